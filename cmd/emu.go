@@ -1,41 +1,189 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/voytas/z80-go-zx/spectrum"
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
 	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
+	"github.com/voytas/z80-go-zx/spectrum/window"
 )
 
 var Model string
+var Config string
+var SaveFile string
+var SaveAfter int
+var FastLoad bool
+var AutoRun bool
+var WAVThreshold float64
+var RecordFile string
+var AutoStop bool
+var Stereo string
+var Separation float32
+var SampleRate int
+var AudioFile string
+var AYFile string
+var YMVersion int
+var TurboSound bool
+var Specdrum bool
+var Covox bool
+var Joystick string
+var JoystickKeys string
+var Debug bool
+var GDBPort int
 
 var emuCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
-	Use:   "emu -m 48k|128k [snapshot.(sna|szx)]",
+	Use:   "emu -m 16k|48k|128k|+2|+2a|+3|pentagon | -c config.(yaml|json|toml) [file.(sna|szx|z80|tap|tzx|csw|pzx|wav|dsk)]",
 	Short: "Run ZX Spectrum emulator",
 	Long: `
-		Run ZX Spectrum emulator. You can optionally specify snapshot file to load,
-		only SNA & SZX formats are supported.
+		Run ZX Spectrum emulator. You can optionally specify snapshot or tape file
+		to load, SNA, SZX & Z80 snapshots and TAP, TZX, CSW, PZX & WAV tapes are supported.
 
-		Supported models are 48k and 128k`,
-	Run: func(cmd *cobra.Command, args []string) {
+		Press F2 to save the snapshot (SNA, SZX or Z80 format as per --save file
+		extension), or use --save-after to save it after number of frames.
+
+		Tapes are loaded instantly unless --fast-load=false is used, in which case
+		the tape is played in real time. Press F5 to play/stop the tape, F6
+		to rewind it and PageUp/PageDown to move to the previous/next block
+		(see "tape ls"). Use --auto-stop to stop the tape at pause blocks.
+		WAV recordings are always played in real time, use --wav-threshold
+		to adjust the signal detection for noisy recordings.
+
+		The tape is started by typing LOAD "" once the ROM is booted, use
+		--auto-run=false to type the command yourself.
+
+		Press F7 to start/stop recording saved tape blocks to TAP or TZX file
+		(as per --record file extension).
+
+		AY channels are mixed into stereo as per --stereo layout (abc, acb, bac
+		or mono), use --separation to reduce the stereo separation. Use
+		--record-audio to write the audio output to WAV file, it is timed by
+		the emulated time, so the same run gives the same file. Use --record-ay
+		to write AY register writes to PSG or YM file (128k only).
+
+		Optional audio peripherals can be attached: --turbo-sound (second AY,
+		128k only), --specdrum (DAC on port 0xDF) and --covox (DAC on port 0xFB).
+
+		Use --joystick to emulate Kempston (port 0x1F), Sinclair 1/2 (keys 6-0
+		and 1-5) or Cursor (keys 5-8 and 0) joystick. It is controlled by the
+		first connected gamepad or the keys specified by --joystick-keys (up,
+		down, left, right and fire, cursor keys and right Ctrl by default).
+
+		DSK disk images (standard and extended) are inserted to the drive A of
+		+3, the disk is saved when the emulator is closed if it was written to.
+
+		Use --debug to control the emulator from the terminal console: pause
+		(also F9), step, step over/out, run to address, show and change
+		registers, flags and memory (bank:offset addresses 128k RAM banks)
+		and disassemble the code around PC. Type h in the console for help.
+
+		Supported models are 16k, 48k, 128k, +2 (grey), +2a, +3 and pentagon
+		(Pentagon 128). The ROMs in spectrum/rom are embedded in the binary
+		when it is built, +2 runs the 128k ROMs. The +2A/+3 ROMs are not
+		distributed with the emulator, +2a and +3 models run only if the ROMs
+		(plus3-0.rom to plus3-3.rom) were copied there before building, or
+		if --config profile specifies their paths.
+
+		Use --config to run the machine defined by YAML, JSON or TOML profile
+		(clock, frame length, contention, ROM files, peripherals and joystick),
+		see spectrum/machine/profiles for the built-in models. The profile can
+		be based on a built-in model (base: 48k) and specify only the changes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var fileName string
 		if len(args) > 0 {
 			fileName = args[0]
 		}
-		var m *machine.Machine
-		switch strings.TrimSpace(Model) {
-		case "48k":
-			m = machine.ZX48k
-		case "128k":
-			m = machine.ZX128k
+		if SampleRate <= 0 {
+			return fmt.Errorf("Invalid sample rate: %d", SampleRate)
+		}
+		stereo, err := sound.ParseStereoMode(Stereo)
+		if err != nil {
+			return err
+		}
+		ayFormat := 0
+		if AYFile != "" {
+			if ayFormat, err = sound.AYFormat(AYFile, YMVersion); err != nil {
+				return err
+			}
+		}
+		m, ok := machine.Models[strings.ToLower(strings.TrimSpace(Model))]
+		if Config != "" {
+			if m, err = machine.LoadConfig(Config); err != nil {
+				return err
+			}
+		} else if !ok {
+			return fmt.Errorf("Model not supported: %s", Model)
+		}
+		if err := m.CheckROMs(); err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("joystick") && m.Joystick != "" {
+			Joystick = m.Joystick
+		}
+		joy, err := joystick.Parse(Joystick)
+		if err != nil {
+			return err
+		}
+		joyKeys, err := window.ParseJoystickKeys(JoystickKeys)
+		if err != nil {
+			return err
 		}
-		spectrum.Run(m, fileName)
+		window.Run(m, &window.Options{
+			FileToLoad:   fileName,
+			SaveFile:     SaveFile,
+			SaveAfter:    SaveAfter,
+			FastLoad:     FastLoad,
+			AutoRun:      AutoRun,
+			WAVThreshold: WAVThreshold,
+			RecordFile:   RecordFile,
+			AutoStop:     AutoStop,
+			Stereo:       stereo,
+			Separation:   Separation,
+			SampleRate:   SampleRate,
+			AudioFile:    AudioFile,
+			AYFile:       AYFile,
+			AYFormat:     ayFormat,
+			Peripherals: bus.Peripherals{
+				TurboSound: TurboSound || m.Peripherals.TurboSound,
+				Specdrum:   Specdrum || m.Peripherals.Specdrum,
+				Covox:      Covox || m.Peripherals.Covox,
+			},
+			Joystick:     joy,
+			JoystickKeys: joyKeys,
+			Debug:        Debug,
+			GDBPort:      GDBPort,
+		})
+		return nil
 	},
 }
 
 func init() {
-	emuCmd.Flags().StringVarP(&Model, "model", "m", "48k", "Model to run: 48k or 128k")
+	emuCmd.Flags().StringVarP(&Model, "model", "m", "48k", "Model to run: 16k, 48k, 128k, +2, +2a, +3 or pentagon (+2a and +3 need the ROMs, see help)")
+	emuCmd.Flags().StringVarP(&Config, "config", "c", "", "Machine profile to run: *.yaml, *.json or *.toml")
+	emuCmd.Flags().StringVarP(&SaveFile, "save", "s", "", "Snapshot file to save: *.sna, *.szx or *.z80")
+	emuCmd.Flags().IntVar(&SaveAfter, "save-after", 0, "Save the snapshot after specified number of frames")
+	emuCmd.Flags().BoolVar(&FastLoad, "fast-load", true, "Load tape blocks instantly using ROM trap")
+	emuCmd.Flags().BoolVar(&AutoRun, "auto-run", true, "Type LOAD \"\" when the tape file is loaded")
+	emuCmd.Flags().Float64Var(&WAVThreshold, "wav-threshold", tape.DefaultWAVThreshold, "Signal threshold for WAV tapes (fraction of the full scale)")
+	emuCmd.Flags().StringVar(&RecordFile, "record", "", "Tape file to record saved blocks to: *.tap or *.tzx")
+	emuCmd.Flags().BoolVar(&AutoStop, "auto-stop", false, "Stop the tape when the pause block is reached")
+	emuCmd.Flags().StringVar(&Stereo, "stereo", "abc", "Layout of the AY channels: abc, acb, bac or mono")
+	emuCmd.Flags().Float32Var(&Separation, "separation", sound.DefaultSeparation, "Stereo separation of the AY channels (0 to 1)")
+	emuCmd.Flags().IntVar(&SampleRate, "sample-rate", sound.DefaultSampleRate, "Audio sample rate: 44100 or 48000")
+	emuCmd.Flags().StringVar(&AudioFile, "record-audio", "", "WAV file to record the audio output to")
+	emuCmd.Flags().StringVar(&AYFile, "record-ay", "", "File to record AY register writes to: *.psg or *.ym")
+	emuCmd.Flags().IntVar(&YMVersion, "ym-version", 6, "Version of the YM file: 5 or 6")
+	emuCmd.Flags().BoolVar(&TurboSound, "turbo-sound", false, "Attach TurboSound (second AY, 128k only)")
+	emuCmd.Flags().BoolVar(&Specdrum, "specdrum", false, "Attach Specdrum (DAC on port 0xDF)")
+	emuCmd.Flags().BoolVar(&Covox, "covox", false, "Attach Covox (DAC on port 0xFB)")
+	emuCmd.Flags().StringVarP(&Joystick, "joystick", "j", "none", "Joystick: kempston, sinclair1, sinclair2, cursor or none")
+	emuCmd.Flags().StringVar(&JoystickKeys, "joystick-keys", window.DefaultJoystickKeys, "Keys emulating the joystick: up,down,left,right,fire")
+	emuCmd.Flags().BoolVar(&Debug, "debug", false, "Run the debugger console in the terminal (F9 pauses/resumes)")
+	emuCmd.Flags().IntVar(&GDBPort, "gdb", 0, "Run GDB remote protocol server on the localhost port, e.g. 1234")
 	rootCmd.AddCommand(emuCmd)
 }
@@ -5,6 +5,8 @@ import (
 	"github.com/voytas/z80-go-zx/exerciser"
 )
 
+var Breakpoints []string
+
 var exerciseCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Use:   "exercise program",
@@ -12,10 +14,11 @@ var exerciseCmd = &cobra.Command{
 	Long: `The program can be for example prelim.com,
 		zexdoc.com or zexall.com`,
 	Run: func(cmd *cobra.Command, args []string) {
-		exerciser.Run(args[0])
+		exerciser.Run(args[0], Breakpoints)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(exerciseCmd)
+	exerciseCmd.Flags().StringArrayVarP(&Breakpoints, "break", "b", nil, `Breakpoint printing the registers when hit, e.g. "1D42 if a==0" or "write 0100-01FF"`)
 }
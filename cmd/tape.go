@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
+)
+
+var tapeCmd = &cobra.Command{
+	Use:   "tape command",
+	Short: "Tape file utilities",
+}
+
+var tapeLsCmd = &cobra.Command{
+	Args:  cobra.ExactArgs(1),
+	Use:   "ls file.(tap|tzx|csw|pzx|wav)",
+	Short: "List blocks on the tape",
+	Long: `
+		List blocks on the tape with their type, flag, length and header
+		(Program, Number array, Character array or Bytes) or TZX metadata.
+		Block numbers can be used to position the tape in the emulator.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t := &tape.Tape{}
+		if err := t.LoadFile(args[0]); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "#\tType\tFlag\tLength\tDescription")
+		for _, b := range t.Blocks() {
+			flag := ""
+			if b.Flag >= 0 {
+				flag = fmt.Sprintf("%02X", b.Flag)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", b.Index, b.Type, flag, b.Length, b.Description)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	tapeCmd.AddCommand(tapeLsCmd)
+	rootCmd.AddCommand(tapeCmd)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"github.com/voytas/z80-go-zx/z80"
+	"github.com/voytas/z80-go-zx/z80/debugger"
 	"github.com/voytas/z80-go-zx/z80/memory"
 
 	_ "embed"
@@ -26,7 +27,9 @@ func (bus *ioBus) Write(hi, lo, data byte) {
 	}
 }
 
-func Run(program string) {
+// Runs the program, the execution stopped at any of the breakpoints (see
+// debugger.ParseBreakpoint for the format) prints the registers and continues
+func Run(program string, breakpoints []string) {
 	fmt.Printf("Running %s\n", program)
 	// Load selected program
 	content, err := ioutil.ReadFile(program)
@@ -50,6 +53,22 @@ func Run(program string) {
 	}
 	z80 := z80.NewZ80(&mem)
 	z80.IOBus = &ioBus{}
-	z80.Run(0)
+	if len(breakpoints) == 0 {
+		z80.Run(0)
+		fmt.Println("")
+		return
+	}
+
+	// The debugger hooks every instruction, so it is used only if needed
+	d := debugger.New(z80, &mem)
+	for _, spec := range breakpoints {
+		if _, err := d.AddBreakpoint(spec); err != nil {
+			log.Fatalf("%s: %v", spec, err)
+		}
+	}
+	for z80.Run(0); z80.Stopped(); z80.Continue() {
+		fmt.Printf("\n%s\n%s\n", z80.Hit(), d.Registers())
+		d.Resume()
+	}
 	fmt.Println("")
 }
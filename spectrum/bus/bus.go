@@ -1,71 +1,232 @@
 package bus
 
 import (
+	"github.com/voytas/z80-go-zx/spectrum/disk"
 	"github.com/voytas/z80-go-zx/spectrum/keyboard"
 	"github.com/voytas/z80-go-zx/spectrum/machine"
 	"github.com/voytas/z80-go-zx/spectrum/memory"
 	"github.com/voytas/z80-go-zx/spectrum/screen"
 	"github.com/voytas/z80-go-zx/spectrum/sound"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
 	"github.com/voytas/z80-go-zx/z80"
 )
 
+// Number of T states from the start of the screen line contention to the
+// first bitmap fetch seen on the data bus
+const floatingBusDelay = 3
+
 type Bus struct {
-	tc      *z80.TCounter
-	beeper  *sound.Beeper
-	ay      *sound.AY8910
-	mem     *memory.Memory
-	machine *machine.Machine
+	tc       *z80.TCounter
+	beeper   *sound.Beeper
+	ay       *ayDevice
+	specdrum *dacDevice // nil if not attached
+	covox    *dacDevice // nil if not attached
+	fdc      *disk.FDC  // nil if not +3
+	mixer    *sound.Mixer
+	mem      *memory.Memory
+	machine  *machine.Machine
+	tape     *tape.Tape
+	keyboard *keyboard.Keyboard
+	devices  []Device // devices attached to the bus
+	decoded  []Device // devices decoding the current port (scratch)
+	ula      byte     // last value written to the ULA port
+	issue2   bool     // keyboard issue 2 (EAR bit affected by MIC output)
 }
 
-func NewBus(machine *machine.Machine, tc *z80.TCounter, mem *memory.Memory) (*Bus, error) {
-	beeper, err := sound.NewBeeper(machine.Clock)
-	if err != nil {
-		return nil, err
+func NewBus(machine *machine.Machine, tc *z80.TCounter, mem *memory.Memory, tape *tape.Tape) *Bus {
+	b := &Bus{
+		beeper:   sound.NewBeeper(),
+		mem:      mem,
+		tc:       tc,
+		machine:  machine,
+		tape:     tape,
+		keyboard: keyboard.New(),
+	}
+	b.ay = newAYDevice(b)
+	b.mixer = sound.NewMixer(machine.Clock, sound.DefaultSampleRate, b.beeper)
+
+	b.Attach(&ulaDevice{b: b})
+	// Only 128k (and +2A/+3) has memory paging and the AY chip
+	if mem.Is128k() {
+		b.Attach(&pagingDevice{b: b})
+		b.Attach(b.ay)
+		b.mixer.AddAY(b.ay.chips[0])
+	}
+	if machine.Plus3 {
+		b.Attach(&plus3Device{b: b})
 	}
+	if machine.FDC {
+		b.fdc = disk.NewFDC()
+		b.Attach(&fdcDevice{b: b})
+	}
+	return b
+}
+
+// Optional audio peripherals
+type Peripherals struct {
+	TurboSound bool // second AY (128k only), chips are selected by writing 0xFF/0xFE to port 0xFFFD
+	Specdrum   bool // 8-bit DAC on port 0xDF
+	Covox      bool // 8-bit DAC on port 0xFB
+}
+
+// Attaches the audio peripherals, their outputs are mixed with the beeper and AY
+func (b *Bus) AttachPeripherals(p Peripherals) {
+	if p.TurboSound && b.mem.Is128k() && len(b.ay.chips) == 1 {
+		ay := sound.NewAY8910()
+		b.ay.chips = append(b.ay.chips, ay)
+		b.mixer.AddAY(ay)
+	}
+	if p.Specdrum && b.specdrum == nil {
+		b.specdrum = &dacDevice{b: b, dac: sound.NewDAC(), port: 0xDF}
+		b.mixer.AddDAC(b.specdrum.dac)
+		b.Attach(b.specdrum)
+	}
+	if p.Covox && b.covox == nil {
+		b.covox = &dacDevice{b: b, dac: sound.NewDAC(), port: 0xFB}
+		b.mixer.AddDAC(b.covox.dac)
+		b.Attach(b.covox)
+	}
+}
+
+// Returns the beeper attached to the ULA
+func (b *Bus) Beeper() *sound.Beeper {
+	return b.beeper
+}
+
+// Returns the AY sound chip (128k), the first chip if TurboSound is attached
+func (b *Bus) AY() *sound.AY8910 {
+	return b.ay.chips[0]
+}
+
+// Sets the recorder of AY register writes, nil stops recording
+func (b *Bus) SetAYRecorder(recorder *sound.AYRecorder) {
+	b.ay.recorder = recorder
+}
+
+// Returns the floppy disk controller (+3), nil if there is none
+func (b *Bus) FDC() *disk.FDC {
+	return b.fdc
+}
+
+// Returns the mixer of the beeper and AY outputs
+func (b *Bus) Mixer() *sound.Mixer {
+	return b.mixer
+}
+
+// Returns the keyboard read by the ULA port
+func (b *Bus) Keyboard() *keyboard.Keyboard {
+	return b.keyboard
+}
+
+// Returns the last value written to the ULA port
+func (b *Bus) ULA() byte {
+	return b.ula
+}
+
+// Restores the last value written to the ULA port, e.g. when loading snapshot
+func (b *Bus) SetULA(data byte) {
+	b.ula = data
+	screen.BorderColour(data, b.tc.Current)
+}
 
-	return &Bus{
-		beeper:  beeper,
-		ay:      sound.NewAY8910(),
-		mem:     mem,
-		tc:      tc,
-		machine: machine,
-	}, nil
+// Checks whether keyboard issue 2 is emulated
+func (b *Bus) Issue2() bool {
+	return b.issue2
 }
 
+// Sets keyboard issue 2 or 3 emulation, it affects EAR bit value
+func (b *Bus) SetIssue2(issue2 bool) {
+	b.issue2 = issue2
+}
+
+// Reads the port, if no device drives the data bus the value is the byte
+// the ULA is currently fetching (floating bus)
 func (b *Bus) Read(hi, lo byte) byte {
-	b.addContention(hi, lo)
-	if lo == 0xFE {
-		return keyboard.GetKeyPortValue(hi)
+	port := uint16(hi)<<8 | uint16(lo)
+	devices := b.decode(port)
+	b.addContention(hi, lo, devices)
+
+	data, driven := byte(0xFF), false
+	for _, d := range devices {
+		if v, ok := d.Read(port); ok {
+			data &= v
+			driven = true
+		}
+	}
+	if !driven {
+		return b.floatingBus()
+	}
+	return data
+}
+
+// Returns the byte the ULA is fetching from the screen memory when the port
+// is read, 0xFF when it is idle (border and retrace). In each 8 T states of
+// the screen line the ULA fetches bitmap, attribute, bitmap and attribute of
+// two adjacent columns and then it is idle for 4 T states. +2A/+3 gate array
+// and Pentagon do not leave the bus floating, they always read 0xFF.
+func (b *Bus) floatingBus() byte {
+	if !b.machine.FloatingBus {
+		return 0xFF
+	}
+	// The data bus is sampled in the last T state of the I/O cycle
+	t := b.tc.Current - 1 - (b.machine.ScreenStart + floatingBusDelay)
+	if t < 0 {
+		return 0xFF
+	}
+	line, x := t/b.machine.LineStates, t%b.machine.LineStates
+	if line >= 192 || x >= 128 || x%8 >= 4 {
+		return 0xFF
 	}
-	return 0xFF
+
+	bitmap, attr := screen.Address(line, x/8*2+x%8/2)
+	if x%2 == 0 {
+		return b.mem.Screen[bitmap-0x4000]
+	}
+	return b.mem.Screen[attr-0x4000]
 }
 
+// Writes the port to all devices decoding it
 func (b *Bus) Write(hi, lo, data byte) {
-	b.addContention(hi, lo)
-	if hi&0x80 == 0 && lo&0x02 == 0 {
-		// Memory page select 128k (port 0x7FFD is decoded as: A15=0, A1=0
-		b.mem.PageMode(data)
-	} else if hi&0xC0 == 0xC0 && lo&0x02 == 0x00 {
-		// AY register select (port 0xFFFD is decoded as: A15=1, A14=1, A1=0
-		b.ay.SelectReg(data & 0x0F)
-	} else if hi&0x80 == 0x80 && lo&0x02 == 0x00 {
-		// AY write data (port 0xBFFD is decoded as: A15=1, A1=0
-		b.ay.WriteReg(data, b.tc.Total)
-	} else if lo&0x01 == 0 {
-		// ULA (port 0xFE is decoded as: A0=0)
-		screen.BorderColour(data, b.tc.Current)
-		b.beeper.Beep(data, b.tc.Total)
-	}
-}
-
-func (b *Bus) addContention(hi, lo byte) {
-	if b.tc.Current >= len(b.machine.ContentionTable) {
+	port := uint16(hi)<<8 | uint16(lo)
+	devices := b.decode(port)
+	b.addContention(hi, lo, devices)
+
+	for _, d := range devices {
+		d.Write(port, data)
+	}
+}
+
+// Returns EAR bit (bit 6) value as read from the ULA port. When the tape is
+// playing it is the tape signal, otherwise it follows EAR output (issue 3)
+// or EAR and MIC outputs (issue 2).
+func (b *Bus) ear() byte {
+	if b.tape.IsPlaying() {
+		return b.tape.EAR(b.tc.Total)
+	}
+	mask := byte(0x10)
+	if b.issue2 {
+		mask = 0x18
+	}
+	if b.ula&mask != 0 {
+		return 0x40
+	}
+	return 0x00
+}
+
+func (b *Bus) addContention(hi, lo byte, devices []Device) {
+	for _, d := range devices {
+		b.tc.Add(d.WaitStates())
+	}
+
+	// +2A/+3 I/O is not contended
+	if b.machine.Plus3 || b.tc.Current >= len(b.machine.ContentionTable) {
 		b.tc.Add(4) // no contended, just 4 T states
 		return
 	}
 
+	// Past the end of the table (the cycle crosses it) there is no contention
 	getContention := func() int {
-		if len(b.machine.ContentionTable) < b.tc.Current {
+		if b.tc.Current < len(b.machine.ContentionTable) {
 			return int(b.machine.ContentionTable[b.tc.Current])
 		}
 		return 0
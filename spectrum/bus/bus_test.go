@@ -0,0 +1,276 @@
+package bus
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
+	"github.com/voytas/z80-go-zx/spectrum/keyboard"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/memory"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+func newTestBus(t *testing.T, is128k bool) *Bus {
+	var mem *memory.Memory
+	var err error
+	m := machine.ZX48k
+	if is128k {
+		m = machine.ZX128k
+		mem, err = memory.NewMem128k("../rom/128-0.rom", "../rom/128-1.rom")
+	} else {
+		mem, err = memory.NewMem48k("../rom/48.rom")
+	}
+	assert.Nil(t, err)
+	cpu := z80.NewZ80(mem)
+	mem.TC = cpu.TC
+	return NewBus(m, cpu.TC, mem, &tape.Tape{})
+}
+
+func Test_TurboSound(t *testing.T) {
+	b := newTestBus(t, true)
+	b.AttachPeripherals(Peripherals{TurboSound: true})
+	assert.Equal(t, 2, len(b.ay.chips))
+	ay, ay2 := b.ay.chips[0], b.ay.chips[1]
+
+	// Second AY selected
+	b.Write(0xFF, 0xFD, 0xFE)
+	b.Write(0xFF, 0xFD, 0x08)
+	b.Write(0xBF, 0xFD, 0x0C)
+	assert.Equal(t, byte(0x0C), b.Read(0xFF, 0xFD))
+	assert.Equal(t, byte(0x0C), ay2.Regs()[8])
+	assert.Equal(t, byte(0x00), ay.Regs()[8])
+
+	// First AY selected
+	b.Write(0xFF, 0xFD, 0xFF)
+	b.Write(0xFF, 0xFD, 0x08)
+	b.Write(0xBF, 0xFD, 0x0A)
+	assert.Equal(t, byte(0x0A), b.Read(0xFF, 0xFD))
+	assert.Equal(t, byte(0x0A), ay.Regs()[8])
+	assert.Equal(t, byte(0x0C), ay2.Regs()[8])
+
+	// Without TurboSound chip select is the register select
+	b = newTestBus(t, true)
+	b.Write(0xFF, 0xFD, 0xFE)
+	assert.Equal(t, byte(0x0E), b.AY().SelectedReg())
+
+	// No AY on 48k
+	b = newTestBus(t, false)
+	b.AttachPeripherals(Peripherals{TurboSound: true})
+	assert.Equal(t, 1, len(b.ay.chips))
+	assert.Equal(t, byte(0xFF), b.Read(0xFF, 0xFD))
+}
+
+func Test_DAC(t *testing.T) {
+	b := newTestBus(t, false)
+	b.Write(0x00, 0xDF, 0xFF)
+	b.Write(0x00, 0xFB, 0xFF)
+	samples := b.Mixer().Mix(70000)
+	assert.Equal(t, int16(0), samples[len(samples)-1])
+
+	b.AttachPeripherals(Peripherals{Specdrum: true, Covox: true})
+	b.Write(0x00, 0xDF, 0xFF)
+	samples = b.Mixer().Mix(140000)
+	level := samples[len(samples)-1]
+	assert.True(t, level > 7000, "Specdrum output expected")
+
+	b.Write(0x00, 0xFB, 0xFF)
+	samples = b.Mixer().Mix(210000)
+	assert.True(t, samples[len(samples)-1] > level, "Covox output expected")
+	// DAC port writes do not affect the ULA
+	assert.Equal(t, byte(0x00), b.ULA())
+}
+
+// Device answering port 0x1F with the specified value
+type testDevice struct {
+	value  byte
+	writes []byte
+	frames int
+	resets int
+	wait   int
+}
+
+func (d *testDevice) Decode() (uint16, uint16) {
+	return 0x00FF, 0x001F
+}
+
+func (d *testDevice) Read(port uint16) (byte, bool) {
+	return d.value, true
+}
+
+func (d *testDevice) Write(port uint16, data byte) {
+	d.writes = append(d.writes, data)
+}
+
+func (d *testDevice) Reset() {
+	d.resets++
+}
+
+func (d *testDevice) EndFrame() {
+	d.frames++
+}
+
+func (d *testDevice) WaitStates() int {
+	return d.wait
+}
+
+func Test_Devices(t *testing.T) {
+	b := newTestBus(t, false)
+	assert.Equal(t, 1, len(b.Devices()))
+	assert.Equal(t, byte(0xFF), b.Read(0x00, 0x1F))
+
+	d1 := &testDevice{value: 0xF0}
+	d2 := &testDevice{value: 0x3F, wait: 2}
+	b.Attach(d1)
+	b.Attach(d2)
+	// Values of all devices answering the port are combined
+	assert.Equal(t, byte(0x30), b.Read(0x12, 0x1F))
+
+	b.Write(0x00, 0x1F, 0x55)
+	b.Write(0x00, 0x3F, 0xAA)
+	assert.Equal(t, []byte{0x55}, d1.writes)
+	assert.Equal(t, []byte{0x55}, d2.writes)
+
+	// Wait states are added to the I/O cycle (uncontended port takes 4 T states)
+	total := b.tc.Total
+	b.Read(0x00, 0x1F)
+	assert.Equal(t, int64(4+2), b.tc.Total-total)
+
+	b.EndFrame()
+	b.Reset()
+	assert.Equal(t, 1, d1.frames)
+	assert.Equal(t, 1, d2.resets)
+
+	b.Detach(d2)
+	assert.Equal(t, byte(0xF0), b.Read(0x12, 0x1F))
+	assert.Equal(t, 2, len(b.Devices()))
+}
+
+func Test_Joystick(t *testing.T) {
+	b := newTestBus(t, false)
+	kempston := joystick.New(joystick.Kempston)
+	b.Attach(kempston)
+	kempston.Press(joystick.Left | joystick.Fire)
+	assert.Equal(t, byte(0x12), b.Read(0x00, 0x1F))
+	b.Detach(kempston)
+	assert.Equal(t, byte(0xFF), b.Read(0x00, 0x1F))
+
+	// Sinclair joystick keys are combined with the keyboard
+	sinclair := joystick.New(joystick.Sinclair2)
+	b.Attach(sinclair)
+	b.Keyboard().Press(keyboard.KEY_1)
+	sinclair.Press(joystick.Fire)
+	assert.Equal(t, byte(0xEE), b.Read(0xF7, 0xFE)|0x40)
+	assert.Equal(t, byte(0xFF), b.Read(0xEF, 0xFE)|0x40)
+}
+
+func Test_FloatingBus(t *testing.T) {
+	for _, is128k := range []bool{false, true} {
+		b := newTestBus(t, is128k)
+		scr := b.mem.Screen
+		scr[0x0000], scr[0x1800] = 0xAA, 0x38 // line 0, column 0
+		scr[0x0001], scr[0x1801] = 0x55, 0x47 // line 0, column 1
+		scr[0x0102], scr[0x1802] = 0x81, 0x07 // line 1, column 2
+
+		// Unattached port 0xFF is not contended, the I/O cycle takes 4 T states
+		start := b.machine.ScreenStart + floatingBusDelay - 3
+		tests := []struct {
+			t     int
+			value byte
+		}{
+			{start - 1, 0xFF},
+			{start, 0xAA},
+			{start + 1, 0x38},
+			{start + 2, 0x55},
+			{start + 3, 0x47},
+			{start + 4, 0xFF},
+			{start + 7, 0xFF},
+			{start + b.machine.LineStates + 8, 0x81},
+			{start + b.machine.LineStates + 9, 0x07},
+			{start + 128, 0xFF},
+			{start + 192*b.machine.LineStates, 0xFF},
+		}
+		for _, test := range tests {
+			b.tc.Current = test.t
+			assert.Equal(t, test.value, b.Read(0x00, 0xFF), "%v %d", is128k, test.t)
+		}
+
+		// Port driven by the device is not affected
+		b.tc.Current = start
+		assert.Equal(t, byte(0xBF), b.Read(0x00, 0xFE))
+	}
+}
+
+func Test_PortContention(t *testing.T) {
+	b := newTestBus(t, false)
+	start := 14335 // first contended T state of 48k
+	tests := []struct {
+		hi, lo byte
+		states int
+	}{
+		{0x00, 0xFE, 1 + 5 + 3},                         // N:1, C:3
+		{0x40, 0xFE, 6 + 1 + 0 + 3},                     // C:1, C:3
+		{0x40, 0xFF, (6 + 1) + 0 + 1 + (6 + 1) + 0 + 1}, // C:1, C:1, C:1, C:1
+		{0x00, 0xFF, 4},                                 // N:4
+	}
+	for _, test := range tests {
+		b.tc.Current = start
+		b.Read(test.hi, test.lo)
+		assert.Equal(t, test.states, b.tc.Current-start, "%02X%02X", test.hi, test.lo)
+	}
+
+	// Outside of the screen area the I/O cycle takes 4 T states, also when
+	// it starts at the end of the contention table
+	for _, start := range []int{0, len(b.machine.ContentionTable) - 1, len(b.machine.ContentionTable)} {
+		b.tc.Current = start
+		b.Read(0x40, 0xFE)
+		assert.Equal(t, 4, b.tc.Current-start, "%d", start)
+	}
+
+	// Port access does not allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		b.tc.Current = start
+		b.Read(0x40, 0xFF)
+		b.Read(0xFE, 0xFE)
+	})
+	assert.Zero(t, allocs)
+}
+
+func Test_Plus3(t *testing.T) {
+	var roms [4]string
+	for i := range roms {
+		roms[i] = filepath.Join(t.TempDir(), "plus3.rom")
+		assert.Nil(t, ioutil.WriteFile(roms[i], make([]byte, 0x4000), 0644))
+	}
+	mem, err := memory.NewMemPlus3(roms)
+	assert.Nil(t, err)
+	cpu := z80.NewZ80(mem)
+	mem.TC = cpu.TC
+	b := NewBus(machine.ZXPlus3, cpu.TC, mem, &tape.Tape{})
+
+	// Port 0x1FFD: special paging and disk motor
+	b.Write(0x1F, 0xFD, 0x09)
+	assert.Equal(t, byte(0x09), mem.PagingModePlus3())
+	assert.True(t, b.FDC().Motor())
+
+	// Port 0x7FFD requires A14=1, 0x3FFD is the FDC data register
+	b.Write(0x3F, 0xFD, 0x08)
+	assert.Equal(t, byte(0x00), mem.PagingMode())
+	assert.Equal(t, byte(0xD0), b.Read(0x2F, 0xFD))
+	assert.Equal(t, byte(0x80), b.Read(0x3F, 0xFD))
+	assert.Equal(t, byte(0x80), b.Read(0x2F, 0xFD))
+	b.Write(0x7F, 0xFD, 0x07)
+	assert.Equal(t, byte(0x07), mem.PagingMode())
+
+	// No floating bus and no I/O contention
+	b.tc.Current = b.machine.ScreenStart + 4
+	assert.Equal(t, byte(0xFF), b.Read(0x40, 0xFF))
+	assert.Equal(t, b.machine.ScreenStart+8, b.tc.Current)
+
+	b.Reset()
+	assert.Equal(t, byte(0x00), mem.PagingModePlus3())
+	assert.False(t, b.FDC().Motor())
+}
@@ -0,0 +1,67 @@
+package bus
+
+// Device attached to the I/O bus, e.g. ULA, AY or joystick interface
+type Device interface {
+	// Returns the port address decoding, the device handles the port
+	// if port & mask == match
+	Decode() (mask, match uint16)
+	// Reads the port, returns false if the device does not drive the data bus
+	Read(port uint16) (byte, bool)
+	// Writes the port
+	Write(port uint16, data byte)
+	// Resets the device to its power on state
+	Reset()
+	// Called at the end of each frame (on the interrupt)
+	EndFrame()
+	// Returns the number of extra T states the device adds to the I/O cycle
+	// (contention hint), 0 if the device is as fast as the ULA
+	WaitStates() int
+}
+
+// Attaches the device to the bus. If several devices decode the same port,
+// all of them are written in the order they were attached and the values
+// they read are combined using AND (as the data bus is pulled low).
+func (b *Bus) Attach(device Device) {
+	b.devices = append(b.devices, device)
+}
+
+// Detaches the device from the bus
+func (b *Bus) Detach(device Device) {
+	for i, d := range b.devices {
+		if d == device {
+			b.devices = append(b.devices[:i], b.devices[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the devices attached to the bus in the order they were attached
+func (b *Bus) Devices() []Device {
+	return b.devices
+}
+
+// Resets all attached devices
+func (b *Bus) Reset() {
+	for _, d := range b.devices {
+		d.Reset()
+	}
+}
+
+// Notifies all attached devices the frame is finished
+func (b *Bus) EndFrame() {
+	for _, d := range b.devices {
+		d.EndFrame()
+	}
+}
+
+// Returns the devices decoding the port, the slice is reused by the next
+// port access, so the I/O does not allocate
+func (b *Bus) decode(port uint16) []Device {
+	b.decoded = b.decoded[:0]
+	for _, d := range b.devices {
+		if mask, match := d.Decode(); port&mask == match {
+			b.decoded = append(b.decoded, d)
+		}
+	}
+	return b.decoded
+}
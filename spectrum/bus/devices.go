@@ -0,0 +1,221 @@
+package bus
+
+import (
+	"github.com/voytas/z80-go-zx/spectrum/screen"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
+)
+
+// ULA port 0xFE (decoded as: A0=0, Pentagon: A0-A7=0xFE): keyboard and EAR
+// input, border, beeper and MIC output
+type ulaDevice struct {
+	b *Bus
+}
+
+func (d *ulaDevice) Decode() (uint16, uint16) {
+	return d.b.machine.ULAMask, 0x00FE & d.b.machine.ULAMask
+}
+
+func (d *ulaDevice) Read(port uint16) (byte, bool) {
+	return d.b.keyboard.GetKeyPortValue(byte(port>>8))&0xBF | d.b.ear(), true
+}
+
+func (d *ulaDevice) Write(port uint16, data byte) {
+	d.b.ula = data
+	screen.BorderColour(data, d.b.tc.Current)
+	d.b.beeper.Beep(data, d.b.tc.Total)
+	d.b.tape.MIC(data, d.b.tc.Total)
+}
+
+func (d *ulaDevice) Reset() {
+	d.b.ula = 0
+}
+
+func (d *ulaDevice) EndFrame() {}
+
+func (d *ulaDevice) WaitStates() int {
+	return 0
+}
+
+// Memory paging port 0x7FFD (128k, decoded as: A15=0, A1=0; +2A/+3 decoded
+// as: A15=0, A14=1, A1=0)
+type pagingDevice struct {
+	b *Bus
+}
+
+func (d *pagingDevice) Decode() (uint16, uint16) {
+	if d.b.machine.Plus3 {
+		return 0xC002, 0x4000
+	}
+	return 0x8002, 0x0000
+}
+
+func (d *pagingDevice) Read(port uint16) (byte, bool) {
+	return 0xFF, false
+}
+
+func (d *pagingDevice) Write(port uint16, data byte) {
+	d.b.mem.PageMode(data)
+}
+
+func (d *pagingDevice) Reset() {
+	d.b.mem.ResetPaging()
+}
+
+func (d *pagingDevice) EndFrame() {}
+
+func (d *pagingDevice) WaitStates() int {
+	return 0
+}
+
+// +2A/+3 memory paging, disk motor and printer strobe port 0x1FFD
+// (decoded as: A15=0, A14=0, A13=0, A12=1, A1=0)
+type plus3Device struct {
+	b *Bus
+}
+
+func (d *plus3Device) Decode() (uint16, uint16) {
+	return 0xF002, 0x1000
+}
+
+func (d *plus3Device) Read(port uint16) (byte, bool) {
+	return 0xFF, false
+}
+
+func (d *plus3Device) Write(port uint16, data byte) {
+	d.b.mem.PageModePlus3(data)
+	if d.b.fdc != nil {
+		d.b.fdc.SetMotor(data&0x08 != 0)
+	}
+}
+
+// Paging is reset by the paging device
+func (d *plus3Device) Reset() {}
+
+func (d *plus3Device) EndFrame() {}
+
+func (d *plus3Device) WaitStates() int {
+	return 0
+}
+
+// +3 floppy disk controller ports 0x2FFD (main status register, decoded as:
+// A15=0, A14=0, A13=1, A12=0, A1=0) and 0x3FFD (data register, decoded as:
+// A15=0, A14=0, A13=1, A12=1, A1=0)
+type fdcDevice struct {
+	b *Bus
+}
+
+func (d *fdcDevice) Decode() (uint16, uint16) {
+	return 0xE002, 0x2000
+}
+
+func (d *fdcDevice) Read(port uint16) (byte, bool) {
+	if port&0x1000 == 0 {
+		return d.b.fdc.Status(), true
+	}
+	return d.b.fdc.ReadData(), true
+}
+
+func (d *fdcDevice) Write(port uint16, data byte) {
+	if port&0x1000 != 0 {
+		d.b.fdc.WriteData(data)
+	}
+}
+
+func (d *fdcDevice) Reset() {
+	d.b.fdc.Reset()
+}
+
+func (d *fdcDevice) EndFrame() {}
+
+func (d *fdcDevice) WaitStates() int {
+	return 0
+}
+
+// AY ports 0xFFFD (register select and read, decoded as: A15=1, A14=1, A1=0)
+// and 0xBFFD (register write, decoded as: A15=1, A14=0, A1=0). With TurboSound
+// the chips are selected by writing 0xFF (first) or 0xFE (second) to 0xFFFD.
+type ayDevice struct {
+	b        *Bus
+	chips    []*sound.AY8910
+	selected *sound.AY8910
+	recorder *sound.AYRecorder // records writes of the first chip, nil if not recording
+}
+
+func newAYDevice(b *Bus) *ayDevice {
+	ay := sound.NewAY8910()
+	return &ayDevice{b: b, chips: []*sound.AY8910{ay}, selected: ay}
+}
+
+func (d *ayDevice) Decode() (uint16, uint16) {
+	return 0x8002, 0x8000
+}
+
+func (d *ayDevice) Read(port uint16) (byte, bool) {
+	if port&0x4000 == 0 {
+		return 0xFF, false
+	}
+	return d.selected.ReadReg(), true
+}
+
+func (d *ayDevice) Write(port uint16, data byte) {
+	if port&0x4000 != 0 {
+		if len(d.chips) > 1 && data >= 0xFE {
+			d.selected = d.chips[0xFF-data]
+		} else {
+			d.selected.SelectReg(data & 0x0F)
+		}
+		return
+	}
+
+	d.selected.WriteReg(data, d.b.tc.Total)
+	if d.recorder != nil && d.selected == d.chips[0] {
+		d.recorder.Write(d.selected.SelectedReg(), data)
+	}
+}
+
+func (d *ayDevice) Reset() {
+	for _, ay := range d.chips {
+		ay.Update(d.b.tc.Total)
+		ay.SetRegs(0, [16]byte{})
+	}
+	d.selected = d.chips[0]
+}
+
+func (d *ayDevice) EndFrame() {
+	if d.recorder != nil {
+		d.recorder.EndFrame()
+	}
+}
+
+func (d *ayDevice) WaitStates() int {
+	return 0
+}
+
+// 8-bit DAC, e.g. Specdrum on port 0xDF or Covox on port 0xFB (A0-A7 decoded)
+type dacDevice struct {
+	b    *Bus
+	dac  *sound.DAC
+	port byte
+}
+
+func (d *dacDevice) Decode() (uint16, uint16) {
+	return 0x00FF, uint16(d.port)
+}
+
+func (d *dacDevice) Read(port uint16) (byte, bool) {
+	return 0xFF, false
+}
+
+func (d *dacDevice) Write(port uint16, data byte) {
+	d.dac.Write(data, d.b.tc.Total)
+}
+
+func (d *dacDevice) Reset() {
+	d.dac.Reset(d.b.tc.Total)
+}
+
+func (d *dacDevice) EndFrame() {}
+
+func (d *dacDevice) WaitStates() int {
+	return 0
+}
@@ -0,0 +1,194 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	dskHeaderSize   = 0x100 // disk and track information block size
+	dskSectorInfo   = 0x18  // offset of the sector information list in the track block
+	dskStandardSig  = "MV - CPC"
+	dskExtendedSig  = "EXTENDED CPC DSK File"
+	dskTrackInfoSig = "Track-Info"
+)
+
+// Sector of the disk track, the data is a slice of the image, so writing
+// the sector updates the image
+type Sector struct {
+	C, H, R, N byte   // sector ID: cylinder, head, record (sector number) and size
+	ST1, ST2   byte   // FDC status registers recorded in the image (errors)
+	Data       []byte // sector data
+}
+
+// Track of the disk, it can be unformatted (no sectors)
+type Track struct {
+	Sectors []*Sector
+	Filler  byte // filler byte used when formatting
+}
+
+// Disk image in standard or extended CPC DSK format
+type Disk struct {
+	file      string
+	data      []byte     // disk image
+	sides     int        // number of sides (1 or 2)
+	tracks    [][]*Track // tracks of each side
+	protected bool       // write protected
+	modified  bool       // disk has been written to
+}
+
+// Loads DSK file (standard or extended)
+func LoadDSK(file string) (*Disk, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseDSK(data)
+	if err != nil {
+		return nil, err
+	}
+	d.file = file
+	return d, nil
+}
+
+// Parses DSK image data (standard or extended)
+func ParseDSK(data []byte) (*Disk, error) {
+	if len(data) < dskHeaderSize {
+		return nil, errors.New("Not a valid DSK file")
+	}
+
+	extended := strings.HasPrefix(string(data), dskExtendedSig)
+	if !extended && !strings.HasPrefix(string(data), dskStandardSig) {
+		return nil, errors.New("Not a valid DSK file")
+	}
+
+	d := &Disk{data: data, sides: int(data[0x31])}
+	numTracks := int(data[0x30])
+	if d.sides < 1 || d.sides > 2 || numTracks*d.sides > dskHeaderSize-0x34 {
+		return nil, errors.New("DSK file geometry is not supported")
+	}
+
+	d.tracks = make([][]*Track, d.sides)
+	offset := dskHeaderSize
+	for t := 0; t < numTracks; t++ {
+		for side := 0; side < d.sides; side++ {
+			var size int
+			if extended {
+				size = int(data[0x34+t*d.sides+side]) << 8
+			} else {
+				size = int(data[0x32]) | int(data[0x33])<<8
+			}
+
+			track := &Track{}
+			if size > 0 {
+				if offset+size > len(data) {
+					return nil, fmt.Errorf("DSK file track %d is truncated", t)
+				}
+				if err := track.parse(data[offset:offset+size], extended); err != nil {
+					return nil, fmt.Errorf("DSK file track %d: %v", t, err)
+				}
+			}
+			d.tracks[side] = append(d.tracks[side], track)
+			offset += size
+		}
+	}
+
+	return d, nil
+}
+
+// Parses the track information block and the sectors data
+func (t *Track) parse(data []byte, extended bool) error {
+	if len(data) < dskHeaderSize || !strings.HasPrefix(string(data), dskTrackInfoSig) {
+		return errors.New("Track information block is invalid")
+	}
+
+	count := int(data[0x15])
+	if dskSectorInfo+8*count > dskHeaderSize {
+		return errors.New("Too many sectors")
+	}
+	t.Filler = data[0x17]
+
+	offset := dskHeaderSize
+	for i := 0; i < count; i++ {
+		info := data[dskSectorInfo+8*i:]
+		s := &Sector{C: info[0], H: info[1], R: info[2], N: info[3], ST1: info[4], ST2: info[5]}
+
+		var size int
+		if extended {
+			size = int(info[6]) | int(info[7])<<8
+		} else {
+			size = sectorSize(data[0x14])
+		}
+		if offset+size > len(data) {
+			return errors.New("Sector data is truncated")
+		}
+		s.Data = data[offset : offset+size]
+		offset += size
+
+		t.Sectors = append(t.Sectors, s)
+	}
+
+	return nil
+}
+
+// Returns the number of sides
+func (d *Disk) Sides() int {
+	return d.sides
+}
+
+// Returns the number of tracks (cylinders)
+func (d *Disk) Tracks() int {
+	return len(d.tracks[0])
+}
+
+// Returns the track of the side, nil if it does not exist
+func (d *Disk) Track(track, side int) *Track {
+	if side >= d.sides || track < 0 || track >= len(d.tracks[side]) {
+		return nil
+	}
+	return d.tracks[side][track]
+}
+
+// Checks whether the disk is write protected
+func (d *Disk) IsProtected() bool {
+	return d.protected
+}
+
+// Sets the write protection
+func (d *Disk) SetProtected(protected bool) {
+	d.protected = protected
+}
+
+// Checks whether the disk has been written to since it was loaded or saved
+func (d *Disk) IsModified() bool {
+	return d.modified
+}
+
+// Saves the image to the file it was loaded from
+func (d *Disk) Save() error {
+	if d.file == "" {
+		return errors.New("DSK file name not specified")
+	}
+	return d.SaveAs(d.file)
+}
+
+// Saves the image to the file
+func (d *Disk) SaveAs(file string) error {
+	if err := ioutil.WriteFile(file, d.data, 0644); err != nil {
+		return err
+	}
+	d.file = file
+	d.modified = false
+	return nil
+}
+
+// Returns the sector data size for the size code N
+func sectorSize(n byte) int {
+	if n > 6 {
+		// Maximum size the 8k track can hold
+		n = 6
+	}
+	return 128 << n
+}
@@ -0,0 +1,84 @@
+package disk
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates +3 format image: single side, 9 sectors (1-9) of 512 bytes per
+// track, each sector filled with the track number and sector number
+func newTestDSK(tracks int, extended bool) []byte {
+	trackSize := 0x100 + 9*512
+	data := make([]byte, 0x100, 0x100+tracks*trackSize)
+	if extended {
+		copy(data, "EXTENDED CPC DSK File\r\nDisk-Info\r\n")
+		for t := 0; t < tracks; t++ {
+			data[0x34+t] = byte(trackSize >> 8)
+		}
+	} else {
+		copy(data, "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+		data[0x32], data[0x33] = byte(trackSize), byte(trackSize>>8)
+	}
+	data[0x30], data[0x31] = byte(tracks), 1
+
+	for t := 0; t < tracks; t++ {
+		track := make([]byte, trackSize)
+		copy(track, "Track-Info\r\n")
+		track[0x10], track[0x14], track[0x15], track[0x17] = byte(t), 2, 9, 0xE5
+		for s := 0; s < 9; s++ {
+			info := track[0x18+8*s:]
+			info[0], info[1], info[2], info[3] = byte(t), 0, byte(s+1), 2
+			if extended {
+				info[6], info[7] = 0x00, 0x02
+			}
+			for i := 0; i < 512; i++ {
+				track[0x100+512*s+i] = byte(t<<4 | (s + 1))
+			}
+		}
+		data = append(data, track...)
+	}
+	return data
+}
+
+func Test_ParseDSK(t *testing.T) {
+	for _, extended := range []bool{false, true} {
+		d, err := ParseDSK(newTestDSK(3, extended))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, d.Sides())
+		assert.Equal(t, 3, d.Tracks())
+
+		track := d.Track(2, 0)
+		assert.Equal(t, 9, len(track.Sectors))
+		assert.Equal(t, byte(0xE5), track.Filler)
+		s := track.Sectors[4]
+		assert.Equal(t, []byte{2, 0, 5, 2}, []byte{s.C, s.H, s.R, s.N})
+		assert.Equal(t, 512, len(s.Data))
+		assert.Equal(t, byte(0x25), s.Data[511])
+
+		assert.Nil(t, d.Track(3, 0))
+		assert.Nil(t, d.Track(0, 1))
+	}
+
+	_, err := ParseDSK(make([]byte, 0x100))
+	assert.NotNil(t, err)
+	_, err = ParseDSK(newTestDSK(3, true)[:0x1000])
+	assert.NotNil(t, err)
+}
+
+func Test_SaveDSK(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.dsk")
+	assert.Nil(t, ioutil.WriteFile(file, newTestDSK(2, true), 0644))
+
+	d, err := LoadDSK(file)
+	assert.Nil(t, err)
+	d.Track(1, 0).Sectors[0].Data[0] = 0xAA
+	assert.Nil(t, d.Save())
+
+	d, err = LoadDSK(file)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0xAA), d.Track(1, 0).Sectors[0].Data[0])
+	assert.Equal(t, byte(0x11), d.Track(1, 0).Sectors[0].Data[1])
+}
@@ -0,0 +1,501 @@
+package disk
+
+// Main status register bits
+const (
+	msrRQM = 0x80 // data register is ready
+	msrDIO = 0x40 // data direction: FDC to CPU
+	msrEXM = 0x20 // execution phase (non-DMA mode)
+	msrCB  = 0x10 // FDC is busy, command in progress
+)
+
+// Status registers bits
+const (
+	st0InvalidCommand = 0x80
+	st0Abnormal       = 0x40
+	st0SeekEnd        = 0x20
+	st0NotReady       = 0x08
+	st1EndOfCylinder  = 0x80
+	st1DataError      = 0x20
+	st1NoData         = 0x04
+	st1NotWritable    = 0x02
+	st1MissingAM      = 0x01
+	st2ControlMark    = 0x40
+	st2DataError      = 0x20
+	st2WrongCylinder  = 0x10
+	st2BadCylinder    = 0x02
+	st3WriteProtected = 0x40
+	st3Ready          = 0x20
+	st3Track0         = 0x10
+	st3TwoSide        = 0x08
+)
+
+// Commands (bits 0-4 of the first command byte)
+const (
+	cmdReadTrack      = 0x02
+	cmdSpecify        = 0x03
+	cmdSenseDrive     = 0x04
+	cmdWriteData      = 0x05
+	cmdReadData       = 0x06
+	cmdRecalibrate    = 0x07
+	cmdSenseInterrupt = 0x08
+	cmdWriteDeleted   = 0x09
+	cmdReadID         = 0x0A
+	cmdReadDeleted    = 0x0C
+	cmdFormatTrack    = 0x0D
+	cmdSeek           = 0x0F
+)
+
+// Number of command bytes (including the command) of the supported commands
+var commandLength = map[byte]int{
+	cmdReadTrack:      9,
+	cmdSpecify:        3,
+	cmdSenseDrive:     2,
+	cmdWriteData:      9,
+	cmdReadData:       9,
+	cmdRecalibrate:    2,
+	cmdSenseInterrupt: 1,
+	cmdWriteDeleted:   9,
+	cmdReadID:         2,
+	cmdReadDeleted:    9,
+	cmdFormatTrack:    6,
+	cmdSeek:           3,
+}
+
+// FDC phases
+const (
+	phaseCommand = iota
+	phaseExecution
+	phaseResult
+)
+
+// Floppy disk drive
+type drive struct {
+	disk    *Disk
+	track   int  // cylinder the head is positioned at
+	index   int  // index of the sector passing under the head (Read ID)
+	seekEnd bool // seek or recalibrate finished, interrupt pending
+}
+
+// NEC uPD765A floppy disk controller in non-DMA mode as used by +3. The
+// transfers are not timed, so the data register is always ready. Terminal
+// count is not connected, so read and write commands end at EOT sector
+// with "end of cylinder" error as on the real +3.
+type FDC struct {
+	drives  [2]drive // drive A and B (unit select 1 bit only)
+	motor   bool     // drive motor is on
+	phase   int
+	command []byte // command bytes received
+	result  []byte // result bytes to be read
+	data    []byte // execution phase data
+	pos     int    // position in execution phase data
+
+	// Current read/write command
+	unit, head    int
+	c, h, r, n    byte // sector ID
+	eot, dtl      byte // last sector number and data length (if N is 0)
+	st0, st1, st2 byte
+	sector        *Sector // sector being read or written
+	index         int     // index of the sector (Read Track)
+	deleted, skip bool    // deleted data mark command, skip sectors with unexpected mark
+	dataError     bool    // sector has data error, command ends after it
+}
+
+// Creates a new floppy disk controller with no disks inserted
+func NewFDC() *FDC {
+	return &FDC{}
+}
+
+// Inserts the disk to the drive (0 = A, 1 = B)
+func (f *FDC) Insert(unit int, disk *Disk) {
+	f.drives[unit&1].disk = disk
+	f.drives[unit&1].index = 0
+}
+
+// Ejects the disk from the drive, returns the disk ejected or nil
+func (f *FDC) Eject(unit int) *Disk {
+	d := f.drives[unit&1].disk
+	f.drives[unit&1].disk = nil
+	return d
+}
+
+// Returns the disk in the drive, nil if there is no disk
+func (f *FDC) Disk(unit int) *Disk {
+	return f.drives[unit&1].disk
+}
+
+// Turns the drive motor on or off
+func (f *FDC) SetMotor(on bool) {
+	f.motor = on
+}
+
+// Checks whether the drive motor is on
+func (f *FDC) Motor() bool {
+	return f.motor
+}
+
+// Resets the controller, the command in progress is aborted
+func (f *FDC) Reset() {
+	f.phase = phaseCommand
+	f.command, f.result, f.data = nil, nil, nil
+	f.motor = false
+	for i := range f.drives {
+		f.drives[i].seekEnd = false
+	}
+}
+
+// Returns the main status register value
+func (f *FDC) Status() byte {
+	switch f.phase {
+	case phaseExecution:
+		if f.isRead() {
+			return msrRQM | msrDIO | msrEXM | msrCB
+		}
+		return msrRQM | msrEXM | msrCB
+	case phaseResult:
+		return msrRQM | msrDIO | msrCB
+	}
+	if len(f.command) > 0 {
+		return msrRQM | msrCB
+	}
+	return msrRQM
+}
+
+// Reads the data register: data in the execution phase or result
+func (f *FDC) ReadData() byte {
+	switch f.phase {
+	case phaseExecution:
+		if !f.isRead() {
+			return 0xFF
+		}
+		val := f.data[f.pos]
+		f.pos++
+		if f.pos == len(f.data) {
+			f.nextSector()
+		}
+		return val
+	case phaseResult:
+		val := f.result[0]
+		f.result = f.result[1:]
+		if len(f.result) == 0 {
+			f.phase = phaseCommand
+			f.command = nil
+		}
+		return val
+	}
+	return 0xFF
+}
+
+// Writes the data register: command bytes or data in the execution phase
+func (f *FDC) WriteData(val byte) {
+	switch f.phase {
+	case phaseCommand:
+		f.command = append(f.command, val)
+		length, ok := commandLength[f.command[0]&0x1F]
+		if !ok {
+			f.finish(st0InvalidCommand)
+			return
+		}
+		if len(f.command) == length {
+			f.execute()
+		}
+	case phaseExecution:
+		if f.isRead() {
+			return
+		}
+		f.data[f.pos] = val
+		f.pos++
+		if f.pos == len(f.data) {
+			if f.command[0]&0x1F == cmdFormatTrack {
+				f.format()
+			} else {
+				f.writeSector()
+			}
+		}
+	}
+}
+
+// Checks whether the command in execution phase transfers data to CPU
+func (f *FDC) isRead() bool {
+	switch f.command[0] & 0x1F {
+	case cmdReadData, cmdReadDeleted, cmdReadTrack:
+		return true
+	}
+	return false
+}
+
+// Executes the command once all command bytes are received
+func (f *FDC) execute() {
+	cmd := f.command[0]
+	if len(f.command) > 1 {
+		f.unit, f.head = int(f.command[1]&0x01), int(f.command[1]>>2&0x01)
+	}
+	drv := &f.drives[f.unit]
+
+	switch cmd & 0x1F {
+	case cmdSpecify:
+		f.phase = phaseCommand
+		f.command = nil
+	case cmdSenseDrive:
+		st3 := byte(f.head<<2 | f.unit)
+		if drv.track == 0 {
+			st3 |= st3Track0
+		}
+		if f.ready() {
+			st3 |= st3Ready
+			if drv.disk.IsProtected() {
+				st3 |= st3WriteProtected
+			}
+			if drv.disk.Sides() > 1 {
+				st3 |= st3TwoSide
+			}
+		}
+		f.finish(st3)
+	case cmdRecalibrate:
+		f.seek(0)
+	case cmdSeek:
+		f.seek(int(f.command[2]))
+	case cmdSenseInterrupt:
+		for i := range f.drives {
+			if f.drives[i].seekEnd {
+				f.drives[i].seekEnd = false
+				st0 := byte(st0SeekEnd | i)
+				if f.drives[i].disk == nil {
+					st0 |= st0Abnormal | st0NotReady
+				}
+				f.finish(st0, byte(f.drives[i].track))
+				return
+			}
+		}
+		f.finish(st0InvalidCommand)
+	case cmdReadID:
+		if !f.ready() {
+			f.finish(f.st0Unit()|st0Abnormal|st0NotReady, 0, 0, 0, 0, 0, 0)
+			return
+		}
+		track := drv.disk.Track(drv.track, f.head)
+		if track == nil || len(track.Sectors) == 0 {
+			f.finish(f.st0Unit()|st0Abnormal, st1MissingAM, 0, 0, 0, 0, 0)
+			return
+		}
+		s := track.Sectors[drv.index%len(track.Sectors)]
+		drv.index++
+		f.finish(f.st0Unit(), 0, 0, s.C, s.H, s.R, s.N)
+	case cmdReadData, cmdReadDeleted, cmdReadTrack, cmdWriteData, cmdWriteDeleted:
+		f.c, f.h, f.r, f.n = f.command[2], f.command[3], f.command[4], f.command[5]
+		f.eot, f.dtl = f.command[6], f.command[8]
+		f.st0, f.st1, f.st2 = f.st0Unit(), 0, 0
+		f.deleted = cmd&0x1F == cmdReadDeleted || cmd&0x1F == cmdWriteDeleted
+		f.skip = cmd&0x20 != 0
+		f.index = 0
+		if !f.ready() {
+			f.st0 |= st0Abnormal | st0NotReady
+			f.finishTransfer()
+			return
+		}
+		if !f.isRead() && drv.disk.IsProtected() {
+			f.st0 |= st0Abnormal
+			f.st1 |= st1NotWritable
+			f.finishTransfer()
+			return
+		}
+		f.startSector()
+	case cmdFormatTrack:
+		f.n = f.command[2]
+		f.st0, f.st1, f.st2 = f.st0Unit(), 0, 0
+		if !f.ready() {
+			f.st0 |= st0Abnormal | st0NotReady
+			f.finishTransfer()
+			return
+		}
+		if drv.disk.IsProtected() {
+			f.st0 |= st0Abnormal
+			f.st1 |= st1NotWritable
+			f.finishTransfer()
+			return
+		}
+		f.phase = phaseExecution
+		f.data, f.pos = make([]byte, 4*int(f.command[3])), 0
+		if len(f.data) == 0 {
+			f.format()
+		}
+	}
+}
+
+// Moves the head to the track, the seek end interrupt is raised
+func (f *FDC) seek(track int) {
+	drv := &f.drives[f.unit]
+	drv.track = track
+	drv.seekEnd = true
+	drv.index = 0
+	f.phase = phaseCommand
+	f.command = nil
+}
+
+// Checks whether the selected drive is ready: disk is inserted and motor is on
+func (f *FDC) ready() bool {
+	return f.drives[f.unit].disk != nil && f.motor
+}
+
+// Returns ST0 with the head and unit of the current command
+func (f *FDC) st0Unit() byte {
+	return byte(f.head<<2 | f.unit)
+}
+
+// Finds the sector with the current ID and starts its transfer
+func (f *FDC) startSector() {
+	drv := &f.drives[f.unit]
+	track := drv.disk.Track(drv.track, f.head)
+	if track == nil || len(track.Sectors) == 0 {
+		f.st0 |= st0Abnormal
+		f.st1 |= st1MissingAM
+		f.finishTransfer()
+		return
+	}
+
+	var sector *Sector
+	if f.command[0]&0x1F == cmdReadTrack {
+		// Sectors are read as they pass under the head, ID is not matched
+		if f.index < len(track.Sectors) {
+			sector = track.Sectors[f.index]
+			f.index++
+			if sector.C != f.c || sector.H != f.h || sector.R != f.r || sector.N != f.n {
+				f.st1 |= st1NoData
+			}
+		}
+	} else {
+		for _, s := range track.Sectors {
+			if s.C == f.c && s.H == f.h && s.R == f.r && s.N == f.n {
+				sector = s
+				break
+			}
+			if s.R == f.r && s.C != f.c {
+				f.st2 |= st2WrongCylinder
+				if s.C == 0xFF {
+					f.st2 |= st2BadCylinder
+				}
+			}
+		}
+	}
+	if sector == nil {
+		f.st0 |= st0Abnormal
+		f.st1 |= st1NoData
+		f.finishTransfer()
+		return
+	}
+	f.st2 &^= st2WrongCylinder | st2BadCylinder
+
+	// Deleted data mark not expected by the command
+	if (sector.ST2&st2ControlMark != 0) != f.deleted && f.isRead() {
+		f.st2 |= st2ControlMark
+		if f.skip {
+			f.sector = sector
+			f.nextSector()
+			return
+		}
+	}
+
+	length := int(f.dtl)
+	if f.n > 0 {
+		length = sectorSize(f.n)
+	}
+	f.sector = sector
+	f.dataError = sector.ST1&st1DataError != 0 || sector.ST2&st2DataError != 0
+	f.phase = phaseExecution
+	f.pos = 0
+	if f.isRead() {
+		if length > len(sector.Data) {
+			length = len(sector.Data)
+		}
+		f.data = sector.Data[:length]
+	} else {
+		f.data = make([]byte, length)
+	}
+	if len(f.data) == 0 {
+		f.nextSector()
+	}
+}
+
+// Stores the data written by CPU to the sector and continues with the next one
+func (f *FDC) writeSector() {
+	copy(f.sector.Data, f.data)
+	if f.deleted {
+		f.sector.ST2 |= st2ControlMark
+	} else {
+		f.sector.ST2 &^= st2ControlMark
+	}
+	f.drives[f.unit].disk.modified = true
+	f.nextSector()
+}
+
+// Continues with the next sector, the command ends when EOT sector is done
+// or an error occurred
+func (f *FDC) nextSector() {
+	if f.dataError {
+		f.st0 |= st0Abnormal
+		f.st1 |= f.sector.ST1 & st1DataError
+		f.st2 |= f.sector.ST2 & st2DataError
+		f.dataError = false
+		f.finishTransfer()
+		return
+	}
+	if f.st2&st2ControlMark != 0 && !f.skip {
+		// Deleted data mark ends the command after the sector
+		f.finishTransfer()
+		return
+	}
+
+	if f.r == f.eot || (f.command[0]&0x1F == cmdReadTrack && int(f.eot) <= f.index) {
+		// Terminal count is not connected on +3
+		f.st0 |= st0Abnormal
+		f.st1 |= st1EndOfCylinder
+		f.c++
+		f.r = 1
+		f.finishTransfer()
+		return
+	}
+	f.r++
+	f.startSector()
+}
+
+// Rewrites the track with the sector IDs written by CPU. As the image layout
+// cannot change, the track must have the same number and size of sectors.
+func (f *FDC) format() {
+	drv := &f.drives[f.unit]
+	track := drv.disk.Track(drv.track, f.head)
+	count := len(f.data) / 4
+
+	valid := track != nil && len(track.Sectors) == count
+	for i := 0; valid && i < count; i++ {
+		valid = len(track.Sectors[i].Data) == sectorSize(f.n)
+	}
+	if !valid {
+		f.st0 |= st0Abnormal
+		f.st1 |= st1MissingAM
+		f.finishTransfer()
+		return
+	}
+
+	filler := f.command[5]
+	for i, s := range track.Sectors {
+		s.C, s.H, s.R, s.N = f.data[4*i], f.data[4*i+1], f.data[4*i+2], f.data[4*i+3]
+		s.ST1, s.ST2 = 0, 0
+		for j := range s.Data {
+			s.Data[j] = filler
+		}
+	}
+	track.Filler = filler
+	drv.disk.modified = true
+	f.finishTransfer()
+}
+
+// Ends read, write or format command with the standard result
+func (f *FDC) finishTransfer() {
+	f.finish(f.st0, f.st1, f.st2, f.c, f.h, f.r, f.n)
+}
+
+// Ends the command, the result bytes are to be read by CPU
+func (f *FDC) finish(result ...byte) {
+	f.phase = phaseResult
+	f.result = result
+	f.data = nil
+}
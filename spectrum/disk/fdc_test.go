@@ -0,0 +1,146 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes the command bytes and returns the result bytes (if any)
+func command(f *FDC, bytes ...byte) []byte {
+	for _, b := range bytes {
+		f.WriteData(b)
+	}
+	return result(f)
+}
+
+// Reads the result bytes
+func result(f *FDC) []byte {
+	var res []byte
+	for f.Status()&(msrDIO|msrEXM) == msrDIO {
+		res = append(res, f.ReadData())
+	}
+	return res
+}
+
+func newTestFDC(t *testing.T) (*FDC, *Disk) {
+	d, err := ParseDSK(newTestDSK(3, true))
+	assert.Nil(t, err)
+	f := NewFDC()
+	f.Insert(0, d)
+	f.SetMotor(true)
+	return f, d
+}
+
+func Test_SeekAndSense(t *testing.T) {
+	f, _ := newTestFDC(t)
+	assert.Equal(t, byte(msrRQM), f.Status())
+
+	// Specify has no result
+	assert.Empty(t, command(f, 0x03, 0xAF, 0x03))
+
+	assert.Empty(t, command(f, 0x0F, 0x00, 0x02))
+	assert.Equal(t, []byte{0x20, 0x02}, command(f, 0x08))
+	// No interrupt pending
+	assert.Equal(t, []byte{0x80}, command(f, 0x08))
+
+	// Drive status: ready, not track 0
+	assert.Equal(t, []byte{0x20}, command(f, 0x04, 0x00))
+	assert.Empty(t, command(f, 0x07, 0x00))
+	assert.Equal(t, []byte{0x20, 0x00}, command(f, 0x08))
+	assert.Equal(t, []byte{0x30}, command(f, 0x04, 0x00))
+
+	// Drive B is not ready
+	assert.Equal(t, []byte{0x11}, command(f, 0x04, 0x01))
+
+	// Invalid command (version)
+	assert.Equal(t, []byte{0x80}, command(f, 0x10))
+	assert.Equal(t, byte(msrRQM), f.Status())
+}
+
+func Test_ReadID(t *testing.T) {
+	f, _ := newTestFDC(t)
+	command(f, 0x0F, 0x00, 0x01)
+	command(f, 0x08)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 1, 0, 1, 2}, command(f, 0x4A, 0x00))
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 1, 0, 2, 2}, command(f, 0x4A, 0x00))
+
+	f.SetMotor(false)
+	assert.Equal(t, byte(0x48), command(f, 0x4A, 0x00)[0])
+}
+
+func Test_ReadData(t *testing.T) {
+	f, _ := newTestFDC(t)
+	command(f, 0x0F, 0x00, 0x01)
+	command(f, 0x08)
+
+	// Read sectors 3 and 4 of track 1
+	assert.Empty(t, command(f, 0x46, 0x00, 1, 0, 3, 2, 4, 0x2A, 0xFF))
+	assert.Equal(t, byte(msrRQM|msrDIO|msrEXM|msrCB), f.Status())
+	var data []byte
+	for f.Status()&msrEXM != 0 {
+		data = append(data, f.ReadData())
+	}
+	assert.Equal(t, 1024, len(data))
+	assert.Equal(t, byte(0x13), data[0])
+	assert.Equal(t, byte(0x14), data[1023])
+	// Terminal count not connected, end of cylinder reported
+	assert.Equal(t, []byte{0x40, 0x80, 0x00, 2, 0, 1, 2}, result(f))
+
+	// Sector not found
+	assert.Equal(t, []byte{0x40, 0x04, 0x00, 1, 0, 10, 2}, command(f, 0x46, 0x00, 1, 0, 10, 2, 10, 0x2A, 0xFF))
+	// Wrong cylinder
+	assert.Equal(t, []byte{0x40, 0x04, 0x10, 0, 0, 1, 2}, command(f, 0x46, 0x00, 0, 0, 1, 2, 1, 0x2A, 0xFF))
+
+	// Data error recorded in the image ends the command after the sector
+	_, d := newTestFDC(t)
+	f.Insert(0, d)
+	d.Track(1, 0).Sectors[0].ST1 = st1DataError
+	d.Track(1, 0).Sectors[0].ST2 = st2DataError
+	command(f, 0x46, 0x00, 1, 0, 1, 2, 9, 0x2A, 0xFF)
+	for f.Status()&msrEXM != 0 {
+		f.ReadData()
+	}
+	assert.Equal(t, []byte{0x40, 0x20, 0x20, 1, 0, 1, 2}, result(f))
+}
+
+func Test_WriteData(t *testing.T) {
+	f, d := newTestFDC(t)
+	command(f, 0x0F, 0x00, 0x02)
+	command(f, 0x08)
+
+	assert.Empty(t, command(f, 0x45, 0x00, 2, 0, 9, 2, 9, 0x2A, 0xFF))
+	assert.Equal(t, byte(msrRQM|msrEXM|msrCB), f.Status())
+	for i := 0; i < 512; i++ {
+		f.WriteData(byte(i))
+	}
+	assert.Equal(t, []byte{0x40, 0x80, 0x00, 3, 0, 1, 2}, result(f))
+	assert.Equal(t, byte(0xFF), d.Track(2, 0).Sectors[8].Data[255])
+	assert.True(t, d.IsModified())
+
+	// Write protected
+	d.SetProtected(true)
+	assert.Equal(t, []byte{0x40, 0x02, 0x00, 2, 0, 9, 2}, command(f, 0x45, 0x00, 2, 0, 9, 2, 9, 0x2A, 0xFF))
+	assert.Equal(t, []byte{0x60}, command(f, 0x04, 0x00))
+}
+
+func Test_FormatTrack(t *testing.T) {
+	f, d := newTestFDC(t)
+	command(f, 0x07, 0x00)
+	command(f, 0x08)
+
+	assert.Empty(t, command(f, 0x4D, 0x00, 2, 9, 0x52, 0xE5))
+	for r := byte(1); r <= 9; r++ {
+		for _, b := range []byte{0, 0, r + 0x40, 2} {
+			f.WriteData(b)
+		}
+	}
+	assert.Equal(t, byte(0x00), result(f)[0])
+	s := d.Track(0, 0).Sectors[3]
+	assert.Equal(t, byte(0x44), s.R)
+	assert.Equal(t, byte(0xE5), s.Data[0])
+
+	// Layout of the image cannot be changed
+	assert.Empty(t, command(f, 0x4D, 0x00, 3, 1, 0x52, 0xE5))
+	assert.Equal(t, []byte{0x40, 0x01, 0x00, 0, 0, 0, 3}, command(f, 0, 0, 1, 3))
+}
@@ -15,6 +15,11 @@ func NewBinaryReader(data []byte) *BinaryReader {
 	return r
 }
 
+// Returns the current position of the reader
+func (r *BinaryReader) Pos() int {
+	return r.pos
+}
+
 // Read a single byte value and advance the reader to the next postion
 func (r *BinaryReader) ReadByte() byte {
 	if len(r.data)-r.pos < 1 {
@@ -0,0 +1,151 @@
+package joystick
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Joystick directions and fire button, the bits match Kempston interface
+const (
+	Right = 0x01
+	Left  = 0x02
+	Down  = 0x04
+	Up    = 0x08
+	Fire  = 0x10
+)
+
+// Joystick interfaces
+const (
+	None      = iota
+	Kempston  // port 0x1F (decoded as: A5=0), active high
+	Sinclair1 // Interface 2 left port, keys 6 (left), 7 (right), 8 (down), 9 (up), 0 (fire)
+	Sinclair2 // Interface 2 right port, keys 1 (left), 2 (right), 3 (down), 4 (up), 5 (fire)
+	Cursor    // Cursor/Protek, keys 5 (left), 6 (down), 7 (up), 8 (right), 0 (fire)
+)
+
+// Returns the joystick interface by its name
+func Parse(name string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return None, nil
+	case "kempston":
+		return Kempston, nil
+	case "sinclair1", "sinclair":
+		return Sinclair1, nil
+	case "sinclair2":
+		return Sinclair2, nil
+	case "cursor", "protek":
+		return Cursor, nil
+	}
+	return None, fmt.Errorf("Joystick not supported: %s", name)
+}
+
+// Key the joystick direction is mapped to: half-row (high byte of the port
+// address line) and key bit
+type key struct {
+	row, bit byte
+}
+
+// Keys of the joystick interfaces mapped onto keyboard half-rows
+var keys = map[int]map[byte]key{
+	Sinclair1: {
+		Fire:  {0x10, 0x01},
+		Up:    {0x10, 0x02},
+		Down:  {0x10, 0x04},
+		Right: {0x10, 0x08},
+		Left:  {0x10, 0x10},
+	},
+	Sinclair2: {
+		Left:  {0x08, 0x01},
+		Right: {0x08, 0x02},
+		Down:  {0x08, 0x04},
+		Up:    {0x08, 0x08},
+		Fire:  {0x08, 0x10},
+	},
+	Cursor: {
+		Left:  {0x08, 0x10},
+		Down:  {0x10, 0x10},
+		Up:    {0x10, 0x08},
+		Right: {0x10, 0x04},
+		Fire:  {0x10, 0x01},
+	},
+}
+
+// Joystick interface, it is attached to the I/O bus as a device
+type Joystick struct {
+	kind  int
+	state byte // directions and fire currently pressed
+}
+
+// Creates a new joystick interface
+func New(kind int) *Joystick {
+	return &Joystick{kind: kind}
+}
+
+// Returns the joystick interface
+func (j *Joystick) Type() int {
+	return j.kind
+}
+
+// Changes the joystick interface, e.g. when loading snapshot
+func (j *Joystick) SetType(kind int) {
+	j.kind = kind
+}
+
+// Returns the directions and fire currently pressed
+func (j *Joystick) State() byte {
+	return j.state
+}
+
+// Sets the directions and fire currently pressed
+func (j *Joystick) SetState(state byte) {
+	j.state = state & (Right | Left | Down | Up | Fire)
+}
+
+// Presses the direction or fire, it stays down until released
+func (j *Joystick) Press(dir byte) {
+	j.SetState(j.state | dir)
+}
+
+// Releases the previously pressed direction or fire
+func (j *Joystick) Release(dir byte) {
+	j.SetState(j.state &^ dir)
+}
+
+// Kempston decodes port 0x1F, Sinclair and Cursor are read as keys
+// on the ULA port
+func (j *Joystick) Decode() (uint16, uint16) {
+	if j.kind == Kempston {
+		return 0x0020, 0x0000
+	}
+	return 0x0001, 0x0000
+}
+
+func (j *Joystick) Read(port uint16) (byte, bool) {
+	switch j.kind {
+	case None:
+		return 0xFF, false
+	case Kempston:
+		return j.state, true
+	}
+
+	// Keys are active low, the value is combined with the keyboard
+	val := byte(0xFF)
+	row := byte(port >> 8)
+	for dir, k := range keys[j.kind] {
+		if j.state&dir != 0 && row&k.row == 0 {
+			val &^= k.bit
+		}
+	}
+	return val, true
+}
+
+func (j *Joystick) Write(port uint16, data byte) {}
+
+func (j *Joystick) Reset() {}
+
+func (j *Joystick) EndFrame() {}
+
+func (j *Joystick) WaitStates() int {
+	return 0
+}
@@ -0,0 +1,91 @@
+package joystick
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse(t *testing.T) {
+	for name, kind := range map[string]int{
+		"none":      None,
+		"Kempston":  Kempston,
+		"sinclair1": Sinclair1,
+		"sinclair2": Sinclair2,
+		"cursor":    Cursor,
+		"protek":    Cursor,
+	} {
+		k, err := Parse(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, kind, k, name)
+	}
+	_, err := Parse("fuller")
+	assert.NotNil(t, err)
+}
+
+func Test_Kempston(t *testing.T) {
+	j := New(Kempston)
+	mask, match := j.Decode()
+	assert.Equal(t, match, 0x001F&mask)
+	assert.NotEqual(t, match, 0x00FF&mask)
+
+	v, ok := j.Read(0x001F)
+	assert.True(t, ok)
+	assert.Equal(t, byte(0x00), v)
+
+	j.Press(Up)
+	j.Press(Fire)
+	v, _ = j.Read(0x001F)
+	assert.Equal(t, byte(0x18), v)
+
+	j.Release(Up)
+	v, _ = j.Read(0x001F)
+	assert.Equal(t, byte(0x10), v)
+
+	j.SetState(0xFF)
+	assert.Equal(t, byte(0x1F), j.State())
+}
+
+func Test_Keys(t *testing.T) {
+	tests := []struct {
+		kind  int
+		dir   byte
+		port  uint16
+		value byte
+	}{
+		{Sinclair1, Left, 0xEFFE, 0xEF},  // 6
+		{Sinclair1, Right, 0xEFFE, 0xF7}, // 7
+		{Sinclair1, Down, 0xEFFE, 0xFB},  // 8
+		{Sinclair1, Up, 0xEFFE, 0xFD},    // 9
+		{Sinclair1, Fire, 0xEFFE, 0xFE},  // 0
+		{Sinclair1, Fire, 0xF7FE, 0xFF},
+		{Sinclair2, Left, 0xF7FE, 0xFE},  // 1
+		{Sinclair2, Right, 0xF7FE, 0xFD}, // 2
+		{Sinclair2, Down, 0xF7FE, 0xFB},  // 3
+		{Sinclair2, Up, 0xF7FE, 0xF7},    // 4
+		{Sinclair2, Fire, 0xF7FE, 0xEF},  // 5
+		{Sinclair2, Fire, 0xEFFE, 0xFF},
+		{Cursor, Left, 0xF7FE, 0xEF},  // 5
+		{Cursor, Down, 0xEFFE, 0xEF},  // 6
+		{Cursor, Up, 0xEFFE, 0xF7},    // 7
+		{Cursor, Right, 0xEFFE, 0xFB}, // 8
+		{Cursor, Fire, 0xEFFE, 0xFE},  // 0
+		{Cursor, Left, 0xEFFE, 0xFF},
+		{Cursor, Fire, 0x00FE, 0xFE}, // all half-rows
+	}
+
+	for _, test := range tests {
+		j := New(test.kind)
+		mask, match := j.Decode()
+		assert.Equal(t, match, test.port&mask)
+
+		j.Press(test.dir)
+		v, ok := j.Read(test.port)
+		assert.True(t, ok)
+		assert.Equal(t, test.value, v, "%d %02X %04X", test.kind, test.dir, test.port)
+
+		j.Release(test.dir)
+		v, _ = j.Read(test.port)
+		assert.Equal(t, byte(0xFF), v)
+	}
+}
@@ -0,0 +1,237 @@
+package machine
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/voytas/z80-go-zx/spectrum/rom"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed profiles/*.yaml
+var profiles embed.FS
+
+// Machine profile as defined in the YAML, JSON or TOML config file
+type profile struct {
+	Name            string      `yaml:"name" json:"name" toml:"name"`
+	Base            string      `yaml:"base" json:"base" toml:"base"` // built-in model the profile is based on
+	Clock           float32     `yaml:"clock" json:"clock" toml:"clock"`
+	FrameStates     int         `yaml:"frame_states" json:"frame_states" toml:"frame_states"`
+	RAM             int         `yaml:"ram" json:"ram" toml:"ram"`
+	ROMs            []string    `yaml:"roms" json:"roms" toml:"roms"`
+	Contention      string      `yaml:"contention" json:"contention" toml:"contention"` // ula, plus3 or none
+	ContentionStart int         `yaml:"contention_start" json:"contention_start" toml:"contention_start"`
+	ScreenStart     int         `yaml:"screen_start" json:"screen_start" toml:"screen_start"`
+	LineStates      int         `yaml:"line_states" json:"line_states" toml:"line_states"`
+	IntLength       int         `yaml:"int_length" json:"int_length" toml:"int_length"`
+	ULAMask         uint16      `yaml:"ula_mask" json:"ula_mask" toml:"ula_mask"`
+	FloatingBus     bool        `yaml:"floating_bus" json:"floating_bus" toml:"floating_bus"`
+	Plus3           bool        `yaml:"plus3" json:"plus3" toml:"plus3"`
+	FDC             bool        `yaml:"fdc" json:"fdc" toml:"fdc"`
+	Peripherals     Peripherals `yaml:"peripherals" json:"peripherals" toml:"peripherals"`
+	Joystick        string      `yaml:"joystick" json:"joystick" toml:"joystick"`
+}
+
+// Loads the machine profile from YAML, JSON or TOML file (as per file
+// extension), relative ROM paths are resolved against the file location
+func LoadConfig(file string) (*Machine, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseConfig(data, filepath.Ext(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	dir := filepath.Dir(file)
+	for _, path := range []*string{&m.ROM1Path, &m.ROM2Path, &m.ROM3Path, &m.ROM4Path} {
+		if *path != "" && !strings.HasPrefix(*path, rom.Embedded) && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+
+	return m, nil
+}
+
+// Parses the machine profile in the format: yaml, json or toml
+func ParseConfig(data []byte, format string) (*Machine, error) {
+	p, err := parseProfile(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return p.build()
+}
+
+// Parses the profile, when it is based on the built-in model the fields not
+// specified are taken from that model
+func parseProfile(data []byte, format string) (*profile, error) {
+	p := &profile{}
+	if err := decode(data, format, p); err != nil {
+		return nil, err
+	}
+	if p.Base == "" {
+		return p, nil
+	}
+
+	base, err := builtinProfile(p.Base)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(data, format, base); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// Decodes the profile, unknown fields are reported as errors
+func decode(data []byte, format string, p *profile) error {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(p)
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(p)
+	case "toml":
+		md, err := toml.Decode(string(data), p)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("Unknown field: %s", undecoded[0])
+		}
+		return nil
+	}
+	return fmt.Errorf("Config format not supported: %s", format)
+}
+
+// Finds the embedded profile of the built-in model
+func builtinProfile(name string) (*profile, error) {
+	files, err := profiles.ReadDir("profiles")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := profiles.ReadFile("profiles/" + f.Name())
+		if err != nil {
+			return nil, err
+		}
+		var header struct {
+			Name string `yaml:"name"`
+		}
+		if err := yaml.Unmarshal(data, &header); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(header.Name, name) {
+			return parseProfile(data, "yaml")
+		}
+	}
+	return nil, fmt.Errorf("Model not supported: %s", name)
+}
+
+// Returns the built-in model, the embedded profiles are part of the binary,
+// so any error is fatal
+func builtin(name string) *Machine {
+	p, err := builtinProfile(name)
+	if err != nil {
+		panic(err)
+	}
+	m, err := p.build()
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	return m
+}
+
+// Validates the profile and creates the machine
+func (p *profile) build() (*Machine, error) {
+	if p.Name == "" {
+		return nil, errors.New("Model name not specified")
+	}
+	if p.Clock <= 0 || p.FrameStates <= 0 || p.LineStates <= 0 {
+		return nil, errors.New("Clock, frame_states and line_states must be specified")
+	}
+
+	roms := 1
+	switch {
+	case p.RAM != 16 && p.RAM != 48 && p.RAM != 128:
+		return nil, fmt.Errorf("RAM size not supported: %dk", p.RAM)
+	case p.Plus3 && p.RAM != 128:
+		return nil, errors.New("+2A/+3 requires 128k RAM")
+	case p.FDC && !p.Plus3:
+		return nil, errors.New("Floppy disk controller is +3 only")
+	case p.Plus3:
+		roms = 4
+	case p.RAM == 128:
+		roms = 2
+	}
+	if len(p.ROMs) != roms {
+		return nil, fmt.Errorf("Expected %d ROMs, found %d", roms, len(p.ROMs))
+	}
+
+	var delays []byte
+	switch strings.ToLower(p.Contention) {
+	case "ula":
+		delays = ulaDelays
+	case "plus3":
+		delays = plus3Delays
+	case "none", "":
+	default:
+		return nil, fmt.Errorf("Contention not supported: %s", p.Contention)
+	}
+	if delays != nil && p.ContentionStart+192*p.LineStates > p.FrameStates {
+		return nil, errors.New("Contended screen does not fit in the frame")
+	}
+
+	m := &Machine{
+		Name:            p.Name,
+		Clock:           p.Clock,
+		FrameStates:     p.FrameStates,
+		RAM:             p.RAM,
+		ContentionTable: buildContentionIndex(p.ContentionStart, p.LineStates, delays),
+		ScreenStart:     p.ScreenStart,
+		LineStates:      p.LineStates,
+		IntLength:       p.IntLength,
+		ULAMask:         p.ULAMask,
+		FloatingBus:     p.FloatingBus,
+		Plus3:           p.Plus3,
+		FDC:             p.FDC,
+		Peripherals:     p.Peripherals,
+		Joystick:        p.Joystick,
+	}
+	for i, path := range []*string{&m.ROM1Path, &m.ROM2Path, &m.ROM3Path, &m.ROM4Path}[:roms] {
+		*path = p.ROMs[i]
+	}
+	if m.IntLength <= 0 {
+		m.IntLength = 32
+	}
+	if m.ULAMask == 0 {
+		m.ULAMask = 0x0001
+	}
+
+	return m, nil
+}
+
+// Checks whether the ROMs of the model can be loaded, +2A/+3 ROMs are not
+// embedded unless they were copied to spectrum/rom before building
+func (m *Machine) CheckROMs() error {
+	for _, path := range []string{m.ROM1Path, m.ROM2Path, m.ROM3Path, m.ROM4Path} {
+		if path == "" {
+			continue
+		}
+		if _, err := rom.Load(path); err != nil {
+			return fmt.Errorf("Model %s cannot be run: %v", m.Name, err)
+		}
+	}
+	return nil
+}
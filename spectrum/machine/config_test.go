@@ -0,0 +1,136 @@
+package machine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Builtin(t *testing.T) {
+	assert.Equal(t, 69888, ZX48k.FrameStates)
+	assert.Equal(t, "embedded:48.rom", ZX48k.ROM1Path)
+	assert.Equal(t, byte(6), ZX48k.ContentionTable[14335])
+	assert.Equal(t, 14335+192*224, len(ZX48k.ContentionTable))
+
+	// +3 is based on +2A
+	assert.True(t, ZXPlus3.Plus3)
+	assert.True(t, ZXPlus3.FDC)
+	assert.False(t, ZXPlus2A.FDC)
+	assert.Equal(t, ZXPlus2A.ContentionTable, ZXPlus3.ContentionTable)
+	assert.Equal(t, "embedded:plus3-3.rom", ZXPlus3.ROM4Path)
+
+	assert.Empty(t, Pentagon.ContentionTable)
+	assert.Equal(t, uint16(0x00FF), Pentagon.ULAMask)
+	assert.Equal(t, 7, len(Models))
+}
+
+func Test_CheckROMs(t *testing.T) {
+	assert.Nil(t, ZX48k.CheckROMs())
+	assert.Nil(t, Pentagon.CheckROMs())
+
+	m := *ZX128k
+	m.ROM2Path = "embedded:missing.rom"
+	assert.EqualError(t, m.CheckROMs(), "Model 128k cannot be run: ROM missing.rom is not embedded, "+
+		"copy it to spectrum/rom and rebuild or specify its path in the machine config")
+}
+
+func Test_ParseConfig(t *testing.T) {
+	configs := map[string]string{
+		"yaml": `
+name: custom
+clock: 3.5
+frame_states: 69888
+ram: 48
+roms: [embedded:48.rom]
+contention: ula
+contention_start: 14335
+screen_start: 14335
+line_states: 224
+ula_mask: 0x0001
+peripherals:
+  specdrum: true
+joystick: kempston
+`,
+		"json": `{
+	"name": "custom", "clock": 3.5, "frame_states": 69888, "ram": 48,
+	"roms": ["embedded:48.rom"], "contention": "ula", "contention_start": 14335,
+	"screen_start": 14335, "line_states": 224, "ula_mask": 1,
+	"peripherals": {"specdrum": true}, "joystick": "kempston"
+}`,
+		"toml": `
+name = "custom"
+clock = 3.5
+frame_states = 69888
+ram = 48
+roms = ["embedded:48.rom"]
+contention = "ula"
+contention_start = 14335
+screen_start = 14335
+line_states = 224
+ula_mask = 0x0001
+joystick = "kempston"
+
+[peripherals]
+specdrum = true
+`,
+	}
+
+	for format, config := range configs {
+		m, err := ParseConfig([]byte(config), format)
+		assert.Nil(t, err, format)
+		assert.Equal(t, "custom", m.Name, format)
+		assert.Equal(t, ZX48k.ContentionTable, m.ContentionTable, format)
+		assert.Equal(t, 32, m.IntLength, format)
+		assert.True(t, m.Peripherals.Specdrum, format)
+		assert.Equal(t, "kempston", m.Joystick, format)
+	}
+}
+
+func Test_ParseConfigBase(t *testing.T) {
+	m, err := ParseConfig([]byte("name: fast\nbase: 128k\nclock: 7\nroms: [a.rom, b.rom]\n"), "yml")
+	assert.Nil(t, err)
+	assert.Equal(t, float32(7), m.Clock)
+	assert.Equal(t, ZX128k.FrameStates, m.FrameStates)
+	assert.Equal(t, ZX128k.ContentionTable, m.ContentionTable)
+	assert.Equal(t, "b.rom", m.ROM2Path)
+
+	// +2 runs the 128k ROMs unless the profile specifies the original ones
+	assert.Equal(t, ZX128k.ROM1Path, ZXPlus2.ROM1Path)
+	m, err = ParseConfig([]byte("name: +2-roms\nbase: +2\nroms: [plus2-0.rom, plus2-1.rom]\n"), "yml")
+	assert.Nil(t, err)
+	assert.Equal(t, "plus2-0.rom", m.ROM1Path)
+	assert.Equal(t, ZXPlus2.FrameStates, m.FrameStates)
+
+	for _, config := range []string{
+		"base: 48k\nram: 32",
+		"base: 48k\nroms: [a.rom, b.rom]",
+		"base: 48k\nfdc: true",
+		"base: 48k\ncontention: odd",
+		"base: 48k\nline_states: 400",
+		"base: 48k\nunknown: 1",
+		"base: 2068",
+		"name: none",
+	} {
+		_, err := ParseConfig([]byte(config), "yaml")
+		assert.NotNil(t, err, config)
+	}
+
+	_, err = ParseConfig([]byte("base: 48k"), "ini")
+	assert.NotNil(t, err)
+}
+
+func Test_LoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "custom.json")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(`{"base": "128k", "roms": ["roms/0.rom", "embedded:128-1.rom"]}`), 0644))
+
+	m, err := LoadConfig(file)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "roms", "0.rom"), m.ROM1Path)
+	assert.Equal(t, "embedded:128-1.rom", m.ROM2Path)
+
+	_, err = LoadConfig(filepath.Join(dir, "none.yaml"))
+	assert.NotNil(t, err)
+}
@@ -1,33 +1,67 @@
 package machine
 
 type Machine struct {
+	Name            string  // Model name as used by emu command, e.g. 48k
 	Clock           float32 // Clock im MHz
 	FrameStates     int     // Number of frames to draw the screen
-	ROM1Path        string  // Path to the ROM file 1
-	ROM2Path        string  // Path to the ROM file 2 (128k only)
+	ROM1Path        string  // Path to the ROM file 1 or embedded:<name>
+	ROM2Path        string  // Path to the ROM file 2 (128k, +2A and +3 only)
+	ROM3Path        string  // Path to the ROM file 3 (+2A and +3 only)
+	ROM4Path        string  // Path to the ROM file 4 (+2A and +3 only)
+	RAM             int     // RAM size in kB: 16, 48 or 128
 	ContentionTable []byte  // Contention table that provides extra states for given state
+	ScreenStart     int     // T state the ULA starts fetching the first screen line
+	LineStates      int     // Number of T states per screen line
+	IntLength       int     // Length of the interrupt signal in T states
+	ULAMask         uint16  // Address lines decoding the ULA port 0xFE (A0 or A0-A7)
+	FloatingBus     bool    // Unattached ports read the byte the ULA is fetching
+	Plus3           bool    // +2A/+3 gate array: port 0x1FFD paging, banks 4-7 contended, no I/O contention
+	FDC             bool    // uPD765 floppy disk controller (+3 only)
+	Peripherals     Peripherals
+	Joystick        string // Default joystick, e.g. kempston
 }
 
-var ZX48k = &Machine{
-	Clock:           3.5,
-	FrameStates:     69888,
-	ROM1Path:        "./spectrum/rom/48.rom",
-	ContentionTable: buildContentionIndex(14335, 224),
+// Audio peripherals attached by default
+type Peripherals struct {
+	TurboSound bool `yaml:"turbo_sound" json:"turbo_sound" toml:"turbo_sound"`
+	Specdrum   bool `yaml:"specdrum" json:"specdrum" toml:"specdrum"`
+	Covox      bool `yaml:"covox" json:"covox" toml:"covox"`
 }
 
-var ZX128k = &Machine{
-	Clock:           3.5469,
-	FrameStates:     70908,
-	ROM1Path:        "./spectrum/rom/128-0.rom",
-	ROM2Path:        "./spectrum/rom/128-1.rom",
-	ContentionTable: buildContentionIndex(14361, 228),
+// Contention delays in each 8 T states
+var (
+	ulaDelays   = []byte{6, 5, 4, 3, 2, 1, 0, 0}
+	plus3Delays = []byte{1, 0, 7, 6, 5, 4, 3, 2}
+)
+
+// Built-in models, defined by the profiles embedded in the binary
+var (
+	ZX16k    = builtin("16k")
+	ZX48k    = builtin("48k")
+	ZX128k   = builtin("128k")
+	ZXPlus2  = builtin("+2")
+	ZXPlus2A = builtin("+2a")
+	ZXPlus3  = builtin("+3")
+	Pentagon = builtin("pentagon")
+)
+
+// Models by name
+var Models = map[string]*Machine{}
+
+func init() {
+	for _, m := range []*Machine{ZX16k, ZX48k, ZX128k, ZXPlus2, ZXPlus2A, ZXPlus3, Pentagon} {
+		Models[m.Name] = m
+	}
 }
 
-// Builds the contention table using starting contention state
-// and number of T states per line
-func buildContentionIndex(start, states int) []byte {
+// Builds the contention table using starting contention state,
+// number of T states per line and delays pattern (no delays means
+// there is no contention)
+func buildContentionIndex(start, states int, delays []byte) []byte {
+	if len(delays) == 0 {
+		return []byte{}
+	}
 	cs := make([]byte, start+192*states)
-	delays := []byte{6, 5, 4, 3, 2, 1, 0, 0}
 
 	for line := 0; line < 192; line++ {
 		t := start + line*states
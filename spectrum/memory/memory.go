@@ -1,20 +1,29 @@
 package memory
 
 import (
-	"io/ioutil"
+	"fmt"
 
 	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/rom"
 	"github.com/voytas/z80-go-zx/z80"
 )
 
 // Memory mode: 48k or 128k
 const (
-	mode48k  = 1
-	mode128k = 2
+	mode48k   = 1
+	mode128k  = 2
+	modePlus3 = 3
 )
 
-type Bank [0x4000]byte     // Represents 16k memory bank
-var contendedStates []byte // contented states table
+// Banks of the special (all RAM) paging configurations (+2A/+3)
+var specialPaging = [4][4]int{
+	{0, 1, 2, 3},
+	{4, 5, 6, 7},
+	{4, 5, 6, 3},
+	{4, 7, 6, 3},
+}
+
+type Bank [0x4000]byte // Represents 16k memory bank
 
 type Memory struct {
 	Screen     *Bank         // current screen bank
@@ -23,9 +32,16 @@ type Memory struct {
 	banks      [8]Bank       // 8 memory banks
 	rom48      Bank          // ROM 1 (48k)
 	rom128     Bank          // ROM 2 (128k)
+	romPlus3   [4]Bank       // ROMs 0-3 (+2A/+3)
 	active     [4]*Bank      // currently active banks
+	contended  [4]bool       // contended 16k slots
+	writable   [4]bool       // 16k slots with RAM
+	unmapped   Bank          // no memory (16k), reads 0xFF
 	pgDisabled bool          // paging disabled until next reset
+	pgMode     byte          // last value written to port 0x7FFD
+	pgPlus3    byte          // last value written to port 0x1FFD (+2A/+3)
 	mode       int
+	contention []byte // contended states table
 }
 
 // Creates a new memory for 48k model
@@ -45,7 +61,26 @@ func NewMem48k(romPath string) (*Memory, error) {
 	m.pgDisabled = true
 	m.Screen = &m.banks[5]
 
-	contendedStates = machine.ZX48k.ContentionTable
+	m.contended[1] = true
+	m.writable = [4]bool{false, true, true, true}
+	m.contention = machine.ZX48k.ContentionTable
+
+	return m, nil
+}
+
+// Creates a new memory for 16k model, there is no RAM above 0x8000
+func NewMem16k(romPath string) (*Memory, error) {
+	m, err := NewMem48k(romPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range m.unmapped {
+		m.unmapped[i] = 0xFF
+	}
+	m.copyBank(0x8000, &m.unmapped)
+	m.copyBank(0xC000, &m.unmapped)
+	m.writable[2], m.writable[3] = false, false
 
 	return m, nil
 }
@@ -71,34 +106,118 @@ func NewMem128k(rom1Path, rom2Path string) (*Memory, error) {
 
 	m.Screen = m.active[1]
 
-	contendedStates = machine.ZX128k.ContentionTable
+	m.contended[1] = true
+	m.writable = [4]bool{false, true, true, true}
+	m.contention = machine.ZX128k.ContentionTable
 
 	return m, nil
 }
 
+// Creates a new memory for +2A/+3 model with paging and special paging modes
+func NewMemPlus3(romPaths [4]string) (*Memory, error) {
+	m := &Memory{mode: modePlus3}
+	for i, path := range romPaths {
+		if err := loadROM(path, &m.romPlus3[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	m.Cells = make([]*byte, 0x10000)
+	m.writable = [4]bool{false, true, true, true}
+	m.pagePlus3()
+	m.Screen = &m.banks[5]
+
+	m.contention = machine.ZXPlus3.ContentionTable
+
+	return m, nil
+}
+
+// Sets the contention table of the model, e.g. no contention on Pentagon
+func (m *Memory) SetContentionTable(table []byte) {
+	m.contention = table
+}
+
 // Reads a value from the memory address
 func (m *Memory) Read(addr uint16) byte {
 	m.addContention(addr)
 	return *m.Cells[addr]
 }
 
-// Writes a value to the memory address
+// Writes a value to the memory address, ROM is not written
 func (m *Memory) Write(addr uint16, value byte) {
-	if addr >= 0x4000 && addr <= 0xFFFF {
+	if m.writable[addr>>14] {
 		*m.Cells[addr] = value
 		m.addContention(addr)
 	}
 }
 
+// Reads the memory without contention (debugger)
+func (m *Memory) Peek(addr uint16) byte {
+	return *m.Cells[addr]
+}
+
+// Writes the memory without contention, ROM is written too (debugger)
+func (m *Memory) Poke(addr uint16, value byte) {
+	*m.Cells[addr] = value
+}
+
+// Returns the number of RAM banks that can be paged in (0 for 16k/48k)
+func (m *Memory) Banks() int {
+	if m.mode == mode48k {
+		return 0
+	}
+	return len(m.banks)
+}
+
+// Returns the RAM bank paged in the 16k slot (0-3), -1 for ROM or no memory
+func (m *Memory) SlotBank(slot int) int {
+	cell := m.Cells[slot<<14]
+	for i := range m.banks {
+		if cell == &m.banks[i][0] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reads the byte of the RAM bank
+func (m *Memory) PeekBank(bank int, offset uint16) byte {
+	return m.banks[bank][offset&0x3FFF]
+}
+
+// Writes the byte of the RAM bank
+func (m *Memory) PokeBank(bank int, offset uint16, value byte) {
+	m.banks[bank][offset&0x3FFF] = value
+}
+
 // Sets the paging mode for 128k model
 func (m *Memory) PageMode(mode byte) {
 	if m.pgDisabled {
 		return
 	}
 
+	m.pgMode = mode
+
 	// Disable paging until next reset
 	m.pgDisabled = mode&0b00100000 != 0
 
+	// Screen bank selection - does not swap memory bank
+	if mode&0b00001000 != 0 {
+		if m.Screen != &m.banks[7] {
+			// second screen select (bank 7)
+			m.Screen = &m.banks[7]
+		}
+	} else if m.Screen != &m.banks[5] {
+		// normal screen select (bank 5)
+		m.Screen = &m.banks[5]
+	}
+
+	if m.mode == modePlus3 {
+		// ROM and RAM banks also depend on port 0x1FFD
+		m.pagePlus3()
+		return
+	}
+
 	// ROM bank selection
 	if mode&0b00010000 != 0 {
 		if m.active[0] != &m.rom48 {
@@ -112,17 +231,6 @@ func (m *Memory) PageMode(mode byte) {
 		m.active[0] = &m.rom128
 	}
 
-	// Screen bank selection - does not swap memory bank
-	if mode&0b00001000 != 0 {
-		if m.Screen != &m.banks[7] {
-			// second screen select (bank 7)
-			m.Screen = &m.banks[7]
-		}
-	} else if m.Screen != &m.banks[5] {
-		// normal screen select (bank 5)
-		m.Screen = &m.banks[5]
-	}
-
 	// RAM bank selection
 	bank := mode & 0x07
 	if m.active[3] != &m.banks[bank] {
@@ -131,6 +239,59 @@ func (m *Memory) PageMode(mode byte) {
 	}
 }
 
+// Sets the paging mode (port 0x1FFD) for +2A/+3 model: bit 0 enables the
+// special paging mode selected by bits 1-2, otherwise bit 2 is the high bit
+// of the ROM selection
+func (m *Memory) PageModePlus3(mode byte) {
+	if m.mode != modePlus3 || m.pgDisabled {
+		return
+	}
+	m.pgPlus3 = mode
+	m.pagePlus3()
+}
+
+// Returns the last paging mode (port 0x1FFD value) for +2A/+3 model
+func (m *Memory) PagingModePlus3() byte {
+	return m.pgPlus3
+}
+
+// Maps the banks as per ports 0x7FFD and 0x1FFD (+2A/+3), banks 4-7 are contended
+func (m *Memory) pagePlus3() {
+	var active [4]*Bank
+	m.writable[0] = m.pgPlus3&0x01 != 0
+	if m.writable[0] {
+		for slot, bank := range specialPaging[m.pgPlus3>>1&0x03] {
+			active[slot] = &m.banks[bank]
+		}
+	} else {
+		rom := m.pgPlus3>>1&0x02 | m.pgMode>>4&0x01
+		active = [4]*Bank{&m.romPlus3[rom], &m.banks[5], &m.banks[2], &m.banks[m.pgMode&0x07]}
+	}
+
+	for slot, bank := range active {
+		if m.active[slot] != bank {
+			m.copyBank(slot*0x4000, bank)
+			m.active[slot] = bank
+		}
+		m.contended[slot] = false
+		for b := 4; b < 8; b++ {
+			if bank == &m.banks[b] {
+				m.contended[slot] = true
+			}
+		}
+	}
+}
+
+// Enables paging and selects the initial banks (128k ROM, bank 0)
+func (m *Memory) ResetPaging() {
+	if m.mode == mode48k {
+		return
+	}
+	m.pgDisabled = false
+	m.pgPlus3 = 0
+	m.PageMode(0)
+}
+
 // Loads the specified memory bank with data
 func (m *Memory) LoadBank(page int, data []byte) {
 	for i := 0; i < len(data); i++ {
@@ -138,6 +299,26 @@ func (m *Memory) LoadBank(page int, data []byte) {
 	}
 }
 
+// Returns the specified memory bank
+func (m *Memory) Bank(page int) *Bank {
+	return &m.banks[page]
+}
+
+// Returns the last paging mode (port 0x7FFD value) for 128k model
+func (m *Memory) PagingMode() byte {
+	return m.pgMode
+}
+
+// Checks whether memory is for 128k model (including +2A/+3)
+func (m *Memory) Is128k() bool {
+	return m.mode != mode48k
+}
+
+// Checks whether memory is for +2A/+3 model
+func (m *Memory) IsPlus3() bool {
+	return m.mode == modePlus3
+}
+
 // Copies the memory bank to the specified address
 func (m *Memory) copyBank(addr int, src *Bank) {
 	for i := 0; i < len(src); i++ {
@@ -147,41 +328,34 @@ func (m *Memory) copyBank(addr int, src *Bank) {
 
 // Loads ROMs for 128k model emulation
 func (m *Memory) load128ROM(rom1Path, rom2Path string) error {
-	rom, err := ioutil.ReadFile(rom1Path)
-	if err != nil {
+	if err := loadROM(rom1Path, &m.rom128); err != nil {
 		return err
 	}
-	for i := 0; i < len(m.rom128); i++ {
-		m.rom128[i] = rom[i]
-	}
+	return loadROM(rom2Path, &m.rom48)
+}
 
-	rom, err = ioutil.ReadFile(rom2Path)
+// Loads the 16k ROM (file or embedded) to the bank
+func loadROM(romPath string, bank *Bank) error {
+	data, err := rom.Load(romPath)
 	if err != nil {
 		return err
 	}
-	for i := 0; i < len(m.rom48); i++ {
-		m.rom48[i] = rom[i]
+	if len(data) < len(bank) {
+		return fmt.Errorf("ROM file is too short: %s", romPath)
 	}
+	copy(bank[:], data)
 
 	return nil
 }
 
 // Loads ROMs for 48k model emulation
 func (m *Memory) load48ROM(romPath string) error {
-	rom, err := ioutil.ReadFile(romPath)
-	if err != nil {
-		return err
-	}
-	for i := 0; i < len(m.rom48); i++ {
-		m.rom48[i] = rom[i]
-	}
-
-	return nil
+	return loadROM(romPath, &m.rom48)
 }
 
 // Add extra states if memory address is contended
 func (m *Memory) addContention(addr uint16) {
-	if addr >= 0x4000 && addr <= 0x7fff && m.TC.Current < len(contendedStates) {
-		m.TC.Add(int(contendedStates[m.TC.Current]))
+	if m.contended[addr>>14] && m.TC.Current < len(m.contention) {
+		m.TC.Add(int(m.contention[m.TC.Current]))
 	}
 }
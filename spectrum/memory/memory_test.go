@@ -1,6 +1,10 @@
 package memory
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,7 +22,6 @@ func Test_ContentedRead(t *testing.T) {
 	cpu.Reg.A = 0x34
 	*mem.Cells[25000] = 0x77 // ld (hl),a
 	*mem.Cells[25001] = 0x76 // halt
-	contendedStates = machine.ZX48k.ContentionTable
 	cpu.Trap = func() {
 		cpu.TC.Current = 14335
 		cpu.TC.Total = 14335
@@ -29,3 +32,93 @@ func Test_ContentedRead(t *testing.T) {
 
 	assert.Equal(t, cpu.Reg.A, *mem.Cells[26000])
 }
+
+func Test_ContentionPerMemory(t *testing.T) {
+	mem48, err := NewMem48k("../rom/48.rom")
+	assert.Nil(t, err)
+	pentagon, err := NewMem128k("../rom/128-0.rom", "../rom/128-1.rom")
+	assert.Nil(t, err)
+	pentagon.SetContentionTable(machine.Pentagon.ContentionTable)
+
+	// Pentagon memory created later does not change 48k contention
+	for _, m := range []*Memory{mem48, pentagon} {
+		m.TC = &z80.TCounter{Current: 14335}
+		m.Read(0x4000)
+	}
+	assert.Equal(t, 14335+6, mem48.TC.Current)
+	assert.Equal(t, 14335, pentagon.TC.Current)
+}
+
+// Creates +2A/+3 memory with ROMs filled with the ROM number
+func newTestMemPlus3(t *testing.T) *Memory {
+	var paths [4]string
+	for i := range paths {
+		paths[i] = filepath.Join(t.TempDir(), fmt.Sprintf("plus3-%d.rom", i))
+		assert.Nil(t, ioutil.WriteFile(paths[i], bytes.Repeat([]byte{byte(i)}, 0x4000), 0644))
+	}
+	mem, err := NewMemPlus3(paths)
+	assert.Nil(t, err)
+	mem.TC = &z80.TCounter{}
+	return mem
+}
+
+func Test_PagingPlus3(t *testing.T) {
+	mem := newTestMemPlus3(t)
+	assert.True(t, mem.Is128k())
+	assert.True(t, mem.IsPlus3())
+
+	// ROM is selected by bit 4 of 0x7FFD and bit 2 of 0x1FFD
+	assert.Equal(t, byte(0), mem.Read(0x0000))
+	mem.PageMode(0x10)
+	assert.Equal(t, byte(1), mem.Read(0x0000))
+	mem.PageModePlus3(0x04)
+	assert.Equal(t, byte(3), mem.Read(0x3FFF))
+	mem.PageMode(0x03)
+	assert.Equal(t, byte(2), mem.Read(0x0000))
+	mem.Write(0x0000, 0xAA)
+	assert.Equal(t, byte(2), mem.Read(0x0000))
+	mem.Write(0xC000, 0x33)
+	assert.Equal(t, byte(0x33), mem.Bank(3)[0])
+	assert.Equal(t, [4]bool{false, true, false, false}, mem.contended)
+
+	// Special paging: banks 0, 1, 2, 3
+	mem.PageModePlus3(0x01)
+	mem.Write(0x0000, 0xAA)
+	assert.Equal(t, byte(0xAA), mem.Bank(0)[0])
+	assert.Equal(t, [4]bool{false, false, false, false}, mem.contended)
+
+	// Special paging: banks 4, 7, 6, 3
+	mem.PageModePlus3(0x07)
+	mem.Write(0x4000, 0x55)
+	assert.Equal(t, byte(0x55), mem.Bank(7)[0])
+	assert.Equal(t, byte(0x33), mem.Read(0xC000))
+	assert.Equal(t, [4]bool{true, true, true, false}, mem.contended)
+	assert.Equal(t, byte(0x07), mem.PagingModePlus3())
+
+	// Paging locked, both ports are ignored
+	mem.PageModePlus3(0x00)
+	mem.PageMode(0x20)
+	mem.PageModePlus3(0x04)
+	assert.Equal(t, byte(0), mem.Read(0x0000))
+
+	mem.ResetPaging()
+	assert.Equal(t, byte(0), mem.Read(0x0000))
+	assert.Equal(t, byte(0x00), mem.PagingModePlus3())
+	assert.Equal(t, &mem.banks[5], mem.Screen)
+}
+
+func Test_Mem16k(t *testing.T) {
+	mem, err := NewMem16k("../rom/48.rom")
+	assert.Nil(t, err)
+	mem.TC = &z80.TCounter{}
+
+	mem.Write(0x7FFF, 0x12)
+	assert.Equal(t, byte(0x12), mem.Read(0x7FFF))
+
+	// No RAM above 0x8000
+	mem.Write(0x8000, 0x34)
+	mem.Write(0xFFFF, 0x56)
+	assert.Equal(t, byte(0xFF), mem.Read(0x8000))
+	assert.Equal(t, byte(0xFF), mem.Read(0xFFFF))
+	assert.False(t, mem.Is128k())
+}
@@ -0,0 +1,30 @@
+// Package rom provides the ROM images embedded in the binary, any *.rom
+// file placed in this directory is embedded when the emulator is built.
+package rom
+
+import (
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Prefix of the ROM path that refers to the embedded ROM
+const Embedded = "embedded:"
+
+//go:embed *.rom
+var roms embed.FS
+
+// Loads the ROM image, path with the "embedded:" prefix is loaded from the
+// binary, otherwise from the file
+func Load(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, Embedded) {
+		return ioutil.ReadFile(path)
+	}
+	name := strings.TrimPrefix(path, Embedded)
+	data, err := roms.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("ROM %s is not embedded, copy it to spectrum/rom and rebuild or specify its path in the machine config", name)
+	}
+	return data, nil
+}
@@ -0,0 +1,20 @@
+package rom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Load(t *testing.T) {
+	data, err := Load("embedded:48.rom")
+	assert.Nil(t, err)
+	assert.Equal(t, 16384, len(data))
+
+	file, err := Load("48.rom")
+	assert.Nil(t, err)
+	assert.Equal(t, data, file)
+
+	_, err = Load("embedded:none.rom")
+	assert.NotNil(t, err)
+}
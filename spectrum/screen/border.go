@@ -46,6 +46,11 @@ func BorderColour(colour byte, tc int) {
 	}
 }
 
+// Returns the current border colour
+func Border() byte {
+	return lastBorderState.colour
+}
+
 func findBorderColour(t int) []byte {
 	if len(borderStates) == 0 {
 		return borderPalette[lastBorderState.colour]
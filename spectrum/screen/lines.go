@@ -27,3 +27,9 @@ var lines = []int{
 	0x50C0, 0x51C0, 0x52C0, 0x53C0, 0x54C0, 0x55C0, 0x56C0, 0x57C0, // Lines 176-183
 	0x50E0, 0x51E0, 0x52E0, 0x53E0, 0x54E0, 0x55E0, 0x56E0, 0x57E0, // Lines 184-191
 }
+
+// Returns the bitmap and attribute addresses of the screen line (0-191)
+// and column (0-31)
+func Address(line, col int) (bitmap, attr uint16) {
+	return uint16(lines[line] + col), uint16(0x5800 + 32*(line/8) + col)
+}
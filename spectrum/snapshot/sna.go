@@ -4,23 +4,41 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/voytas/z80-go-zx/spectrum/bus"
 	"github.com/voytas/z80-go-zx/spectrum/memory"
 	"github.com/voytas/z80-go-zx/spectrum/screen"
 	"github.com/voytas/z80-go-zx/z80"
 )
 
+const (
+	sna48kSize  = 49179  // 27 bytes header + 48k memory
+	sna128kSize = 131103 // 48k snapshot + 4 bytes + 5 remaining banks
+)
+
 type SNA struct{}
 
 // Loads SNA file to memory and updates the CPU state so it is ready to run
-func (sna *SNA) Load(file string, cpu *z80.Z80, mem *memory.Memory) error {
+func (sna *SNA) Load(file string, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
-	if len(data) == 49179 && len(data) == 131103 && len(data) == 147487 {
+	if len(data) != sna48kSize && len(data) != sna128kSize && len(data) != sna128kSize+16384 {
 		return fmt.Errorf("SNA file format is invalid. Expected 49179, 131103 or 147487 bytes, but received %v", len(data))
 	}
 
+	// 128k snapshot has 5 remaining banks, or 6 if bank 2 or 5 is paged in
+	if len(data) != sna48kSize {
+		bank := data[49181] & 0x07
+		size := sna128kSize
+		if bank == 2 || bank == 5 {
+			size += 16384
+		}
+		if len(data) != size {
+			return fmt.Errorf("SNA file format is invalid. Expected %v bytes with bank %v paged in, but received %v", size, bank, len(data))
+		}
+	}
+
 	// Restore CPU state
 	state := &z80.CPUState{
 		AF:   uint16(data[22])<<8 | uint16(data[21]),
@@ -41,21 +59,25 @@ func (sna *SNA) Load(file string, cpu *z80.Z80, mem *memory.Memory) error {
 		IFF2: data[19]&0x04 != 0,
 	}
 
+	// Page in the memory bank before loading 48k memory
+	if len(data) != sna48kSize {
+		mem.PageMode(data[49181])
+	}
+
 	// Load 48k memory
 	for i := 16384; i < len(mem.Cells); i++ {
 		*mem.Cells[i] = data[i-16384+27]
 	}
 
-	if len(data) == 49179 {
+	if len(data) == sna48kSize {
 		// 48k model
 		state.PC = uint16(*mem.Cells[state.SP+1])<<8 | uint16(*mem.Cells[state.SP])
 		state.SP += 2
 	} else {
 		// 128k model
 		mode := data[49181]
-		mem.PageMode(mode)
 		block := 49183
-		for bank := 0; bank < 7; bank++ {
+		for bank := 0; bank < 8; bank++ {
 			// Skip 3 banks already loaded as 48k memory
 			if bank != 2 && bank != 5 && bank != int(mode&0x07) {
 				mem.LoadBank(bank, data[block:block+16384])
@@ -66,10 +88,66 @@ func (sna *SNA) Load(file string, cpu *z80.Z80, mem *memory.Memory) error {
 	}
 
 	// Restore border colour
-	screen.BorderColour(data[26], 0)
+	bus.SetULA(data[26] & 0x07)
 
 	// Set CPU state
 	cpu.State(state)
 
 	return nil
 }
+
+// Saves the CPU state and memory as SNA file
+func (sna *SNA) Save(file string, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
+	state := cpu.GetState()
+	if state.Halt {
+		// Execute HALT again when snapshot is loaded
+		state.PC -= 1
+	}
+
+	data := make([]byte, sna48kSize, sna128kSize+16384)
+	data[0] = state.I
+	data[1], data[2] = byte(state.HL_), byte(state.HL_>>8)
+	data[3], data[4] = byte(state.DE_), byte(state.DE_>>8)
+	data[5], data[6] = byte(state.BC_), byte(state.BC_>>8)
+	data[7], data[8] = byte(state.AF_), byte(state.AF_>>8)
+	data[9], data[10] = byte(state.HL), byte(state.HL>>8)
+	data[11], data[12] = byte(state.DE), byte(state.DE>>8)
+	data[13], data[14] = byte(state.BC), byte(state.BC>>8)
+	data[15], data[16] = byte(state.IY), byte(state.IY>>8)
+	data[17], data[18] = byte(state.IX), byte(state.IX>>8)
+	if state.IFF2 {
+		data[19] = 0x04
+	}
+	data[20] = state.R
+	data[21], data[22] = byte(state.AF), byte(state.AF>>8)
+	data[25] = state.IM
+	data[26] = screen.Border()
+
+	// Save 48k memory
+	for i := 16384; i < len(mem.Cells); i++ {
+		data[i-16384+27] = *mem.Cells[i]
+	}
+
+	if !mem.Is128k() {
+		// 48k model, PC is pushed on the stack
+		state.SP -= 2
+		if state.SP >= 16384 {
+			data[int(state.SP)-16384+27] = byte(state.PC)
+		}
+		if state.SP+1 >= 16384 {
+			data[int(state.SP)+1-16384+27] = byte(state.PC >> 8)
+		}
+	} else {
+		// 128k model, remaining banks follow
+		mode := mem.PagingMode()
+		data = append(data, byte(state.PC), byte(state.PC>>8), mode, 0)
+		for bank := 0; bank < 8; bank++ {
+			if bank != 2 && bank != 5 && bank != int(mode&0x07) {
+				data = append(data, mem.Bank(bank)[:]...)
+			}
+		}
+	}
+	data[23], data[24] = byte(state.SP), byte(state.SP>>8)
+
+	return ioutil.WriteFile(file, data, 0644)
+}
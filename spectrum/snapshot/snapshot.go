@@ -5,20 +5,85 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
 	"github.com/voytas/z80-go-zx/spectrum/memory"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
 	"github.com/voytas/z80-go-zx/z80"
 )
 
-func LoadFile(file string, cpu *z80.Z80, mem *memory.Memory) error {
+// Loads the snapshot file, format is determined by the file extension
+func LoadFile(file string, model *machine.Machine, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus, tape *tape.Tape) error {
 	ext := strings.ToLower(filepath.Ext(file))
 	switch ext {
 	case ".sna":
 		sna := &SNA{}
-		return sna.Load(file, cpu, mem)
+		return sna.Load(file, cpu, mem, bus)
 	case ".szx":
 		szx := &SZX{}
-		return szx.Load(file, cpu, mem)
+		return szx.Load(file, cpu, mem, bus, tape)
+	case ".z80":
+		z := &Z80{}
+		return z.Load(file, model, cpu, mem, bus)
 	default:
 		return fmt.Errorf("File format not supported: %s", ext)
 	}
 }
+
+// Saves the snapshot file of the model, format is determined by the file extension
+func SaveFile(file string, model *machine.Machine, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".sna":
+		sna := &SNA{}
+		return sna.Save(file, cpu, mem, bus)
+	case ".szx":
+		szx := &SZX{}
+		return szx.Save(file, model, cpu, mem, bus)
+	case ".z80":
+		z := &Z80{}
+		return z.Save(file, model, cpu, mem, bus)
+	default:
+		return fmt.Errorf("File format not supported: %s", ext)
+	}
+}
+
+// Selects 48k ROM and locks paging, so 128k model behaves as 48k
+// (+2A/+3 48k ROM is ROM 3)
+func lock48k(mem *memory.Memory) {
+	mem.PageModePlus3(0b00000100)
+	mem.PageMode(0b00110000)
+}
+
+// Models as identified in the snapshots
+const (
+	model16k = iota
+	model48k
+	model128k
+	modelPlus2
+	modelPlus2A
+	modelPlus3
+	modelPentagon
+)
+
+// Identifies the model, +2 and Pentagon by name (they are 128k hardware),
+// others (including custom models) by their hardware
+func modelType(m *machine.Machine) int {
+	switch strings.ToLower(m.Name) {
+	case "+2":
+		return modelPlus2
+	case "pentagon":
+		return modelPentagon
+	}
+	switch {
+	case m.Plus3 && m.FDC:
+		return modelPlus3
+	case m.Plus3:
+		return modelPlus2A
+	case m.RAM == 128:
+		return model128k
+	case m.RAM == 16:
+		return model16k
+	}
+	return model48k
+}
@@ -0,0 +1,337 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/memory"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+var testState = &z80.CPUState{
+	AF: 0x1234, BC: 0x2345, DE: 0x3456, HL: 0x4567,
+	AF_: 0x5678, BC_: 0x6789, DE_: 0x789A, HL_: 0x89AB,
+	IX: 0x9ABC, IY: 0xABCD, PC: 0xBCDE, SP: 0xCDEF,
+	I: 0x1A, R: 0x2B, IM: 1, IFF1: true, IFF2: true,
+}
+
+func newTestMachine(t *testing.T, is128k bool) (*z80.Z80, *memory.Memory, *bus.Bus) {
+	var mem *memory.Memory
+	var err error
+	m := machine.ZX48k
+	if is128k {
+		m = machine.ZX128k
+		mem, err = memory.NewMem128k("../rom/128-0.rom", "../rom/128-1.rom")
+	} else {
+		mem, err = memory.NewMem48k("../rom/48.rom")
+	}
+	assert.Nil(t, err)
+	cpu := z80.NewZ80(mem)
+	mem.TC = cpu.TC
+	return cpu, mem, bus.NewBus(m, cpu.TC, mem, &tape.Tape{})
+}
+
+func Test_SaveLoad(t *testing.T) {
+	for _, test := range []struct {
+		file   string
+		is128k bool
+	}{
+		{"test48k.sna", false},
+		{"test128k.sna", true},
+		{"test48k.szx", false},
+		{"test128k.szx", true},
+		{"test48k.z80", false},
+		{"test128k.z80", true},
+	} {
+		cpu, mem, bus := newTestMachine(t, test.is128k)
+		cpu.State(testState)
+		banks := []int{5, 2, 0}
+		if test.is128k {
+			mem.PageMode(0x03)
+			banks = []int{0, 1, 2, 3, 4, 5, 6, 7}
+		}
+		for _, bank := range banks {
+			for i := range mem.Bank(bank) {
+				mem.Bank(bank)[i] = byte(bank + i)
+			}
+		}
+
+		model := machine.ZX48k
+		if test.is128k {
+			model = machine.ZX128k
+		}
+		file := filepath.Join(t.TempDir(), test.file)
+		err := SaveFile(file, model, cpu, mem, bus)
+		assert.Nil(t, err, test.file)
+
+		cpu2, mem2, bus2 := newTestMachine(t, test.is128k)
+		err = LoadFile(file, model, cpu2, mem2, bus2, &tape.Tape{})
+		assert.Nil(t, err, test.file)
+
+		if test.file == "test48k.sna" {
+			// PC has been pushed on the stack
+			*mem.Cells[testState.SP-2] = byte(testState.PC)
+			*mem.Cells[testState.SP-1] = byte(testState.PC >> 8)
+		}
+
+		assert.Equal(t, testState, cpu2.GetState(), test.file)
+		assert.Equal(t, mem.PagingMode(), mem2.PagingMode(), test.file)
+		for _, bank := range banks {
+			assert.True(t, *mem.Bank(bank) == *mem2.Bank(bank), "%s bank %d", test.file, bank)
+		}
+	}
+}
+
+func Test_SNA128kBanks(t *testing.T) {
+	for _, test := range []struct {
+		mode byte
+		size int
+	}{
+		{0x01, 131103}, // 5 remaining banks
+		{0x05, 147487}, // bank 5 is paged in twice, 6 remaining banks
+		{0x02, 147487},
+	} {
+		cpu, mem, bus := newTestMachine(t, true)
+		cpu.State(testState)
+		mem.PageMode(test.mode)
+		for bank := 0; bank < 8; bank++ {
+			for i := range mem.Bank(bank) {
+				mem.Bank(bank)[i] = byte(bank * i)
+			}
+		}
+		file := filepath.Join(t.TempDir(), "test.sna")
+		assert.Nil(t, SaveFile(file, machine.ZX128k, cpu, mem, bus))
+		data, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, test.size, len(data))
+
+		cpu2, mem2, bus2 := newTestMachine(t, true)
+		assert.Nil(t, LoadFile(file, machine.ZX128k, cpu2, mem2, bus2, &tape.Tape{}))
+		assert.Equal(t, test.mode, mem2.PagingMode())
+		for bank := 0; bank < 8; bank++ {
+			assert.True(t, *mem.Bank(bank) == *mem2.Bank(bank), "mode %02X bank %d", test.mode, bank)
+		}
+
+		// The size does not match the paged bank
+		other := 131103 + 147487 - test.size
+		data = append(data, make([]byte, 16384)...)[:other]
+		assert.Nil(t, ioutil.WriteFile(file, data, 0644))
+		assert.NotNil(t, LoadFile(file, machine.ZX128k, cpu2, mem2, bus2, &tape.Tape{}), "mode %02X", test.mode)
+	}
+}
+
+func Test_SZX(t *testing.T) {
+	cpu, mem, bus := newTestMachine(t, true)
+	state := *testState
+	state.Halt, state.EILast, state.T = true, true, 12345
+	cpu.State(&state)
+	bus.SetIssue2(true)
+	bus.SetULA(0x1A)
+	regs := [16]byte{0x12, 0x03, 0x34, 0x05, 0x56, 0x07, 0x1F, 0x38, 0x0F, 0x10, 0x0A, 0x34, 0x12, 0x0E, 0xFF, 0xFF}
+	bus.AY().SetRegs(0x07, regs)
+
+	file := filepath.Join(t.TempDir(), "test.szx")
+	err := SaveFile(file, machine.ZX128k, cpu, mem, bus)
+	assert.Nil(t, err)
+
+	cpu2, mem2, bus2 := newTestMachine(t, true)
+	err = LoadFile(file, machine.ZX128k, cpu2, mem2, bus2, &tape.Tape{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, &state, cpu2.GetState())
+	assert.Equal(t, true, bus2.Issue2())
+	assert.Equal(t, byte(0x1A), bus2.ULA())
+	assert.Equal(t, regs, bus2.AY().Regs())
+	assert.Equal(t, byte(0x07), bus2.AY().SelectedReg())
+}
+
+func Test_SZXJoystick(t *testing.T) {
+	for _, kind := range []int{joystick.Kempston, joystick.Sinclair1, joystick.Sinclair2, joystick.Cursor} {
+		cpu, mem, bus := newTestMachine(t, false)
+		cpu.State(testState)
+		bus.Attach(joystick.New(kind))
+		file := filepath.Join(t.TempDir(), "test.szx")
+		assert.Nil(t, SaveFile(file, machine.ZX48k, cpu, mem, bus))
+
+		// Attached to the bus without joystick, replaces the type of the attached one
+		cpu2, mem2, bus2 := newTestMachine(t, false)
+		assert.Nil(t, LoadFile(file, machine.ZX48k, cpu2, mem2, bus2, &tape.Tape{}))
+		joy := attachedJoystick(bus2)
+		if assert.NotNil(t, joy) {
+			assert.Equal(t, kind, joy.Type())
+		}
+		cpu3, mem3, bus3 := newTestMachine(t, false)
+		joy = joystick.New(joystick.None)
+		bus3.Attach(joy)
+		assert.Nil(t, LoadFile(file, machine.ZX48k, cpu3, mem3, bus3, &tape.Tape{}))
+		assert.Equal(t, kind, joy.Type())
+		assert.Equal(t, joy, attachedJoystick(bus3))
+	}
+
+	// No joystick, no block
+	cpu, mem, bus := newTestMachine(t, false)
+	cpu.State(testState)
+	file := filepath.Join(t.TempDir(), "test.szx")
+	assert.Nil(t, SaveFile(file, machine.ZX48k, cpu, mem, bus))
+	cpu2, mem2, bus2 := newTestMachine(t, false)
+	assert.Nil(t, LoadFile(file, machine.ZX48k, cpu2, mem2, bus2, &tape.Tape{}))
+	assert.Nil(t, attachedJoystick(bus2))
+}
+
+func Test_SaveModel(t *testing.T) {
+	rom := filepath.Join(t.TempDir(), "test.rom")
+	assert.Nil(t, ioutil.WriteFile(rom, make([]byte, 0x4000), 0644))
+
+	for _, test := range []struct {
+		model    *machine.Machine
+		szx, z80 byte
+		flags    byte
+	}{
+		{machine.ZX16k, zxstmid_16k, z80Mode48k, z80Modified},
+		{machine.ZX48k, zxstmid_48k, z80Mode48k, 0},
+		{machine.ZX128k, zxstmid_128k, z80Mode128k, 0x04},
+		{machine.ZXPlus2, zxstmid_plus2, z80ModePlus2, 0x04},
+		{machine.ZXPlus2A, zxstmid_plus2a, z80ModePlus2A, 0x04},
+		{machine.ZXPlus3, zxstmid_plus3, z80ModePlus3, 0x04},
+		{machine.Pentagon, zxstmid_pentagon, z80ModePentagon, 0x04},
+	} {
+		var mem *memory.Memory
+		var err error
+		switch {
+		case test.model.Plus3:
+			mem, err = memory.NewMemPlus3([4]string{rom, rom, rom, rom})
+		case test.model.RAM == 128:
+			mem, err = memory.NewMem128k(rom, rom)
+		case test.model.RAM == 16:
+			mem, err = memory.NewMem16k(rom)
+		default:
+			mem, err = memory.NewMem48k(rom)
+		}
+		assert.Nil(t, err)
+		cpu := z80.NewZ80(mem)
+		mem.TC = cpu.TC
+		bus := bus.NewBus(test.model, cpu.TC, mem, &tape.Tape{})
+		quarter := test.model.FrameStates / 4
+		cpu.State(&z80.CPUState{T: quarter + 1})
+
+		file := filepath.Join(t.TempDir(), "test.szx")
+		assert.Nil(t, SaveFile(file, test.model, cpu, mem, bus))
+		data, _ := ioutil.ReadFile(file)
+		assert.Equal(t, test.szx, data[6], test.model.Name)
+
+		// T state counter counts down in the second quarter of the frame
+		file = filepath.Join(t.TempDir(), "test.z80")
+		assert.Nil(t, SaveFile(file, test.model, cpu, mem, bus))
+		data, _ = ioutil.ReadFile(file)
+		assert.Equal(t, test.z80, data[34], test.model.Name)
+		assert.Equal(t, test.flags, data[37], test.model.Name)
+		assert.Equal(t, quarter-2, int(data[55])|int(data[56])<<8, test.model.Name)
+		assert.Equal(t, byte(0), data[57], test.model.Name)
+
+		// Loaded machine continues at the same T state of the frame
+		cpu.State(&z80.CPUState{})
+		assert.Nil(t, LoadFile(file, test.model, cpu, mem, bus, &tape.Tape{}))
+		assert.Equal(t, quarter+1, cpu.GetState().T, test.model.Name)
+	}
+}
+
+// Returns the version 1 Z80 header (registers of testState)
+func z80Header(pc uint16, flags byte) []byte {
+	s := testState
+	return []byte{
+		byte(s.AF >> 8), byte(s.AF), byte(s.BC), byte(s.BC >> 8), byte(s.HL), byte(s.HL >> 8),
+		byte(pc), byte(pc >> 8), byte(s.SP), byte(s.SP >> 8), s.I, s.R & 0x7F, s.R>>7 | flags,
+		byte(s.DE), byte(s.DE >> 8), byte(s.BC_), byte(s.BC_ >> 8), byte(s.DE_), byte(s.DE_ >> 8),
+		byte(s.HL_), byte(s.HL_ >> 8), byte(s.AF_ >> 8), byte(s.AF_), byte(s.IY), byte(s.IY >> 8),
+		byte(s.IX), byte(s.IX >> 8), 1, 1, s.IM,
+	}
+}
+
+func Test_Z80Versions(t *testing.T) {
+	// 48k memory: bank 5 filled with 0x11, bank 2 with 0x22 and 0xED, bank 0 with 0x00
+	mem48k := make([]byte, 0xC000)
+	for i := range mem48k[:0x4000] {
+		mem48k[i] = 0x11
+	}
+	for i := 0x4000; i < 0x8000; i++ {
+		mem48k[i] = []byte{0x22, 0xED}[i&1]
+	}
+
+	// Version 1: RLE compressed 48k memory followed by the end marker
+	v1 := append(z80Header(testState.PC, 0x20|0x02<<1), compressZ80(mem48k)...)
+	v1 = append(v1, 0x00, 0xED, 0xED, 0x00)
+
+	// Version 2: 23 bytes additional header, pages 4 (bank 2, uncompressed),
+	// 5 (bank 0) and 8 (bank 5)
+	v2 := append(z80Header(0, 0x02<<1), z80V2HeaderSize, 0)
+	v2 = append(v2, make([]byte, z80V2HeaderSize)...)
+	v2[32], v2[33] = byte(testState.PC), byte(testState.PC>>8)
+	v2[34] = 0 // 48k
+	for _, page := range []struct {
+		page  byte
+		block []byte
+	}{
+		{4, nil},
+		{5, compressZ80(mem48k[0x8000:0xC000])},
+		{8, compressZ80(mem48k[0x0000:0x4000])},
+	} {
+		if page.block == nil {
+			v2 = append(v2, 0xFF, 0xFF, page.page)
+			v2 = append(v2, mem48k[0x4000:0x8000]...)
+			continue
+		}
+		v2 = append(v2, byte(len(page.block)), byte(len(page.block)>>8), page.page)
+		v2 = append(v2, page.block...)
+	}
+
+	for name, data := range map[string][]byte{"v1": v1, "v2": v2} {
+		file := filepath.Join(t.TempDir(), "test.z80")
+		assert.Nil(t, ioutil.WriteFile(file, data, 0644))
+		cpu, mem, bus := newTestMachine(t, false)
+		assert.Nil(t, LoadFile(file, machine.ZX48k, cpu, mem, bus, &tape.Tape{}), name)
+
+		assert.Equal(t, testState, cpu.GetState(), name)
+		assert.Equal(t, byte(0x02), bus.ULA(), name)
+		for i, b := range mem48k {
+			if *mem.Cells[0x4000+i] != b {
+				assert.Fail(t, "memory differs", "%s %04X", name, 0x4000+i)
+				break
+			}
+		}
+	}
+
+	// Version 1 without the end marker is still loaded, truncated memory is not
+	file := filepath.Join(t.TempDir(), "test.z80")
+	assert.Nil(t, ioutil.WriteFile(file, v1[:len(v1)-4], 0644))
+	cpu, mem, bus := newTestMachine(t, false)
+	assert.Nil(t, LoadFile(file, machine.ZX48k, cpu, mem, bus, &tape.Tape{}))
+	assert.Nil(t, ioutil.WriteFile(file, v1[:len(v1)-100], 0644))
+	assert.NotNil(t, LoadFile(file, machine.ZX48k, cpu, mem, bus, &tape.Tape{}))
+}
+
+func Test_compressZ80(t *testing.T) {
+	data := []byte{1, 2, 2, 2, 2, 2, 3, 0xED, 0xED, 0xED, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	assert.Equal(t,
+		[]byte{1, 0xED, 0xED, 5, 2, 3, 0xED, 0xED, 3, 0xED, 0xED, 0xED, 6, 0x00},
+		compressZ80(data))
+
+	data = []byte{0xED, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	assert.Equal(t, []byte{0xED, 0x00, 0xED, 0xED, 5, 0x00}, compressZ80(data))
+}
+
+func Test_decompressZ80(t *testing.T) {
+	data := []byte{1, 0xED, 0xED, 5, 2, 3, 0xED, 0xED, 3, 0xED, 0xED, 0xED, 6, 0x00}
+	assert.Equal(t,
+		[]byte{1, 2, 2, 2, 2, 2, 3, 0xED, 0xED, 0xED, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		decompressZ80(data, 0x4000))
+
+	// Version 1 end marker
+	data = []byte{0xED, 0x00, 0x01, 0x00, 0xED, 0xED, 0x00}
+	assert.Equal(t, []byte{0xED, 0x00, 0x01}, decompressZ80(data, 0xC000))
+}
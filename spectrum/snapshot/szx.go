@@ -6,9 +6,14 @@ import (
 	"errors"
 	"io/ioutil"
 	"log"
+	"strings"
 
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
 	"github.com/voytas/z80-go-zx/spectrum/memory"
 	"github.com/voytas/z80-go-zx/spectrum/screen"
+	"github.com/voytas/z80-go-zx/spectrum/tape"
 	"github.com/voytas/z80-go-zx/z80"
 )
 
@@ -16,7 +21,24 @@ const (
 	zxstmid_16k       = 0
 	zxstmid_48k       = 1
 	zxstmid_128k      = 2
+	zxstmid_plus2     = 3
+	zxstmid_plus2a    = 4
+	zxstmid_plus3     = 5
+	zxstmid_pentagon  = 7
 	zxstrf_compressed = 1
+	zxstzf_eilast     = 1
+	zxstzf_halted     = 2
+	zxstayf_128ay     = 2
+	zxstkf_issue2     = 1
+	zxjt_kempston     = 0
+	zxjt_cursor       = 2
+	zxjt_sinclair1    = 3
+	zxjt_sinclair2    = 4
+	zxjt_none         = 8
+	zxsttp_embedded   = 1
+	zxsttp_compressed = 2
+	szxMajorVersion   = 1
+	szxMinorVersion   = 4
 )
 
 type SZX struct {
@@ -31,65 +53,115 @@ type szxBlock struct {
 	data []byte
 }
 
-func (szx *SZX) Load(file string, cpu *z80.Z80, mem *memory.Memory) error {
+// Expected minimum size of the supported blocks
+var szxBlockSizes = map[string]int{
+	"Z80R":       37,
+	"SPCR":       8,
+	"AY00":       18,
+	"RAMP":       3,
+	"KEYB":       5,
+	"JOY\x00":    6,
+	"B128":       10,
+	"+3\x00\x00": 2,
+	"TAPE":       28,
+}
+
+// Loads SZX file to memory and updates the CPU state so it is ready to run.
+// Embedded tape (if present) is loaded to the tape.
+func (szx *SZX) Load(file string, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus, tape *tape.Tape) error {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
 	szx.data = data
 
-	if szx.dwToId(data[0:4]) != "ZXST" {
+	if len(data) < 8 || szx.dwToId(data[0:4]) != "ZXST" {
 		return errors.New("Not a valid SZX file")
 	}
 
 	machine := data[6]
+	is48k := false
 	switch machine {
-	case zxstmid_16k:
-	case zxstmid_48k:
-	case zxstmid_128k:
+	case zxstmid_16k, zxstmid_48k:
+		is48k = true
+	case zxstmid_128k, zxstmid_plus2, zxstmid_plus2a, zxstmid_plus3, zxstmid_pentagon:
+		if !mem.Is128k() {
+			return errors.New("Snapshot requires 128k model")
+		}
 	default:
 		return errors.New("Snapshot is for not supported model")
 	}
 
 	for {
-		block := szx.readNextBlock()
+		block, err := szx.readNextBlock()
+		if err != nil {
+			return err
+		}
 		if block == nil {
 			break
 		}
+		if block.size < szxBlockSizes[block.id] {
+			return errors.New("Invalid SZX block " + block.id)
+		}
 
 		switch block.id {
 		case "Z80R":
 			szx.processZ80(block)
 		case "AY00":
-			szx.processAY(block)
+			szx.processAY(block, bus)
 		case "RAMP":
 			err := szx.processRAMPage(block, mem)
 			if err != nil {
 				return errors.New("Error reading memory block")
 			}
 		case "SPCR":
-			szx.processULA(block, mem)
+			szx.processULA(block, mem, bus)
+		case "KEYB":
+			szx.processKeyboard(block, bus)
+		case "TAPE":
+			err := szx.processTape(block, tape)
+			if err != nil {
+				return errors.New("Error reading tape block")
+			}
+		case "JOY\x00":
+			szx.processJoystick(block, bus)
+		case "B128", "+3\x00\x00":
+			// Recognised, but the hardware (Beta 128 or +3 disk) is not emulated
+			log.Printf("SZX block '%s' ignored, hardware not emulated", strings.TrimRight(block.id, "\x00"))
+		default:
+			log.Printf("SZX block '%s' not supported", strings.TrimRight(block.id, "\x00"))
 		}
+	}
 
-		log.Print(block.id)
+	if is48k && mem.Is128k() {
+		lock48k(mem)
 	}
 
+	if szx.state == nil {
+		return errors.New("SZX file does not contain CPU registers")
+	}
 	cpu.State(szx.state)
 
 	return nil
 }
 
 // Read the next block from the snapshot. Returns nil if there are no more blocks.
-func (szx *SZX) readNextBlock() *szxBlock {
+func (szx *SZX) readNextBlock() (*szxBlock, error) {
 	if szx.offset == 0 {
 		szx.offset = 8
 	}
 
 	if szx.offset >= len(szx.data) {
-		return nil
+		return nil, nil
+	}
+	if szx.offset+8 > len(szx.data) {
+		return nil, errors.New("SZX file is truncated")
 	}
 
 	size := szx.dwToInt(szx.data[szx.offset+4 : szx.offset+8])
+	if size < 0 || szx.offset+8+size > len(szx.data) {
+		return nil, errors.New("SZX file is truncated")
+	}
 	block := &szxBlock{
 		id:   szx.dwToId(szx.data[szx.offset : szx.offset+4]),
 		size: size,
@@ -97,57 +169,60 @@ func (szx *SZX) readNextBlock() *szxBlock {
 	}
 	szx.offset += 8 + size
 
-	return block
+	return block, nil
 }
 
 // Converts DW to string identifier
 func (szx *SZX) dwToId(dw []byte) string {
-	return string(dw[0]) + string(dw[1]) + string(dw[2]) + string(dw[3])
+	return string(dw[0:4])
 }
 
 // Converts DW to integer
 func (szx *SZX) dwToInt(dw []byte) int {
-	return int(dw[0]) + int(dw[1])<<8 + int(dw[2])>>16 + int(dw[3])<<24
+	return int(dw[0]) | int(dw[1])<<8 | int(dw[2])<<16 | int(dw[3])<<24
 }
 
 // ZXSTZ80REGS block
 func (szx *SZX) processZ80(block *szxBlock) {
 	szx.state = &z80.CPUState{
-		AF:   uint16(block.data[0]) | uint16(block.data[1])<<8,
-		BC:   uint16(block.data[2]) | uint16(block.data[3])<<8,
-		DE:   uint16(block.data[4]) | uint16(block.data[5])<<8,
-		HL:   uint16(block.data[6]) | uint16(block.data[7])<<8,
-		AF_:  uint16(block.data[8]) | uint16(block.data[9])<<8,
-		BC_:  uint16(block.data[10]) | uint16(block.data[11])<<8,
-		DE_:  uint16(block.data[12]) | uint16(block.data[13])<<8,
-		HL_:  uint16(block.data[14]) | uint16(block.data[15])<<8,
-		IX:   uint16(block.data[16]) | uint16(block.data[17])<<8,
-		IY:   uint16(block.data[18]) | uint16(block.data[19])<<8,
-		SP:   uint16(block.data[20]) | uint16(block.data[21])<<8,
-		PC:   uint16(block.data[22]) | uint16(block.data[23])<<8,
-		I:    block.data[24],
-		R:    block.data[25],
-		IFF1: block.data[26] != 0,
-		IFF2: block.data[27] != 0,
-		IM:   block.data[28],
-	}
-}
-
-// ZXSTAYBLOCK page
-func (szx *SZX) processAY(block *szxBlock) {
-	// TODO: set AY state
+		AF:     uint16(block.data[0]) | uint16(block.data[1])<<8,
+		BC:     uint16(block.data[2]) | uint16(block.data[3])<<8,
+		DE:     uint16(block.data[4]) | uint16(block.data[5])<<8,
+		HL:     uint16(block.data[6]) | uint16(block.data[7])<<8,
+		AF_:    uint16(block.data[8]) | uint16(block.data[9])<<8,
+		BC_:    uint16(block.data[10]) | uint16(block.data[11])<<8,
+		DE_:    uint16(block.data[12]) | uint16(block.data[13])<<8,
+		HL_:    uint16(block.data[14]) | uint16(block.data[15])<<8,
+		IX:     uint16(block.data[16]) | uint16(block.data[17])<<8,
+		IY:     uint16(block.data[18]) | uint16(block.data[19])<<8,
+		SP:     uint16(block.data[20]) | uint16(block.data[21])<<8,
+		PC:     uint16(block.data[22]) | uint16(block.data[23])<<8,
+		I:      block.data[24],
+		R:      block.data[25],
+		IFF1:   block.data[26] != 0,
+		IFF2:   block.data[27] != 0,
+		IM:     block.data[28],
+		T:      szx.dwToInt(block.data[29:33]),
+		EILast: block.data[34]&zxstzf_eilast != 0,
+		Halt:   block.data[34]&zxstzf_halted != 0,
+	}
+}
+
+// ZXSTAYBLOCK block
+func (szx *SZX) processAY(block *szxBlock, bus *bus.Bus) {
+	regs := [16]byte{}
+	copy(regs[:], block.data[2:18])
+	bus.AY().SetRegs(block.data[1], regs)
 }
 
 // ZXSTRAMPAGE block
 func (szx *SZX) processRAMPage(block *szxBlock, mem *memory.Memory) error {
 	page := int(block.data[2])
+	if page > 7 {
+		return errors.New("Invalid memory page")
+	}
 	if block.data[0]&zxstrf_compressed != 0 {
-		r, err := zlib.NewReader(bytes.NewReader(block.data[3:]))
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-		data, err := ioutil.ReadAll(r)
+		data, err := szx.decompress(block.data[3:])
 		if err != nil {
 			return err
 		}
@@ -160,7 +235,186 @@ func (szx *SZX) processRAMPage(block *szxBlock, mem *memory.Memory) error {
 }
 
 // ZXSTSPECREGS block
-func (szx *SZX) processULA(block *szxBlock, mem *memory.Memory) {
+func (szx *SZX) processULA(block *szxBlock, mem *memory.Memory, bus *bus.Bus) {
+	bus.SetULA(block.data[3])
 	screen.BorderColour(block.data[0], 0)
+	// Port 0x1FFD first, as port 0x7FFD may lock paging
+	mem.PageModePlus3(block.data[2])
 	mem.PageMode(block.data[1])
 }
+
+// ZXSTKEYBOARD block, keyboard joystick is not emulated
+func (szx *SZX) processKeyboard(block *szxBlock, bus *bus.Bus) {
+	bus.SetIssue2(szx.dwToInt(block.data[0:4])&zxstkf_issue2 != 0)
+}
+
+// Joystick interfaces of ZXSTJOYSTICK block
+var szxJoysticks = map[byte]int{
+	zxjt_kempston:  joystick.Kempston,
+	zxjt_cursor:    joystick.Cursor,
+	zxjt_sinclair1: joystick.Sinclair1,
+	zxjt_sinclair2: joystick.Sinclair2,
+	zxjt_none:      joystick.None,
+}
+
+// ZXSTJOYSTICK block, the player 1 joystick is attached to the bus (or
+// replaces the type of the attached one), player 2 is not emulated
+func (szx *SZX) processJoystick(block *szxBlock, bus *bus.Bus) {
+	kind, ok := szxJoysticks[block.data[4]]
+	if !ok {
+		log.Printf("SZX joystick %d not supported", block.data[4])
+		return
+	}
+	if joy := attachedJoystick(bus); joy != nil {
+		joy.SetType(kind)
+	} else if kind != joystick.None {
+		bus.Attach(joystick.New(kind))
+	}
+}
+
+// Returns the joystick attached to the bus, nil if there is none
+func attachedJoystick(bus *bus.Bus) *joystick.Joystick {
+	for _, d := range bus.Devices() {
+		if joy, ok := d.(*joystick.Joystick); ok {
+			return joy
+		}
+	}
+	return nil
+}
+
+// ZXSTTAPE block, only embedded tape is supported
+func (szx *SZX) processTape(block *szxBlock, tape *tape.Tape) error {
+	current := int(block.data[0]) | int(block.data[1])<<8
+	flags := int(block.data[2]) | int(block.data[3])<<8
+	if flags&zxsttp_embedded == 0 {
+		log.Print("SZX tape is not embedded, tape file is not loaded")
+		return nil
+	}
+
+	ext := "." + strings.TrimLeft(strings.TrimRight(string(block.data[12:28]), "\x00"), ".")
+	data := block.data[28:]
+	if flags&zxsttp_compressed != 0 {
+		var err error
+		data, err = szx.decompress(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tape.LoadData(data, ext); err != nil {
+		return err
+	}
+	tape.Seek(current)
+
+	return nil
+}
+
+// Decompresses zlib compressed data
+func (szx *SZX) decompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Saves the CPU state, memory, ULA and AY registers as SZX file
+func (szx *SZX) Save(file string, model *machine.Machine, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
+	buf := &bytes.Buffer{}
+
+	// Header
+	id := []byte{
+		model16k:      zxstmid_16k,
+		model48k:      zxstmid_48k,
+		model128k:     zxstmid_128k,
+		modelPlus2:    zxstmid_plus2,
+		modelPlus2A:   zxstmid_plus2a,
+		modelPlus3:    zxstmid_plus3,
+		modelPentagon: zxstmid_pentagon,
+	}[modelType(model)]
+	buf.WriteString("ZXST")
+	buf.Write([]byte{szxMajorVersion, szxMinorVersion, id, 0})
+
+	// ZXSTZ80REGS block
+	state := cpu.GetState()
+	regs := []byte{}
+	for _, rr := range []uint16{
+		state.AF, state.BC, state.DE, state.HL,
+		state.AF_, state.BC_, state.DE_, state.HL_,
+		state.IX, state.IY, state.SP, state.PC,
+	} {
+		regs = append(regs, byte(rr), byte(rr>>8))
+	}
+	flags := byte(0)
+	if state.EILast {
+		flags |= zxstzf_eilast
+	}
+	if state.Halt {
+		flags |= zxstzf_halted
+	}
+	regs = append(regs, state.I, state.R, boolToByte(state.IFF1), boolToByte(state.IFF2), state.IM)
+	regs = append(regs, byte(state.T), byte(state.T>>8), byte(state.T>>16), byte(state.T>>24))
+	regs = append(regs, 0, flags, 0, 0)
+	szx.writeBlock(buf, "Z80R", regs)
+
+	// ZXSTSPECREGS block
+	szx.writeBlock(buf, "SPCR", []byte{screen.Border(), mem.PagingMode(), mem.PagingModePlus3(), bus.ULA(), 0, 0, 0, 0})
+
+	// ZXSTKEYBOARD block
+	keyb := []byte{0, 0, 0, 0, 0}
+	if bus.Issue2() {
+		keyb[0] = zxstkf_issue2
+	}
+	szx.writeBlock(buf, "KEYB", keyb)
+
+	// ZXSTJOYSTICK block
+	if joy := attachedJoystick(bus); joy != nil {
+		for id, kind := range szxJoysticks {
+			if kind == joy.Type() {
+				szx.writeBlock(buf, "JOY\x00", []byte{0, 0, 0, 0, id, zxjt_none})
+			}
+		}
+	}
+
+	// ZXSTAYBLOCK block
+	if mem.Is128k() {
+		ayRegs := bus.AY().Regs()
+		szx.writeBlock(buf, "AY00", append([]byte{zxstayf_128ay, bus.AY().SelectedReg()}, ayRegs[:]...))
+	}
+
+	// ZXSTRAMPAGE blocks
+	pages := []int{5, 2, 0}
+	if mem.Is128k() {
+		pages = []int{0, 1, 2, 3, 4, 5, 6, 7}
+	}
+	for _, page := range pages {
+		data := &bytes.Buffer{}
+		w := zlib.NewWriter(data)
+		if _, err := w.Write(mem.Bank(page)[:]); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		szx.writeBlock(buf, "RAMP", append([]byte{zxstrf_compressed, 0, byte(page)}, data.Bytes()...))
+	}
+
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// Writes the block with specified identifier and data
+func (szx *SZX) writeBlock(buf *bytes.Buffer, id string, data []byte) {
+	size := len(data)
+	buf.WriteString(id)
+	buf.Write([]byte{byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)})
+	buf.Write(data)
+}
+
+// Converts boolean value to byte
+func boolToByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,336 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/memory"
+	"github.com/voytas/z80-go-zx/spectrum/screen"
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+const (
+	z80HeaderSize   = 30   // version 1 header length
+	z80V2HeaderSize = 23   // version 2 additional header length
+	z80V3HeaderSize = 54   // version 3 additional header length
+	z80V3PortSize   = 55   // version 3 additional header length with port 0x1FFD value
+	z80Mode48k      = 0    // hardware mode 48k (version 3)
+	z80Mode128k     = 4    // hardware mode 128k (version 2 and 3)
+	z80ModePlus3    = 7    // hardware mode +3 (version 3)
+	z80ModePentagon = 9    // hardware mode Pentagon 128k (version 3)
+	z80ModePlus2    = 12   // hardware mode +2 (version 3)
+	z80ModePlus2A   = 13   // hardware mode +2A (version 3)
+	z80Modified     = 0x80 // byte 37 flag, modifies 48k to 16k
+)
+
+type Z80 struct{}
+
+// Loads Z80 file (version 1, 2 or 3) to memory and updates the CPU state so it is ready to run
+func (z *Z80) Load(file string, model *machine.Machine, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if len(data) < z80HeaderSize {
+		return errors.New("Not a valid Z80 file")
+	}
+
+	// Byte 12 equal to 255 should be treated as 1 for compatibility
+	flags := data[12]
+	if flags == 0xFF {
+		flags = 0x01
+	}
+
+	state := &z80.CPUState{
+		AF:   uint16(data[0])<<8 | uint16(data[1]),
+		BC:   uint16(data[3])<<8 | uint16(data[2]),
+		HL:   uint16(data[5])<<8 | uint16(data[4]),
+		PC:   uint16(data[7])<<8 | uint16(data[6]),
+		SP:   uint16(data[9])<<8 | uint16(data[8]),
+		I:    data[10],
+		R:    data[11]&0x7F | flags<<7,
+		DE:   uint16(data[14])<<8 | uint16(data[13]),
+		BC_:  uint16(data[16])<<8 | uint16(data[15]),
+		DE_:  uint16(data[18])<<8 | uint16(data[17]),
+		HL_:  uint16(data[20])<<8 | uint16(data[19]),
+		AF_:  uint16(data[21])<<8 | uint16(data[22]),
+		IY:   uint16(data[24])<<8 | uint16(data[23]),
+		IX:   uint16(data[26])<<8 | uint16(data[25]),
+		IFF1: data[27] != 0,
+		IFF2: data[28] != 0,
+		IM:   data[29] & 0x03,
+	}
+
+	if state.PC != 0 {
+		// Version 1, 48k memory only
+		mem48k := data[z80HeaderSize:]
+		if flags&0x20 != 0 {
+			mem48k = decompressZ80(mem48k, 0xC000)
+		}
+		if len(mem48k) < 0xC000 {
+			return errors.New("Z80 file memory block is invalid")
+		}
+		if mem.Is128k() {
+			lock48k(mem)
+		}
+		mem.LoadBank(5, mem48k[0x0000:0x4000])
+		mem.LoadBank(2, mem48k[0x4000:0x8000])
+		mem.LoadBank(0, mem48k[0x8000:0xC000])
+	} else {
+		// Version 2 or 3
+		if len(data) < z80HeaderSize+2 {
+			return errors.New("Not a valid Z80 file")
+		}
+		length := int(data[30]) | int(data[31])<<8
+		offset := z80HeaderSize + 2 + length
+		if length < z80V2HeaderSize || len(data) < offset {
+			return errors.New("Not a valid Z80 file")
+		}
+		state.PC = uint16(data[33])<<8 | uint16(data[32])
+		if length >= z80V3HeaderSize {
+			state.T = z.frameStates(model, data[55:58])
+		}
+
+		is128k, err := z.is128k(data[34], length == z80V2HeaderSize)
+		if err != nil {
+			return err
+		}
+		if is128k && !mem.Is128k() {
+			return errors.New("Snapshot requires 128k model")
+		}
+
+		if is128k {
+			if length == z80V3PortSize {
+				// Port 0x1FFD first, as port 0x7FFD may lock paging
+				mem.PageModePlus3(data[86])
+			}
+			mem.PageMode(data[35])
+			regs := [16]byte{}
+			copy(regs[:], data[39:55])
+			bus.AY().SetRegs(data[38], regs)
+		} else if mem.Is128k() {
+			lock48k(mem)
+		}
+
+		// Memory blocks
+		for offset+3 <= len(data) {
+			size := int(data[offset]) | int(data[offset+1])<<8
+			page := int(data[offset+2])
+			offset += 3
+
+			var block []byte
+			if size == 0xFFFF {
+				size = 0x4000
+				if offset+size > len(data) {
+					return errors.New("Z80 file memory block is invalid")
+				}
+				block = data[offset : offset+size]
+			} else {
+				if offset+size > len(data) {
+					return errors.New("Z80 file memory block is invalid")
+				}
+				block = decompressZ80(data[offset:offset+size], 0x4000)
+			}
+			offset += size
+
+			bank := -1
+			if is128k {
+				bank = page - 3
+			} else {
+				switch page {
+				case 4:
+					bank = 2
+				case 5:
+					bank = 0
+				case 8:
+					bank = 5
+				}
+			}
+			if bank >= 0 && bank < 8 {
+				mem.LoadBank(bank, block)
+			}
+		}
+	}
+
+	// Restore border colour
+	bus.SetULA(flags >> 1 & 0x07)
+
+	// Set CPU state
+	cpu.State(state)
+
+	return nil
+}
+
+// Returns the T state of the frame from the version 3 T state counter, the
+// low counter counts down in each quarter of the frame
+func (z *Z80) frameStates(model *machine.Machine, counter []byte) int {
+	quarter := model.FrameStates / 4
+	lo := int(counter[0]) | int(counter[1])<<8
+	if lo >= quarter {
+		return 0
+	}
+	return (int(counter[2])+1)%4*quarter + quarter - 1 - lo
+}
+
+// Checks whether hardware mode is 128k model, only the models
+// compatible with 48k or 128k memory layout are supported
+func (z *Z80) is128k(mode byte, v2 bool) (bool, error) {
+	if v2 {
+		switch mode {
+		case 0, 1:
+			return false, nil
+		case 3, 4:
+			return true, nil
+		}
+	} else {
+		switch mode {
+		case 0, 1, 3:
+			return false, nil
+		case 4, 5, 6, 7, 8, 9, 12, 13:
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("Snapshot hardware mode %d is not supported", mode)
+}
+
+// Saves the CPU state, memory and AY registers as version 3 Z80 file
+func (z *Z80) Save(file string, model *machine.Machine, cpu *z80.Z80, mem *memory.Memory, bus *bus.Bus) error {
+	state := cpu.GetState()
+	if state.Halt {
+		// Execute HALT again when snapshot is loaded
+		state.PC -= 1
+	}
+
+	length := z80V3HeaderSize
+	if mem.IsPlus3() {
+		length = z80V3PortSize
+	}
+	data := make([]byte, z80HeaderSize+2+length)
+	data[0], data[1] = byte(state.AF>>8), byte(state.AF)
+	data[2], data[3] = byte(state.BC), byte(state.BC>>8)
+	data[4], data[5] = byte(state.HL), byte(state.HL>>8)
+	// PC (bytes 6 and 7) is 0 for version 2 and 3
+	data[8], data[9] = byte(state.SP), byte(state.SP>>8)
+	data[10] = state.I
+	data[11] = state.R & 0x7F
+	data[12] = state.R>>7 | screen.Border()<<1
+	data[13], data[14] = byte(state.DE), byte(state.DE>>8)
+	data[15], data[16] = byte(state.BC_), byte(state.BC_>>8)
+	data[17], data[18] = byte(state.DE_), byte(state.DE_>>8)
+	data[19], data[20] = byte(state.HL_), byte(state.HL_>>8)
+	data[21], data[22] = byte(state.AF_>>8), byte(state.AF_)
+	data[23], data[24] = byte(state.IY), byte(state.IY>>8)
+	data[25], data[26] = byte(state.IX), byte(state.IX>>8)
+	data[27] = boolToByte(state.IFF1)
+	data[28] = boolToByte(state.IFF2)
+	data[29] = state.IM & 0x03
+
+	// Version 3 additional header
+	data[30] = byte(length)
+	data[32], data[33] = byte(state.PC), byte(state.PC>>8)
+
+	// T state counter, low counter counts down in each quarter of the frame
+	quarter := model.FrameStates / 4
+	lo := quarter - 1 - state.T%quarter
+	data[55], data[56] = byte(lo), byte(lo>>8)
+	data[57] = byte((state.T/quarter + 3) % 4)
+
+	// Memory pages
+	pages := map[int]int{4: 2, 5: 0, 8: 5}
+	if mem.Is128k() {
+		data[34] = []byte{
+			model128k:     z80Mode128k,
+			modelPlus2:    z80ModePlus2,
+			modelPlus2A:   z80ModePlus2A,
+			modelPlus3:    z80ModePlus3,
+			modelPentagon: z80ModePentagon,
+		}[modelType(model)]
+		if mem.IsPlus3() {
+			data[86] = mem.PagingModePlus3()
+		}
+		data[35] = mem.PagingMode()
+		data[37] = 0x04 // AY sound in use
+		data[38] = bus.AY().SelectedReg()
+		regs := bus.AY().Regs()
+		copy(data[39:55], regs[:])
+
+		pages = map[int]int{}
+		for bank := 0; bank < 8; bank++ {
+			pages[bank+3] = bank
+		}
+	} else {
+		data[34] = z80Mode48k
+		if modelType(model) == model16k {
+			data[37] = z80Modified
+		}
+	}
+
+	buf := bytes.NewBuffer(data)
+	for page := 3; page <= 10; page++ {
+		bank, ok := pages[page]
+		if !ok {
+			continue
+		}
+		block := compressZ80(mem.Bank(bank)[:])
+		buf.Write([]byte{byte(len(block)), byte(len(block) >> 8), byte(page)})
+		buf.Write(block)
+	}
+
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// Decompresses the data using RLE compression: ED ED xx yy means byte yy repeated
+// xx times. Stops when size bytes have been decompressed or the version 1 end
+// marker (00 ED ED 00) has been reached.
+func decompressZ80(data []byte, size int) []byte {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(data) && len(out) < size; {
+		if i+3 < len(data) && data[i] == 0x00 && data[i+1] == 0xED && data[i+2] == 0xED && data[i+3] == 0x00 {
+			break
+		}
+		if i+3 < len(data) && data[i] == 0xED && data[i+1] == 0xED {
+			for n := 0; n < int(data[i+2]); n++ {
+				out = append(out, data[i+3])
+			}
+			i += 4
+			continue
+		}
+		out = append(out, data[i])
+		i++
+	}
+
+	return out
+}
+
+// Compresses the data using RLE compression: repetitions of at least five
+// equal bytes (or two 0xED bytes) are coded as ED ED xx yy, meaning byte yy
+// repeated xx times. A single ED byte is always followed by a literal byte.
+func compressZ80(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		b := data[i]
+		run := 1
+		for i+run < len(data) && data[i+run] == b && run < 255 {
+			run++
+		}
+
+		if run >= 5 || b == 0xED && run >= 2 {
+			out = append(out, 0xED, 0xED, byte(run), b)
+			i += run
+			continue
+		}
+
+		out = append(out, b)
+		i++
+		if b == 0xED && i < len(data) {
+			out = append(out, data[i])
+			i++
+		}
+	}
+
+	return out
+}
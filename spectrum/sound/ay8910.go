@@ -26,13 +26,12 @@ const (
 	regPortA      = 0x0E // I/O port A
 	regPortB      = 0x0F // I/O port B
 
-	channelA        = 0
-	channelB        = 1
-	channelC        = 2
-	numChannels     = 3
-	maxAmplitude    = 10900
-	ampModeFixed    = 0
-	ampModeVariable = 1
+	channelA      = 0
+	channelB      = 1
+	channelC      = 2
+	numChannels   = 3
+	maxAmplitude  = 10900
+	statesPerTick = 16 // AY clock is half of the CPU clock, generators are updated every 8 AY cycles
 )
 
 var volRates = [16]float32{
@@ -41,14 +40,26 @@ var volRates = [16]float32{
 }
 var volLevels [16]float32
 
-type ayCommand struct {
-}
+// Amplitudes of the channels A, B and C
+type aySample [numChannels]float32
 
+// AY-3-8912 sound chip. The chip is clocked by the T states, so the output
+// is updated to the T state of each register write.
 type AY8910 struct {
 	reg      byte     // currently selected register
 	regs     [16]byte // all registers
 	tones    [numChannels]tone
+	noise    noise
 	envelope envelope
+	t        int64      // T state of the next tick
+	last     aySample   // current output
+	changes  []ayChange // output changes since the last read
+}
+
+// Output change at the T state
+type ayChange struct {
+	t      int64
+	sample aySample
 }
 
 func NewAY8910() *AY8910 {
@@ -56,6 +67,7 @@ func NewAY8910() *AY8910 {
 	for i, v := range volRates {
 		volLevels[i] = v * maxAmplitude
 	}
+	ay.noise.rng = 1
 	return ay
 }
 
@@ -71,6 +83,7 @@ func (ay *AY8910) writeReg(val byte) {
 		ay.tones[channelC].setPeriod(ay.regs[regFineC], ay.regs[regCoarseC])
 	case regNoise:
 		ay.regs[regNoise] &= 0x1F
+		ay.noise.period = ay.regs[regNoise]
 	case regEnable:
 		ay.tones[channelA].toneEnabled = val&0x01 == 0
 		ay.tones[channelA].noiseEnabled = val&0x08 == 0
@@ -79,43 +92,15 @@ func (ay *AY8910) writeReg(val byte) {
 		ay.tones[channelC].toneEnabled = val&0x04 == 0
 		ay.tones[channelC].noiseEnabled = val&0x20 == 0
 	case regAmplitudeA:
-		ay.tones[channelA].envEnabled = val&0x10 != 0
-		if ay.tones[channelA].envEnabled {
-			ay.tones[channelA].amplitude = volLevels[ay.envelope.amplitude]
-		} else {
-			ay.tones[channelA].amplitude = volLevels[val&0x0F]
-		}
+		ay.tones[channelA].setAmplitude(val)
 	case regAmplitudeB:
-		ay.tones[channelB].envEnabled = val&0x10 != 0
+		ay.tones[channelB].setAmplitude(val)
 	case regAmplitudeC:
-		ay.tones[channelC].envEnabled = val&0x10 != 0
+		ay.tones[channelC].setAmplitude(val)
 	case regFineE, regCoarseE:
 		ay.envelope.period = uint16(ay.regs[regCoarseE])<<8 | uint16(ay.regs[regFineE])
-		if ay.envelope.period == 0 {
-			ay.envelope.period = 2
-		} else {
-			ay.envelope.period <<= 1
-		}
 	case regShapeE:
-		ay.envelope.hold = val&0x01 != 0
-		ay.envelope.alternate = val&0x02 != 0
-		ay.envelope.attack = val&0x04 != 0
-		ay.envelope.cont = val&0x08 != 0
-		if ay.envelope.attack {
-			ay.envelope.amplitude = 0
-		} else {
-			ay.envelope.amplitude = 15
-		}
-
-		if ay.tones[channelA].envEnabled {
-			ay.tones[channelA].amplitude = 0
-		}
-		if ay.tones[channelB].envEnabled {
-			ay.tones[channelB].amplitude = 0
-		}
-		if ay.tones[channelC].envEnabled {
-			ay.tones[channelC].amplitude = 0
-		}
+		ay.envelope.setShape(val)
 	}
 }
 
@@ -123,35 +108,195 @@ func (ay *AY8910) SelectReg(reg byte) {
 	ay.reg = reg
 }
 
-func (ay *AY8910) WriteReg(val byte, t int64) {
+// Returns the currently selected register
+func (ay *AY8910) SelectedReg() byte {
+	return ay.reg
+}
+
+// Returns values of all registers
+func (ay *AY8910) Regs() [16]byte {
+	return ay.regs
+}
 
+// Restores values of all registers and the selected register
+func (ay *AY8910) SetRegs(selected byte, regs [16]byte) {
+	for reg, val := range regs {
+		ay.reg = byte(reg)
+		ay.writeReg(val)
+	}
+	ay.reg = selected & 0x0F
+}
+
+// Returns the value of the selected register
+func (ay *AY8910) ReadReg() byte {
+	return ay.regs[ay.reg]
 }
 
-func Update(t int64) {
+// Writes the selected register at the T state, the output is generated
+// up to the T state before the value is changed
+func (ay *AY8910) WriteReg(val byte, t int64) {
+	ay.Update(t)
+	ay.writeReg(val)
+}
+
+// Runs the generators up to the T state, the output changes are recorded
+func (ay *AY8910) Update(t int64) {
+	for ; ay.t < t; ay.t += statesPerTick {
+		ay.tick()
+		if sample := ay.output(); sample != ay.last {
+			ay.last = sample
+			ay.changes = append(ay.changes, ayChange{t: ay.t, sample: sample})
+		}
+	}
+}
 
+// Returns output changes since the last call and resets the buffer
+func (ay *AY8910) Changes() []ayChange {
+	changes := ay.changes
+	ay.changes = nil
+	return changes
 }
 
-func StartPlay() {
+// Advances the tone, noise and envelope generators by one step
+func (ay *AY8910) tick() {
+	for i := range ay.tones {
+		ay.tones[i].tick()
+	}
+	ay.noise.tick()
+	ay.envelope.tick()
+}
 
+// Returns the current amplitudes of the channels
+func (ay *AY8910) output() aySample {
+	var sample aySample
+	noise := ay.noise.output()
+	for i, t := range ay.tones {
+		// Disabled tone or noise keeps the channel output high
+		if (t.output || !t.toneEnabled) && (noise || !t.noiseEnabled) {
+			if t.envEnabled {
+				sample[i] = volLevels[ay.envelope.amplitude]
+			} else {
+				sample[i] = volLevels[t.volume]
+			}
+		}
+	}
+	return sample
 }
 
+// Square wave generator of the channel
 type tone struct {
 	period       uint16
+	count        uint16
+	output       bool
 	toneEnabled  bool
 	noiseEnabled bool
 	envEnabled   bool
-	amplitude    float32
+	volume       byte // fixed volume (0-15) used if envelope is disabled
 }
 
+func (t *tone) setPeriod(fine, coarse byte) {
+	t.period = uint16(fine) | uint16(coarse&0x0F)<<8
+}
+
+func (t *tone) setAmplitude(val byte) {
+	t.volume = val & 0x0F
+	t.envEnabled = val&0x10 != 0
+}
+
+// Output is inverted every period steps, period 0 is the same as 1
+func (t *tone) tick() {
+	t.count++
+	if t.count >= t.period {
+		t.count = 0
+		t.output = !t.output
+	}
+}
+
+// Noise generator, 17-bit LFSR with taps at bits 0 and 3
+type noise struct {
+	period byte
+	count  byte
+	rng    uint32
+}
+
+// The noise generator is clocked at half of the tone generators rate,
+// period 0 is the same as 1
+func (n *noise) tick() {
+	period := n.period * 2
+	if period == 0 {
+		period = 2
+	}
+	n.count++
+	if n.count >= period {
+		n.count = 0
+		n.rng = (n.rng^n.rng>>3)&0x01<<16 | n.rng>>1
+	}
+}
+
+func (n *noise) output() bool {
+	return n.rng&0x01 != 0
+}
+
+// Envelope generator, the amplitude changes every 2*period steps
 type envelope struct {
-	amplitude byte
+	amplitude byte // current amplitude (0-15)
 	period    uint16
+	count     uint32
+	step      byte // step within the cycle (0-15)
+	invert    byte // 0x0F if amplitude decreases within the cycle
+	holding   bool // the amplitude does not change anymore
 	hold      bool
 	alternate bool
 	attack    bool
 	cont      bool
 }
 
-func (t *tone) setPeriod(fine, coarse byte) {
-	t.period = uint16(fine) | uint16(coarse&0x0F)<<8
+// Sets the envelope shape and restarts the envelope cycle
+func (e *envelope) setShape(val byte) {
+	e.hold = val&0x01 != 0
+	e.alternate = val&0x02 != 0
+	e.attack = val&0x04 != 0
+	e.cont = val&0x08 != 0
+	e.invert = 0x0F
+	if e.attack {
+		e.invert = 0x00
+	}
+	e.step, e.count, e.holding = 0, 0, false
+	e.amplitude = e.step ^ e.invert
+}
+
+func (e *envelope) tick() {
+	if e.holding {
+		return
+	}
+	// Period 0 is the same as 1
+	period := uint32(e.period) * 2
+	if period == 0 {
+		period = 2
+	}
+	e.count++
+	if e.count < period {
+		return
+	}
+	e.count = 0
+
+	e.step++
+	if e.step > 15 {
+		switch {
+		case !e.cont:
+			// Shapes 0-7 end with the amplitude 0
+			e.step, e.invert, e.holding = 0, 0x00, true
+		case e.hold:
+			e.step, e.holding = 15, true
+			if e.alternate {
+				e.invert ^= 0x0F
+			}
+		default:
+			e.step = 0
+			if e.alternate {
+				e.invert ^= 0x0F
+			}
+		}
+	}
+	e.amplitude = e.step ^ e.invert
 }
@@ -0,0 +1,68 @@
+package sound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Tone(t *testing.T) {
+	ay := NewAY8910()
+	ay.SetRegs(regFineA, [16]byte{regFineA: 4, regEnable: 0x3E, regAmplitudeA: 0x0F})
+	ay.Update(256)
+
+	// Tick every 16 T states, output is inverted every 4 ticks
+	assert.Equal(t, []ayChange{
+		{t: 48, sample: aySample{volLevels[15], 0, 0}},
+		{t: 112, sample: aySample{0, 0, 0}},
+		{t: 176, sample: aySample{volLevels[15], 0, 0}},
+		{t: 240, sample: aySample{0, 0, 0}},
+	}, ay.Changes())
+
+	// Register write at the T state runs the generators up to it
+	ay.SelectReg(regAmplitudeA)
+	ay.WriteReg(0x00, 300)
+	assert.Nil(t, ay.Changes())
+	ay.Update(320)
+	assert.Nil(t, ay.Changes())
+	assert.Equal(t, int64(320), ay.t)
+}
+
+func Test_Noise(t *testing.T) {
+	n := noise{rng: 1}
+	length := 0
+	for {
+		n.tick()
+		n.tick()
+		length++
+		if n.rng == 1 {
+			break
+		}
+	}
+	assert.Equal(t, 1<<17-1, length)
+}
+
+func Test_EnvelopeShapes(t *testing.T) {
+	down := []byte{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+	up := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	low := make([]byte, 16)
+	high := []byte{15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15}
+
+	for shape, cycles := range [16][3][]byte{
+		{down, low, low}, {down, low, low}, {down, low, low}, {down, low, low},
+		{up, low, low}, {up, low, low}, {up, low, low}, {up, low, low},
+		{down, down, down}, {down, low, low}, {down, up, down}, {down, high, high},
+		{up, up, up}, {up, high, high}, {up, down, up}, {up, low, low},
+	} {
+		e := envelope{period: 1}
+		e.setShape(byte(shape))
+		for cycle, amplitudes := range cycles {
+			for step, amplitude := range amplitudes {
+				assert.Equal(t, amplitude, e.amplitude, "shape %d cycle %d step %d", shape, cycle, step)
+				// Amplitude changes every 2*period ticks
+				e.tick()
+				e.tick()
+			}
+		}
+	}
+}
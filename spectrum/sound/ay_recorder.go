@@ -0,0 +1,156 @@
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// AY register log formats
+const (
+	PSGFormat = iota // register writes of each frame
+	YM5Format        // register values of each frame
+	YM6Format        // same as YM5, but allows effects (not used by the recorder)
+)
+
+// Number of registers recorded, I/O ports are not recorded
+const ayRecordedRegs = 14
+
+// Returns the format of the AY register log as per file extension: psg or ym
+// (YM5 or YM6 as per version)
+func AYFormat(file string, ymVersion int) (int, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".psg":
+		return PSGFormat, nil
+	case ".ym":
+		switch ymVersion {
+		case 5:
+			return YM5Format, nil
+		case 6:
+			return YM6Format, nil
+		}
+		return 0, fmt.Errorf("YM version not supported: %d", ymVersion)
+	default:
+		return 0, fmt.Errorf("AY register log format not supported: %s", filepath.Ext(file))
+	}
+}
+
+// Single register write
+type ayWrite struct {
+	reg, val byte
+}
+
+// Records AY register writes per frame and writes them to PSG or YM file
+type AYRecorder struct {
+	file   string
+	format int
+	clock  int         // AY clock in Hz
+	regs   [16]byte    // register values at the start of the recording
+	frames [][]ayWrite // register writes of each finished frame
+	writes []ayWrite   // register writes of the current frame
+}
+
+// Creates a new recorder, regs are the register values at the start, clock is
+// the CPU clock in MHz (AY clock is half of it)
+func NewAYRecorder(file string, format int, clock float32, regs [16]byte) *AYRecorder {
+	return &AYRecorder{
+		file:   file,
+		format: format,
+		clock:  int(clock * 1000000 / 2),
+		regs:   regs,
+	}
+}
+
+// Records the register write
+func (r *AYRecorder) Write(reg, val byte) {
+	if reg < ayRecordedRegs {
+		r.writes = append(r.writes, ayWrite{reg: reg, val: val})
+	}
+}
+
+// Finishes the current frame, it is called on every interrupt
+func (r *AYRecorder) EndFrame() {
+	r.frames = append(r.frames, r.writes)
+	r.writes = nil
+}
+
+// Writes the recorded frames to the file
+func (r *AYRecorder) Close() error {
+	var data []byte
+	if r.format == PSGFormat {
+		data = r.psg()
+	} else {
+		data = r.ym()
+	}
+	return ioutil.WriteFile(r.file, data, 0644)
+}
+
+// Returns PSG file data: header followed by register and value pairs, each
+// frame is terminated by 0xFF and the file by 0xFD
+func (r *AYRecorder) psg() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("PSG\x1A")
+	buf.Write(make([]byte, 12))
+
+	// Initial state of the registers
+	for reg := byte(0); reg < ayRecordedRegs; reg++ {
+		buf.Write([]byte{reg, r.regs[reg]})
+	}
+	for _, writes := range r.frames {
+		for _, w := range writes {
+			buf.Write([]byte{w.reg, w.val})
+		}
+		buf.WriteByte(0xFF)
+	}
+	buf.WriteByte(0xFD)
+
+	return buf.Bytes()
+}
+
+// Returns YM file data (uncompressed, interleaved): header followed by all
+// frames of register 0, then all frames of register 1, etc. Envelope shape
+// is 0xFF in frames where it is not written.
+func (r *AYRecorder) ym() []byte {
+	regs := r.regs
+	// Registers 14 and 15 are used for effects (not recorded)
+	regs[regPortA], regs[regPortB] = 0, 0
+	values := make([][16]byte, len(r.frames))
+	for i, writes := range r.frames {
+		regs[regShapeE] = 0xFF
+		if i == 0 {
+			regs[regShapeE] = r.regs[regShapeE]
+		}
+		for _, w := range writes {
+			regs[w.reg] = w.val
+		}
+		values[i] = regs
+	}
+
+	buf := &bytes.Buffer{}
+	if r.format == YM5Format {
+		buf.WriteString("YM5!")
+	} else {
+		buf.WriteString("YM6!")
+	}
+	buf.WriteString("LeOnArD!")
+	binary.Write(buf, binary.BigEndian, uint32(len(values))) // number of frames
+	binary.Write(buf, binary.BigEndian, uint32(1))           // interleaved
+	binary.Write(buf, binary.BigEndian, uint16(0))           // number of digidrums
+	binary.Write(buf, binary.BigEndian, uint32(r.clock))     // AY clock
+	binary.Write(buf, binary.BigEndian, uint16(50))          // frames per second
+	binary.Write(buf, binary.BigEndian, uint32(0))           // loop frame
+	binary.Write(buf, binary.BigEndian, uint16(0))           // additional data size
+	buf.Write([]byte{0, 0, 0})                               // song name, author and comment
+
+	for reg := 0; reg < 16; reg++ {
+		for _, v := range values {
+			buf.WriteByte(v[reg])
+		}
+	}
+	buf.WriteString("End!")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,86 @@
+package sound
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AYFormat(t *testing.T) {
+	for _, test := range []struct {
+		file    string
+		version int
+		format  int
+		err     bool
+	}{
+		{"music.psg", 6, PSGFormat, false},
+		{"music.YM", 5, YM5Format, false},
+		{"music.ym", 6, YM6Format, false},
+		{"music.ym", 3, 0, true},
+		{"music.wav", 6, 0, true},
+	} {
+		format, err := AYFormat(test.file, test.version)
+		assert.Equal(t, test.format, format, test.file)
+		assert.Equal(t, test.err, err != nil, test.file)
+	}
+}
+
+func newTestAYRecorder(file string, format int) *AYRecorder {
+	regs := [16]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x38, 0x0F, 0x0E, 0x0D, 0x10, 0x00, 0x08, 0xFF, 0xFF}
+	r := NewAYRecorder(file, format, 3.5469, regs)
+	r.Write(regAmplitudeA, 0x0A)
+	r.Write(regPortA, 0x12) // not recorded
+	r.EndFrame()
+	r.EndFrame()
+	r.Write(regShapeE, 0x0E)
+	r.Write(regFineA, 0xFF)
+	r.EndFrame()
+	return r
+}
+
+func Test_AYRecorderPSG(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "music.psg")
+	assert.Nil(t, newTestAYRecorder(file, PSGFormat).Close())
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "PSG\x1A", string(data[:4]))
+	data = data[16:]
+	for reg := 0; reg < ayRecordedRegs; reg++ {
+		assert.Equal(t, byte(reg), data[reg*2])
+	}
+	assert.Equal(t, []byte{
+		regAmplitudeA, 0x0A, 0xFF,
+		0xFF,
+		regShapeE, 0x0E, regFineA, 0xFF, 0xFF,
+		0xFD,
+	}, data[ayRecordedRegs*2:])
+}
+
+func Test_AYRecorderYM(t *testing.T) {
+	for _, format := range []int{YM5Format, YM6Format} {
+		file := filepath.Join(t.TempDir(), "music.ym")
+		assert.Nil(t, newTestAYRecorder(file, format).Close())
+
+		data, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		signature := map[int]string{YM5Format: "YM5!", YM6Format: "YM6!"}[format]
+		assert.Equal(t, signature+"LeOnArD!", string(data[:12]))
+		assert.Equal(t, uint32(3), binary.BigEndian.Uint32(data[12:]))
+		assert.Equal(t, uint32(1), binary.BigEndian.Uint32(data[16:]))
+		assert.Equal(t, uint32(1773450), binary.BigEndian.Uint32(data[22:]))
+		assert.Equal(t, uint16(50), binary.BigEndian.Uint16(data[26:]))
+		assert.Equal(t, "End!", string(data[len(data)-4:]))
+
+		// Interleaved registers, 3 frames each
+		regs := data[37 : len(data)-4]
+		assert.Equal(t, 16*3, len(regs))
+		assert.Equal(t, []byte{0x01, 0x01, 0xFF}, regs[regFineA*3:regFineA*3+3])
+		assert.Equal(t, []byte{0x0A, 0x0A, 0x0A}, regs[regAmplitudeA*3:regAmplitudeA*3+3])
+		assert.Equal(t, []byte{0x08, 0xFF, 0x0E}, regs[regShapeE*3:regShapeE*3+3])
+		assert.Equal(t, []byte{0, 0, 0}, regs[regPortA*3:regPortA*3+3])
+	}
+}
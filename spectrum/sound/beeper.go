@@ -1,89 +1,51 @@
 package sound
 
-import (
-	"io"
-
-	"github.com/hajimehoshi/oto"
-)
-
 type Beeper struct {
 	ear     byte
-	ctx     *oto.Context
-	player  *oto.Player
-	lastT   int64 // last T state
-	lastA   byte  // last Amplitude
-	samples chan byte
+	lastA   byte           // last Amplitude
+	changes []beeperChange // amplitude changes since the last read
+}
+
+// Amplitude change at the T state
+type beeperChange struct {
+	t         int64
+	amplitude byte
 }
 
 const (
-	beeperAmplitudeLo     = 0      // low amplitude value
-	beeperAmplitudeHi     = 255    // high amplitude value
-	beeperMaxDuration     = 214042 // BEEP x,-60
-	beeperStatesPerSample = 8
+	beeperAmplitudeLo = 0   // low amplitude value
+	beeperAmplitudeHi = 255 // high amplitude value
 )
 
 // Create a new instance of the Beeper
-func NewBeeper(clock float32) (*Beeper, error) {
-	sampleRate := int(clock * 1000000 / beeperStatesPerSample)
-
-	ctx, err := oto.NewContext(sampleRate, 1, 1, 16384)
-	if err != nil {
-		return nil, err
-	}
-
-	b := &Beeper{
-		ctx:     ctx,
-		player:  ctx.NewPlayer(),
-		samples: make(chan byte, sampleRate),
-	}
-
-	go func() {
-		_, _ = io.Copy(b.player, b)
-	}()
-
-	return b, nil
+func NewBeeper() *Beeper {
+	return &Beeper{}
 }
 
-func (b *Beeper) Close() {
-	b.ctx.Close()
+// Current amplitude
+func (b *Beeper) Amplitude() byte {
+	return b.lastA
 }
 
-func (b *Beeper) Read(buf []byte) (int, error) {
-	for i := 0; i < len(buf); i++ {
-		select {
-		case a := <-b.samples:
-			buf[i] = a
-		default:
-			buf[i] = b.lastA
-		}
-	}
-
-	return len(buf), nil
+// Returns amplitude changes since the last call and resets the buffer
+func (b *Beeper) Changes() []beeperChange {
+	changes := b.changes
+	b.changes = nil
+	return changes
 }
 
 // Process beeper change at T state
 func (b *Beeper) Beep(val byte, t int64) {
-	on := false
 	ear := val & 0x10
-	if b.ear != ear {
-		b.ear = ear
-		on = true
+	if b.ear == ear {
+		return
 	}
+	b.ear = ear
 
-	if on {
-		duration := t - b.lastT
-		b.lastT = t
-		if duration > 0 && duration <= beeperMaxDuration {
-			if b.lastA == beeperAmplitudeLo {
-				b.lastA = beeperAmplitudeHi
-			} else {
-				b.lastA = beeperAmplitudeLo
-			}
-
-			length := int(duration / beeperStatesPerSample)
-			for i := 0; i < length; i++ {
-				b.samples <- b.lastA
-			}
-		}
+	if ear != 0 {
+		b.lastA = beeperAmplitudeHi
+	} else {
+		b.lastA = beeperAmplitudeLo
 	}
+	b.changes = append(b.changes, beeperChange{t: t, amplitude: b.lastA})
 }
@@ -0,0 +1,118 @@
+package sound
+
+import "math"
+
+// Band-limited step synthesis, based on the idea of blip_buf:
+// http://www.slack.net/~ant/libs/audio.html#Blip_Buffer
+// Each level change is added to the buffer as a band-limited impulse (windowed
+// sinc) at its exact position and the buffer is integrated when read, so the
+// output contains band-limited steps without the aliasing of square waves.
+
+const (
+	blipWidth  = 16  // number of output samples affected by a single step
+	blipPhases = 64  // number of fractional positions of the step
+	blipCutoff = 0.9 // cutoff frequency relative to the output Nyquist frequency
+)
+
+// Impulse kernels for each fractional position of the step
+var blipKernel = newBlipKernel()
+
+func newBlipKernel() [blipPhases][blipWidth]float32 {
+	var kernel [blipPhases][blipWidth]float32
+	fc := blipCutoff / 2 // cycles per sample
+	for phase := range kernel {
+		sum := 0.0
+		values := [blipWidth]float64{}
+		for i := range values {
+			// Distance of the sample from the step position
+			x := float64(i-blipWidth/2+1) - float64(phase)/blipPhases
+			sinc := 2 * fc
+			if x != 0 {
+				sinc = math.Sin(2*math.Pi*fc*x) / (math.Pi * x)
+			}
+			// Blackman window
+			w := 0.42 + 0.5*math.Cos(math.Pi*x/(blipWidth/2)) + 0.08*math.Cos(2*math.Pi*x/(blipWidth/2))
+			values[i] = sinc * w
+			sum += values[i]
+		}
+		// Each step adds up exactly to its height
+		for i, v := range values {
+			kernel[phase][i] = float32(v / sum)
+		}
+	}
+	return kernel
+}
+
+// Stereo buffer of band-limited steps at the output sample rate
+type blip struct {
+	rate   float64      // output samples per T state
+	origin int64        // T state of the first output sample
+	read   int64        // number of samples read since the origin
+	deltas [2][]float32 // step impulses of the samples not read yet
+	levels [2]float32   // integrated output level
+}
+
+func newBlip(rate float64, origin int64) *blip {
+	return &blip{rate: rate, origin: origin}
+}
+
+// Returns the position of the T state relative to the first sample not read yet
+func (b *blip) offset(t int64) float64 {
+	return float64(t-b.origin)*b.rate - float64(b.read)
+}
+
+// Adds the level change of the left and right output at the T state
+func (b *blip) addDelta(t int64, left, right float32) {
+	offset := b.offset(t)
+	if offset < 0 {
+		offset = 0
+	}
+	i := int(offset)
+	phase := int((offset - float64(i)) * blipPhases)
+	for len(b.deltas[0]) < i+blipWidth {
+		b.deltas[0] = append(b.deltas[0], 0)
+		b.deltas[1] = append(b.deltas[1], 0)
+	}
+	for k, v := range blipKernel[phase] {
+		b.deltas[0][i+k] += left * v
+		b.deltas[1][i+k] += right * v
+	}
+}
+
+// Returns the samples up to the T state, left and right interleaved. The
+// samples are delayed by half of the kernel width.
+func (b *blip) samples(t int64) []int16 {
+	n := int(b.offset(t))
+	if n <= 0 {
+		return nil
+	}
+	for len(b.deltas[0]) < n {
+		b.deltas[0] = append(b.deltas[0], 0)
+		b.deltas[1] = append(b.deltas[1], 0)
+	}
+
+	samples := make([]int16, n*2)
+	for i := 0; i < n; i++ {
+		for ch := range b.levels {
+			b.levels[ch] += b.deltas[ch][i]
+			samples[i*2+ch] = toInt16(b.levels[ch])
+		}
+	}
+	for ch := range b.deltas {
+		b.deltas[ch] = append(b.deltas[ch][:0], b.deltas[ch][n:]...)
+	}
+	b.read += int64(n)
+
+	return samples
+}
+
+// Converts the level (-1 to 1) to 16 bit sample
+func toInt16(level float32) int16 {
+	v := math.Round(float64(level) * 32767)
+	if v > 32767 {
+		return 32767
+	} else if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
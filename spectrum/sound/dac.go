@@ -0,0 +1,43 @@
+package sound
+
+// Value of the DAC output with no sound
+const dacSilence = 0x80
+
+// 8-bit DAC peripheral, e.g. Specdrum or Covox, the value written to
+// its port is the output level
+type DAC struct {
+	value   byte
+	changes []dacChange // value changes since the last read
+}
+
+// Value change at the T state
+type dacChange struct {
+	t     int64
+	value byte
+}
+
+// Create a new instance of the DAC
+func NewDAC() *DAC {
+	return &DAC{value: dacSilence}
+}
+
+// Sets the output level at the T state
+func (d *DAC) Write(val byte, t int64) {
+	if val == d.value {
+		return
+	}
+	d.value = val
+	d.changes = append(d.changes, dacChange{t: t, value: val})
+}
+
+// Sets the output level to silence at the T state
+func (d *DAC) Reset(t int64) {
+	d.Write(dacSilence, t)
+}
+
+// Returns value changes since the last call and resets the buffer
+func (d *DAC) Changes() []dacChange {
+	changes := d.changes
+	d.changes = nil
+	return changes
+}
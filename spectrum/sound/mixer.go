@@ -0,0 +1,201 @@
+package sound
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sample rate used if not specified otherwise
+const DefaultSampleRate = 44100
+
+// Layout of the AY channels in the stereo output
+type StereoMode int
+
+const (
+	StereoABC  StereoMode = iota // A left, B centre, C right
+	StereoACB                    // A left, C centre, B right
+	StereoBAC                    // B left, A centre, C right
+	StereoMono                   // all channels centre
+)
+
+// Default stereo separation, 1 means side channels are output to one side only
+const DefaultSeparation = 1.0
+
+// Parses the stereo mode name: abc, acb, bac or mono
+func ParseStereoMode(name string) (StereoMode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "abc":
+		return StereoABC, nil
+	case "acb":
+		return StereoACB, nil
+	case "bac":
+		return StereoBAC, nil
+	case "mono":
+		return StereoMono, nil
+	default:
+		return StereoMono, fmt.Errorf("Stereo mode not supported: %s", name)
+	}
+}
+
+// Mixes the beeper, AY and DAC outputs into a single audio stream (stereo,
+// 16 bit) at the output sample rate
+type Mixer struct {
+	beeper     *Beeper
+	ays        []*AY8910
+	dacs       []*DAC
+	clock      float32 // CPU clock in MHz
+	sampleRate int
+	blip       *blip
+	gains      [numChannels][2]float32 // left and right gain of the AY channels
+	beeperA    byte                    // last beeper amplitude
+	ayLast     []aySample              // last output of each AY
+	dacLast    []byte                  // last value of each DAC
+	levels     [][2]float32            // output (left and right) of each source added to the output
+	last       int64                   // T state of the last mix
+}
+
+// Creates a new mixer of the beeper output, AY chips and DACs can be added
+func NewMixer(clock float32, sampleRate int, beeper *Beeper) *Mixer {
+	m := &Mixer{
+		beeper: beeper,
+		clock:  clock,
+		levels: make([][2]float32, 1),
+	}
+	m.SetSampleRate(sampleRate)
+	m.SetStereo(StereoABC, DefaultSeparation)
+	return m
+}
+
+// Adds the AY chip output to the mix
+func (m *Mixer) AddAY(ay *AY8910) {
+	m.ays = append(m.ays, ay)
+	m.ayLast = append(m.ayLast, ay.last)
+	m.levels = append(m.levels, [2]float32{})
+}
+
+// Adds the DAC output to the mix
+func (m *Mixer) AddDAC(dac *DAC) {
+	m.dacs = append(m.dacs, dac)
+	m.dacLast = append(m.dacLast, dac.value)
+	m.levels = append(m.levels, [2]float32{})
+}
+
+// Sample rate of the generated audio
+func (m *Mixer) SampleRate() int {
+	return m.sampleRate
+}
+
+// Sets the output sample rate, e.g. 44100 or 48000
+func (m *Mixer) SetSampleRate(sampleRate int) {
+	m.sampleRate = sampleRate
+	m.blip = newBlip(float64(sampleRate)/(float64(m.clock)*1000000), m.last)
+	for _, level := range m.levels {
+		m.blip.levels[0] += level[0]
+		m.blip.levels[1] += level[1]
+	}
+}
+
+// Sets the layout of the AY channels and the stereo separation (0 to 1),
+// the separation 0 is the same as mono
+func (m *Mixer) SetStereo(mode StereoMode, separation float32) {
+	if separation < 0 {
+		separation = 0
+	} else if separation > 1 {
+		separation = 1
+	}
+
+	// Position of the channels A, B and C: -1 left, 0 centre, 1 right
+	var pans [numChannels]float32
+	switch mode {
+	case StereoABC:
+		pans = [numChannels]float32{-1, 0, 1}
+	case StereoACB:
+		pans = [numChannels]float32{-1, 1, 0}
+	case StereoBAC:
+		pans = [numChannels]float32{0, -1, 1}
+	}
+	for i, pan := range pans {
+		m.gains[i] = [2]float32{(1 - pan*separation) / 2, (1 + pan*separation) / 2}
+	}
+}
+
+// Generates the output of all sources up to the T state and returns the
+// mixed samples generated since the last call, left and right interleaved
+func (m *Mixer) Mix(t int64) []int16 {
+	// Stereo layout or sources may have changed since the last mix
+	m.setLevel(m.last, 0, m.beeperOutput(m.beeperA))
+	for i := range m.ays {
+		m.setLevel(m.last, 1+i, m.ayOutput(m.ayLast[i]))
+	}
+	for i := range m.dacs {
+		m.setLevel(m.last, 1+len(m.ays)+i, m.dacOutput(m.dacLast[i]))
+	}
+
+	// Changes are added independently, their order does not matter
+	for _, c := range m.beeper.Changes() {
+		m.beeperA = c.amplitude
+		m.setLevel(c.t, 0, m.beeperOutput(c.amplitude))
+	}
+	for i, ay := range m.ays {
+		ay.Update(t)
+		for _, c := range ay.Changes() {
+			m.ayLast[i] = c.sample
+			m.setLevel(c.t, 1+i, m.ayOutput(c.sample))
+		}
+	}
+	for i, dac := range m.dacs {
+		for _, c := range dac.Changes() {
+			m.dacLast[i] = c.value
+			m.setLevel(c.t, 1+len(m.ays)+i, m.dacOutput(c.value))
+		}
+	}
+
+	m.last = t
+	return m.blip.samples(t)
+}
+
+// Returns the volume of each group of the sources (beeper, AY chips and
+// DACs), the groups share the output range equally
+func (m *Mixer) volume() float32 {
+	groups := 1
+	if len(m.ays) > 0 {
+		groups++
+	}
+	if len(m.dacs) > 0 {
+		groups++
+	}
+	return 1 / float32(groups)
+}
+
+// Returns the left and right output of the beeper, it is centred
+func (m *Mixer) beeperOutput(amplitude byte) [2]float32 {
+	level := float32(amplitude) / beeperAmplitudeHi * m.volume()
+	return [2]float32{level, level}
+}
+
+// Returns the left and right output of the AY channels
+func (m *Mixer) ayOutput(sample aySample) [2]float32 {
+	// Side channel and half of the centre channel is the maximum
+	volume := m.volume() / (numChannels * maxAmplitude / 2) / float32(len(m.ays))
+	var levels [2]float32
+	for ch, amplitude := range sample {
+		levels[0] += amplitude * m.gains[ch][0] * volume
+		levels[1] += amplitude * m.gains[ch][1] * volume
+	}
+	return levels
+}
+
+// Returns the left and right output of the DAC, it is centred
+func (m *Mixer) dacOutput(value byte) [2]float32 {
+	level := (float32(value) - dacSilence) / dacSilence * m.volume() / float32(len(m.dacs))
+	return [2]float32{level, level}
+}
+
+// Adds the output change of the source at the T state
+func (m *Mixer) setLevel(t int64, source int, level [2]float32) {
+	prev := m.levels[source]
+	if level != prev {
+		m.levels[source] = level
+		m.blip.addDelta(t, level[0]-prev[0], level[1]-prev[1])
+	}
+}
@@ -0,0 +1,92 @@
+package sound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Mix(t *testing.T) {
+	beeper := NewBeeper()
+	mixer := NewMixer(3.5, DefaultSampleRate, beeper)
+	assert.Equal(t, DefaultSampleRate, mixer.SampleRate())
+
+	beeper.Beep(0x10, 7000)
+	samples := mixer.Mix(70000)
+	assert.Equal(t, 882*2, len(samples))
+	// Band-limited step: silent before, full level after and smooth transition
+	assert.Equal(t, []int16{0, 0}, samples[:2])
+	assert.Equal(t, []int16{32767, 32767}, samples[len(samples)-2:])
+	min, max := int16(32767), int16(-32768)
+	for i := 80 * 2; i < 100*2; i++ {
+		if samples[i] > 0 && samples[i] < 32000 {
+			if samples[i] < min {
+				min = samples[i]
+			}
+			if samples[i] > max {
+				max = samples[i]
+			}
+		}
+	}
+	assert.True(t, min < 8000 && max > 24000, "transition %d-%d", min, max)
+
+	// Same number of samples for the same time at 48kHz
+	mixer.SetSampleRate(48000)
+	assert.Equal(t, 960*2, len(mixer.Mix(140000)))
+	assert.Equal(t, int16(32767), mixer.Mix(210000)[0])
+
+	// Beeper and AY share the output range
+	ay := NewAY8910()
+	ay.SetRegs(regFineA, [16]byte{regEnable: 0x3F, regAmplitudeA: 0x0F})
+	beeper = NewBeeper()
+	mixer = NewMixer(3.5469, DefaultSampleRate, beeper)
+	mixer.AddAY(ay)
+	samples = mixer.Mix(70000)
+	assert.Equal(t, []int16{10922, 0}, samples[len(samples)-2:])
+	beeper.Beep(0x10, 70000)
+	samples = mixer.Mix(140000)
+	assert.Equal(t, []int16{27306, 16384}, samples[len(samples)-2:])
+
+	// Second AY and DACs, the groups share the output range
+	ay2 := NewAY8910()
+	ay2.SetRegs(regFineA, [16]byte{regEnable: 0x3F, regAmplitudeC: 0x0F})
+	mixer.AddAY(ay2)
+	dac := NewDAC()
+	mixer.AddDAC(dac)
+	mixer.AddDAC(NewDAC())
+	dac.Write(0xFF, 140000)
+	samples = mixer.Mix(210000)
+	beeperLevel, ayLevel, dacLevel := 1.0/3, 1.0/3/1.5/2, 1.0/3/2*127/128
+	assert.InDelta(t, (beeperLevel+ayLevel+dacLevel)*32767, samples[len(samples)-2], 1)
+	assert.InDelta(t, (beeperLevel+ayLevel+dacLevel)*32767, samples[len(samples)-1], 1)
+}
+
+func Test_Stereo(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		mode       StereoMode
+		separation float32
+		left       [numChannels]float32
+	}{
+		{"ABC", StereoABC, 1, [numChannels]float32{1, 0.5, 0}},
+		{"acb", StereoACB, 1, [numChannels]float32{1, 0, 0.5}},
+		{"bac", StereoBAC, 1, [numChannels]float32{0.5, 1, 0}},
+		{"mono", StereoMono, 1, [numChannels]float32{0.5, 0.5, 0.5}},
+		{"abc", StereoABC, 0.5, [numChannels]float32{0.75, 0.5, 0.25}},
+		{"abc", StereoABC, 0, [numChannels]float32{0.5, 0.5, 0.5}},
+	} {
+		mode, err := ParseStereoMode(test.name)
+		assert.Nil(t, err)
+		assert.Equal(t, test.mode, mode)
+
+		mixer := NewMixer(3.5469, DefaultSampleRate, NewBeeper())
+		mixer.AddAY(NewAY8910())
+		mixer.SetStereo(mode, test.separation)
+		for ch, left := range test.left {
+			assert.Equal(t, [2]float32{left, 1 - left}, mixer.gains[ch], "%s channel %d", test.name, ch)
+		}
+	}
+
+	_, err := ParseStereoMode("abcd")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,76 @@
+package sound
+
+import "sync/atomic"
+
+// Maximum emulation speed change requested to keep the buffer level stable
+const maxSpeedAdjust = 0.005
+
+// Lock-free ring buffer of stereo samples for a single producer (emulation)
+// and a single consumer (audio device). Writing never blocks, samples which
+// do not fit are dropped.
+type RingBuffer struct {
+	read    uint64 // total frames read, first to keep 64-bit alignment
+	write   uint64 // total frames written
+	samples []int16
+	size    uint64 // capacity in frames (left and right sample)
+}
+
+// Creates a new ring buffer with the capacity of size frames
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{
+		samples: make([]int16, size*2),
+		size:    uint64(size),
+	}
+}
+
+// Returns the number of frames in the buffer
+func (r *RingBuffer) Len() int {
+	return int(atomic.LoadUint64(&r.write) - atomic.LoadUint64(&r.read))
+}
+
+// Returns the capacity of the buffer in frames
+func (r *RingBuffer) Cap() int {
+	return int(r.size)
+}
+
+// Writes the samples (left and right interleaved), returns the number of
+// frames written
+func (r *RingBuffer) Write(samples []int16) int {
+	write := atomic.LoadUint64(&r.write)
+	free := r.size - (write - atomic.LoadUint64(&r.read))
+	n := uint64(len(samples) / 2)
+	if n > free {
+		n = free
+	}
+	for i := uint64(0); i < n; i++ {
+		pos := (write + i) % r.size * 2
+		r.samples[pos], r.samples[pos+1] = samples[i*2], samples[i*2+1]
+	}
+	atomic.StoreUint64(&r.write, write+n)
+	return int(n)
+}
+
+// Reads the samples (left and right interleaved), returns the number of
+// frames read
+func (r *RingBuffer) Read(samples []int16) int {
+	read := atomic.LoadUint64(&r.read)
+	available := atomic.LoadUint64(&r.write) - read
+	n := uint64(len(samples) / 2)
+	if n > available {
+		n = available
+	}
+	for i := uint64(0); i < n; i++ {
+		pos := (read + i) % r.size * 2
+		samples[i*2], samples[i*2+1] = r.samples[pos], r.samples[pos+1]
+	}
+	atomic.StoreUint64(&r.read, read+n)
+	return int(n)
+}
+
+// Returns the emulation speed factor to keep the buffer half full, it is
+// slightly above 1 when the buffer is running low and below 1 when it is
+// filling up
+func (r *RingBuffer) Speed() float64 {
+	fill := float64(r.Len()) / float64(r.size)
+	return 1 + (0.5-fill)*2*maxSpeedAdjust
+}
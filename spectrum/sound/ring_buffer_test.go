@@ -0,0 +1,49 @@
+package sound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RingBuffer(t *testing.T) {
+	r := NewRingBuffer(4)
+	assert.Equal(t, 4, r.Cap())
+	assert.Equal(t, 1+maxSpeedAdjust, r.Speed())
+
+	assert.Equal(t, 3, r.Write([]int16{1, -1, 2, -2, 3, -3}))
+	samples := make([]int16, 4)
+	assert.Equal(t, 2, r.Read(samples))
+	assert.Equal(t, []int16{1, -1, 2, -2}, samples)
+
+	// Wraps around, samples which do not fit are dropped
+	assert.Equal(t, 3, r.Write([]int16{4, -4, 5, -5, 6, -6, 7, -7}))
+	assert.Equal(t, 4, r.Len())
+	assert.Equal(t, 1-maxSpeedAdjust, r.Speed())
+	samples = make([]int16, 10)
+	assert.Equal(t, 4, r.Read(samples))
+	assert.Equal(t, []int16{3, -3, 4, -4, 5, -5, 6, -6, 0, 0}, samples)
+	assert.Equal(t, 0, r.Read(samples))
+}
+
+func Test_RingBufferConcurrent(t *testing.T) {
+	r := NewRingBuffer(64)
+	done := make(chan bool)
+	go func() {
+		for i := int16(0); i < 1000; {
+			if r.Write([]int16{i, -i}) == 1 {
+				i++
+			}
+		}
+		done <- true
+	}()
+
+	samples := make([]int16, 2)
+	for i := int16(0); i < 1000; {
+		if r.Read(samples) == 1 {
+			assert.Equal(t, []int16{i, -i}, samples)
+			i++
+		}
+	}
+	<-done
+}
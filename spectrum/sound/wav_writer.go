@@ -0,0 +1,85 @@
+package sound
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+)
+
+// Size of the RIFF and WAVE format headers
+const wavHeaderSize = 44
+
+// Writes the audio samples (stereo, 16 bit) to WAV file. The samples are
+// written as generated by the emulation, so the same run gives the same file.
+type WAVWriter struct {
+	file       *os.File
+	buf        *bufio.Writer
+	sampleRate int
+	size       int   // size of the samples in bytes
+	err        error // first write error
+}
+
+// Creates the WAV file, the header is completed when the writer is closed
+func NewWAVWriter(file string, sampleRate int) (*WAVWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAVWriter{
+		file:       f,
+		buf:        bufio.NewWriter(f),
+		sampleRate: sampleRate,
+	}
+	w.err = w.writeHeader()
+	return w, nil
+}
+
+// Writes the samples (left and right interleaved)
+func (w *WAVWriter) Write(samples []int16) {
+	if w.err != nil {
+		return
+	}
+	w.err = binary.Write(w.buf, binary.LittleEndian, samples)
+	w.size += len(samples) * 2
+}
+
+// Completes the header and closes the file, returns the first error
+// which occurred when writing the file
+func (w *WAVWriter) Close() error {
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if w.err == nil {
+		_, w.err = w.file.Seek(0, 0)
+	}
+	if w.err == nil {
+		w.buf.Reset(w.file)
+		w.err = w.writeHeader()
+	}
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if err := w.file.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *WAVWriter) writeHeader() error {
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(wavHeaderSize-8+w.size))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)                       // format chunk size
+	binary.LittleEndian.PutUint16(header[20:], 1)                        // PCM
+	binary.LittleEndian.PutUint16(header[22:], 2)                        // channels
+	binary.LittleEndian.PutUint32(header[24:], uint32(w.sampleRate))     // sample rate
+	binary.LittleEndian.PutUint32(header[28:], uint32(w.sampleRate*2*2)) // bytes per second
+	binary.LittleEndian.PutUint16(header[32:], 2*2)                      // bytes per frame
+	binary.LittleEndian.PutUint16(header[34:], 16)                       // bits per sample
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(w.size))
+	_, err := w.buf.Write(header)
+	return err
+}
@@ -0,0 +1,32 @@
+package sound
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WAVWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.wav")
+	w, err := NewWAVWriter(file, 48000)
+	assert.Nil(t, err)
+	w.Write([]int16{1, -1, 32767, -32768})
+	w.Write([]int16{0x1234, 0x5678})
+	assert.Nil(t, w.Close())
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, wavHeaderSize+12, len(data))
+	assert.Equal(t, "RIFF", string(data[0:4]))
+	assert.Equal(t, uint32(wavHeaderSize-8+12), binary.LittleEndian.Uint32(data[4:]))
+	assert.Equal(t, "WAVEfmt ", string(data[8:16]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[22:]))
+	assert.Equal(t, uint32(48000), binary.LittleEndian.Uint32(data[24:]))
+	assert.Equal(t, uint16(16), binary.LittleEndian.Uint16(data[34:]))
+	assert.Equal(t, "data", string(data[36:40]))
+	assert.Equal(t, uint32(12), binary.LittleEndian.Uint32(data[40:]))
+	assert.Equal(t, []byte{0x01, 0x00, 0xFF, 0xFF, 0xFF, 0x7F, 0x00, 0x80, 0x34, 0x12, 0x78, 0x56}, data[wavHeaderSize:])
+}
@@ -1,156 +1,460 @@
 package spectrum
 
 import (
-	"log"
-	"runtime"
-	"time"
-	"unsafe"
+	"errors"
+	"image"
+	"path/filepath"
+	"strings"
 
-	"github.com/go-gl/gl/v2.1/gl"
-	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/disk"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
 	"github.com/voytas/z80-go-zx/spectrum/keyboard"
 	"github.com/voytas/z80-go-zx/spectrum/machine"
 	"github.com/voytas/z80-go-zx/spectrum/memory"
 	"github.com/voytas/z80-go-zx/spectrum/screen"
 	"github.com/voytas/z80-go-zx/spectrum/snapshot"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
 	"github.com/voytas/z80-go-zx/spectrum/tape"
 	"github.com/voytas/z80-go-zx/z80"
+	"github.com/voytas/z80-go-zx/z80/debugger"
 )
 
-type Emulator struct {
-	bus *bus.Bus
-	z80 *z80.Z80
-	mem *memory.Memory
+// Represents the emulated ZX Spectrum, it does not depend on any display
+// or audio device, so it can run headless (e.g. in tests)
+type Machine struct {
+	model       *machine.Machine
+	bus         *bus.Bus
+	z80         *z80.Z80
+	mem         *memory.Memory
+	tape        *tape.Tape
+	screen      *image.RGBA
+	audio       []int16 // audio samples generated by the last frame
+	wav         *sound.WAVWriter
+	ayLog       *sound.AYRecorder
+	autoRun     bool // type LOAD "" when the tape file is loaded
+	autoRunKey  int  // next key press of LOAD "" (index to loadKeys), -1 if not typing
+	autoRunWait bool // tape file loaded, LOAD "" is typed once the ROM is ready
+	frame       int  // frame counter, key presses are timed by the frames
+	fastLoad    bool // load tape blocks instantly using ROM trap
+	debugger    *debugger.Debugger
+	midFrame    bool // frame stopped by the debugger is finished when resumed
 }
 
-func init() {
-	runtime.LockOSThread()
-}
+// Creates a new instance of the specified ZX Spectrum model
+func NewMachine(model *machine.Machine) (*Machine, error) {
+	// Initialise memory
+	var mem *memory.Memory = nil
+	var err error
+	switch {
+	case model.Plus3:
+		mem, err = memory.NewMemPlus3([4]string{model.ROM1Path, model.ROM2Path, model.ROM3Path, model.ROM4Path})
+	case model.RAM == 128:
+		mem, err = memory.NewMem128k(model.ROM1Path, model.ROM2Path)
+	case model.RAM == 48:
+		mem, err = memory.NewMem48k(model.ROM1Path)
+	case model.RAM == 16:
+		mem, err = memory.NewMem16k(model.ROM1Path)
+	default:
+		return nil, errors.New("Machine not supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	mem.SetContentionTable(model.ContentionTable)
+
+	// Initialise CPU
+	cpu := z80.NewZ80(mem)
+	cpu.IntLength = model.IntLength
+
+	// Initialise IO bus (ports)
+	tape := &tape.Tape{}
+	tape.Set48k(!mem.Is128k())
+	tape.SetClock(model.Clock)
+	bus := bus.NewBus(model, cpu.TC, mem, tape)
+	cpu.IOBus = bus
+	mem.TC = cpu.TC
 
-func Run(machine *machine.Machine, fileToLoad string) {
-	if err := glfw.Init(); err != nil {
-		log.Fatalln("failed to initialize glfw:", err)
+	m := &Machine{
+		model:      model,
+		mem:        mem,
+		bus:        bus,
+		z80:        cpu,
+		tape:       tape,
+		fastLoad:   true,
+		autoRun:    true,
+		autoRunKey: -1,
 	}
-	defer glfw.Terminate()
 
-	window, err := glfw.CreateWindow(632, 504, "ZX Spectrum 48k", nil, nil)
-	if err != nil {
-		log.Fatalln("failed to create window:", err)
+	// Initialise CPU trap
+	cpu.Trap = m.trap
+
+	return m, nil
+}
+
+// Loads TAP, TZX, CSW, PZX, WAV, SNA, SZX or Z80 file, DSK file is inserted
+// to the drive A (+3 only)
+func (m *Machine) LoadFile(file string) error {
+	if strings.ToLower(filepath.Ext(file)) == ".dsk" {
+		return m.InsertDisk(0, file)
+	}
+	if m.tape.IsTape(file) {
+		m.autoRunWait = m.autoRun
+		return m.tape.LoadFile(file)
 	}
+	return snapshot.LoadFile(file, m.model, m.z80, m.mem, m.bus, m.tape)
+}
+
+// Saves the current state as SNA, SZX or Z80 snapshot
+func (m *Machine) SaveFile(file string) error {
+	return snapshot.SaveFile(file, m.model, m.z80, m.mem, m.bus)
+}
+
+// Enables or disables typing LOAD "" when the tape file is loaded (enabled
+// by default)
+func (m *Machine) SetTapeAutoRun(autoRun bool) {
+	m.autoRun = autoRun
+}
+
+// Enables or disables fast tape loading and saving. When disabled the tape is
+// played in real time and loaded by the ROM routine from the EAR bit of port
+// 0xFE, saved blocks are decoded from the MIC bit.
+func (m *Machine) SetFastLoad(fastLoad bool) {
+	m.fastLoad = fastLoad
+}
+
+// Starts recording the saved tape blocks to TAP or TZX file
+func (m *Machine) StartRecording(file string) error {
+	return m.tape.StartRecording(file)
+}
+
+// Stops recording the saved tape blocks
+func (m *Machine) StopRecording() error {
+	return m.tape.StopRecording()
+}
+
+// Checks whether the saved tape blocks are being recorded
+func (m *Machine) IsRecording() bool {
+	return m.tape.IsRecording()
+}
+
+// Sets the threshold used to detect signal level changes in WAV tapes
+func (m *Machine) SetWAVThreshold(threshold float64) {
+	m.tape.SetWAVThreshold(threshold)
+}
+
+// Starts playing the tape
+func (m *Machine) PlayTape() {
+	m.tape.Play(m.z80.TC.Total)
+}
+
+// Stops playing the tape
+func (m *Machine) StopTape() {
+	m.tape.Stop()
+}
+
+// Rewinds the tape to the beginning
+func (m *Machine) RewindTape() {
+	m.tape.Rewind()
+}
+
+// Checks whether the tape is playing
+func (m *Machine) IsTapePlaying() bool {
+	return m.tape.IsPlaying()
+}
+
+// Positions the tape at the specified block
+func (m *Machine) SeekTape(block int) {
+	m.tape.Seek(block)
+}
+
+// Returns the blocks on the tape
+func (m *Machine) TapeBlocks() []*tape.BlockInfo {
+	return m.tape.Blocks()
+}
+
+// Returns the index of the tape block to be played or loaded next
+func (m *Machine) TapePosition() int {
+	return m.tape.Position()
+}
 
-	window.MakeContextCurrent()
+// Enables or disables stopping the tape when the pause block is reached
+func (m *Machine) SetTapeAutoStop(autoStop bool) {
+	m.tape.SetAutoStop(autoStop)
+}
 
-	if err := gl.Init(); err != nil {
-		log.Fatalln("failed to initialize gl bindings:", err)
+// Runs a single frame, i.e. executes the frame worth of T states,
+// raises the interrupt and renders the screen. When the debugger stops the
+// execution, the frame is finished by the call after it is resumed.
+func (m *Machine) RunFrame() {
+	if m.debugger != nil {
+		m.debugger.Poll()
+		if m.debugger.Paused() {
+			m.pausedFrame()
+			return
+		}
 	}
 
-	window.SetKeyCallback(keyboard.Callback)
+	if m.midFrame {
+		m.z80.Continue()
+	} else {
+		m.z80.Run(m.model.FrameStates)
+	}
+	if m.midFrame = m.z80.Stopped(); m.midFrame {
+		m.pausedFrame()
+		return
+	}
+	m.z80.INT(0xFF)
+	m.frame++
+	if m.autoRunKey >= 0 {
+		m.typeLoad()
+	}
+	m.bus.EndFrame()
+	m.screen = screen.Render(m.mem.Screen)
+	m.audio = m.bus.Mixer().Mix(m.z80.TC.Total)
+	if m.wav != nil {
+		m.wav.Write(m.audio)
+	}
+}
 
-	gl.ClearColor(0, 0, 0, 1)
-	gl.PixelZoom(4, -4)
-	gl.RasterPos2d(-1, 1)
+// Renders the screen memory as it is when the execution is paused, there is
+// no audio output
+func (m *Machine) pausedFrame() {
+	m.screen = screen.Render(m.mem.Screen)
+	m.audio = nil
+}
 
-	emu, err := createEmulator(machine, fileToLoad)
-	if err != nil {
-		log.Fatalln("failed to create emulator:", err)
+// Returns the debugger, it is attached to the CPU on the first call. The
+// console commands (see Debugger.Serve) are executed by RunFrame.
+func (m *Machine) Debugger() *debugger.Debugger {
+	if m.debugger == nil {
+		m.debugger = debugger.New(m.z80, m.mem)
 	}
+	return m.debugger
+}
+
+// Returns the screen rendered by the last frame
+func (m *Machine) Screen() *image.RGBA {
+	return m.screen
+}
 
-	freq := machine.Clock * 1000000 / float32(machine.FrameStates)
-	ticker := time.NewTicker(time.Duration(1/freq*1000000) * time.Microsecond)
-	defer ticker.Stop()
+// Returns audio samples (beeper and AY mixed) generated by the last frame,
+// stereo 16 bit with left and right samples interleaved
+func (m *Machine) AudioSamples() []int16 {
+	return m.audio
+}
+
+// Sets the layout of the AY channels and the stereo separation (0 to 1)
+func (m *Machine) SetStereo(mode sound.StereoMode, separation float32) {
+	m.bus.Mixer().SetStereo(mode, separation)
+}
+
+// Returns the sample rate of the audio samples
+func (m *Machine) SampleRate() int {
+	return m.bus.Mixer().SampleRate()
+}
+
+// Attaches the I/O device (peripheral) to the bus
+func (m *Machine) AttachDevice(device bus.Device) {
+	m.bus.Attach(device)
+}
 
-	for !window.ShouldClose() {
-		emu.z80.Run(machine.FrameStates)
-		emu.z80.INT(0xFF)
-		<-ticker.C
+// Detaches the I/O device from the bus
+func (m *Machine) DetachDevice(device bus.Device) {
+	m.bus.Detach(device)
+}
 
-		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-		scr := screen.Render(emu.mem.Screen)
-		gl.DrawPixels(
-			screen.BorderLeft+256+screen.BorderRight,
-			screen.BorderTop+192+screen.BorderBottom,
-			gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&scr.Pix[0]))
+// Returns the keyboard of the machine, the key presses are read by the ULA port
+func (m *Machine) Keyboard() *keyboard.Keyboard {
+	return m.bus.Keyboard()
+}
 
-		window.SwapBuffers()
-		glfw.PollEvents()
+// Returns the joystick attached to the bus (e.g. by SZX snapshot), nil if there is none
+func (m *Machine) Joystick() *joystick.Joystick {
+	for _, d := range m.bus.Devices() {
+		if joy, ok := d.(*joystick.Joystick); ok {
+			return joy
+		}
 	}
+	return nil
 }
 
-func createEmulator(m *machine.Machine, fileToLoad string) (*Emulator, error) {
-	// Initialise memory
-	var mem *memory.Memory = nil
-	var err error
-	if m == machine.ZX48k {
-		mem, err = memory.NewMem48k(m.ROM1Path)
-	} else if m == machine.ZX128k {
-		mem, err = memory.NewMem128k(m.ROM1Path, m.ROM2Path)
-	} else {
-		log.Fatal("Machine not supported")
+// Attaches the optional audio peripherals: TurboSound (128k only), Specdrum or Covox
+func (m *Machine) AttachPeripherals(p bus.Peripherals) {
+	m.bus.AttachPeripherals(p)
+}
+
+// Sets the sample rate of the audio samples, e.g. 44100 or 48000
+func (m *Machine) SetSampleRate(sampleRate int) {
+	m.bus.Mixer().SetSampleRate(sampleRate)
+}
+
+// Starts recording the audio samples to WAV file, the samples of each frame
+// are written when the frame is finished
+func (m *Machine) StartAudioRecording(file string) error {
+	if err := m.StopAudioRecording(); err != nil {
+		return err
 	}
+	wav, err := sound.NewWAVWriter(file, m.SampleRate())
 	if err != nil {
-		return nil, err
+		return err
 	}
+	m.wav = wav
+	return nil
+}
 
-	// Initialise CPU
-	cpu := z80.NewZ80(mem)
+// Stops recording the audio samples and completes the WAV file
+func (m *Machine) StopAudioRecording() error {
+	if m.wav == nil {
+		return nil
+	}
+	err := m.wav.Close()
+	m.wav = nil
+	return err
+}
 
-	// Initialise IO bus (ports)
-	bus, err := bus.NewBus(m, cpu.TC, mem)
-	if err != nil {
-		return nil, err
+// Starts recording AY register writes to PSG or YM file (format is one of
+// sound.PSGFormat, sound.YM5Format or sound.YM6Format), one frame per interrupt
+func (m *Machine) StartAYRecording(file string, format int) error {
+	if !m.mem.Is128k() {
+		return errors.New("AY is only available on 128k")
 	}
-	cpu.IOBus = bus
-	mem.TC = cpu.TC
+	if err := m.StopAYRecording(); err != nil {
+		return err
+	}
+	ay := m.bus.AY()
+	m.ayLog = sound.NewAYRecorder(file, format, m.model.Clock, ay.Regs())
+	m.bus.SetAYRecorder(m.ayLog)
+	return nil
+}
 
-	// Initialise tape loader
-	tape := &tape.Tape{}
+// Stops recording AY register writes and writes the file
+func (m *Machine) StopAYRecording() error {
+	if m.ayLog == nil {
+		return nil
+	}
+	err := m.ayLog.Close()
+	m.ayLog = nil
+	m.bus.SetAYRecorder(nil)
+	return err
+}
+
+// Checks whether the AY register writes are being recorded
+func (m *Machine) IsAYRecording() bool {
+	return m.ayLog != nil
+}
+
+// Checks whether the audio samples are being recorded
+func (m *Machine) IsAudioRecording() bool {
+	return m.wav != nil
+}
+
+// Returns the current CPU state
+func (m *Machine) CPUState() *z80.CPUState {
+	return m.z80.GetState()
+}
 
-	emu := &Emulator{
-		mem: mem,
-		bus: bus,
-		z80: cpu,
+// Returns the emulated model
+func (m *Machine) Model() *machine.Machine {
+	return m.model
+}
+
+// Returns the memory of the emulated machine
+func (m *Machine) Memory() *memory.Memory {
+	return m.mem
+}
+
+// Inserts DSK file to the drive (0 = A, 1 = B), the disk in the drive
+// is ejected first
+func (m *Machine) InsertDisk(drive int, file string) error {
+	if m.bus.FDC() == nil {
+		return errors.New("Disk drive is only available on +3")
 	}
+	d, err := disk.LoadDSK(file)
+	if err != nil {
+		return err
+	}
+	if err := m.EjectDisk(drive); err != nil {
+		return err
+	}
+	m.bus.FDC().Insert(drive, d)
+	return nil
+}
 
-	tapeAutoRun := false
+// Ejects the disk from the drive (0 = A, 1 = B), the disk is saved
+// if it has been written to
+func (m *Machine) EjectDisk(drive int) error {
+	if m.bus.FDC() == nil {
+		return nil
+	}
+	d := m.bus.FDC().Eject(drive)
+	if d != nil && d.IsModified() {
+		return d.Save()
+	}
+	return nil
+}
 
-	// Initialise CPU trap
-	cpu.Trap = func() {
-		switch cpu.Reg.PC {
-		case 0x056A: // LD_BYTES trap to handle fast tape loading
-			tape.Load(cpu, mem)
-		case 0x12A9: // MAIN_EXEC main execution loop
-			if tapeAutoRun {
-				tapeAutoRun = false
-				go func() {
-					// Simulate LOAD "" + ENTER
-					const delay = 50
-					keyboard.KeyDownUp(keyboard.KEY_J, delay)
-					keyboard.KeyDown(keyboard.KEY_SYMBOL, delay)
-					keyboard.KeyDownUp(keyboard.KEY_P, delay)
-					keyboard.KeyUp(keyboard.KEY_SYMBOL, delay)
-					keyboard.KeyDown(keyboard.KEY_SYMBOL, delay)
-					keyboard.KeyDownUp(keyboard.KEY_P, delay)
-					keyboard.KeyUp(keyboard.KEY_SYMBOL, delay)
-					keyboard.KeyDownUp(keyboard.KEY_ENTER, delay)
-				}()
-			}
-		}
+// Checks whether 48k BASIC ROM is paged in, it contains the tape routines
+// (+2A/+3 ROM 3 with special paging disabled)
+func (m *Machine) basicROM() bool {
+	if m.mem.IsPlus3() {
+		return m.mem.PagingModePlus3()&0x05 == 0x04 && m.mem.PagingMode()&0x10 != 0
 	}
+	return !m.mem.Is128k() || m.mem.PagingMode()&0x10 != 0
+}
 
-	// Load TAP, SNA or SZX file if specified
-	if fileToLoad != "" {
-		var err error
-		if tape.IsTape(fileToLoad) {
-			tapeAutoRun = true
-			err = tape.LoadFile(fileToLoad)
-		} else {
-			err = snapshot.LoadFile(fileToLoad, emu.z80, mem)
+// Traps to execute on specific PC addresses
+func (m *Machine) trap() {
+	switch m.z80.Reg.PC {
+	case 0x04C2: // SA_BYTES trap to handle fast tape saving
+		if m.fastLoad && m.basicROM() {
+			m.tape.Save(m.z80, m.mem)
+		}
+	case 0x056A: // LD_BYTES trap to handle fast tape loading
+		if !m.basicROM() {
+			break
+		}
+		if !m.fastLoad || !m.tape.Load(m.z80, m.mem) {
+			// Start the tape when the ROM starts loading, also when
+			// the tape has no block which could be loaded instantly
+			m.PlayTape()
 		}
-		if err != nil {
-			return nil, err
+	case 0x12A9: // MAIN_EXEC main execution loop
+		if m.autoRunWait {
+			m.autoRunWait = false
+			m.autoRunKey = 0
 		}
 	}
+}
+
+// Key presses and releases typing LOAD "" + ENTER
+var loadKeys = []struct {
+	key  byte
+	down bool
+}{
+	{keyboard.KEY_J, true}, {keyboard.KEY_J, false},
+	{keyboard.KEY_SYMBOL, true}, {keyboard.KEY_P, true}, {keyboard.KEY_P, false}, {keyboard.KEY_SYMBOL, false},
+	{keyboard.KEY_SYMBOL, true}, {keyboard.KEY_P, true}, {keyboard.KEY_P, false}, {keyboard.KEY_SYMBOL, false},
+	{keyboard.KEY_ENTER, true}, {keyboard.KEY_ENTER, false},
+}
+
+// Number of frames between the key presses, the keyboard is scanned every
+// frame and the same key has to be released for 5 frames to be repeated
+const loadKeyFrames = 3
 
-	return emu, nil
+// Types LOAD "" one key press at a time, the keys are timed by the frames,
+// so the typing is the same regardless of the emulation speed
+func (m *Machine) typeLoad() {
+	if m.frame%loadKeyFrames != 0 {
+		return
+	}
+	k := loadKeys[m.autoRunKey]
+	if k.down {
+		m.bus.Keyboard().Press(k.key)
+	} else {
+		m.bus.Keyboard().Release(k.key)
+	}
+	if m.autoRunKey++; m.autoRunKey == len(loadKeys) {
+		m.autoRunKey = -1
+	}
 }
@@ -0,0 +1,540 @@
+package spectrum
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/spectrum/disk"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/screen"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+func Test_RunFrame(t *testing.T) {
+	m, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+
+	// Boot the ROM, it takes less than 2 seconds to display the copyright message
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+
+	// Waiting for the key press in the main execution loop
+	pc := m.CPUState().PC
+	assert.True(t, pc < 0x4000, "PC %04X should be in ROM", pc)
+
+	// Copyright message rendered at the bottom of the screen
+	img := m.Screen()
+	assert.Equal(t, screen.BorderLeft+256+screen.BorderRight, img.Bounds().Dx())
+	assert.Equal(t, screen.BorderTop+192+screen.BorderBottom, img.Bounds().Dy())
+	ink := 0
+	for x := 0; x < 256; x++ {
+		for y := 184; y < 192; y++ {
+			if img.RGBAAt(screen.BorderLeft+x, screen.BorderTop+y).R == 0 {
+				ink++
+			}
+		}
+	}
+	assert.NotZero(t, ink)
+}
+
+func Test_RealTapeLoading(t *testing.T) {
+	m, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+	m.SetFastLoad(false)
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+
+	// TAP file with a single data block
+	data := []byte{0xAA, 0x55, 0xC3}
+	checksum := byte(0xFF)
+	for _, b := range data {
+		checksum ^= b
+	}
+	tap := append([]byte{byte(len(data) + 2), 0x00, 0xFF}, append(data, checksum)...)
+	file := filepath.Join(t.TempDir(), "test.tap")
+	assert.Nil(t, ioutil.WriteFile(file, tap, 0644))
+	m.SetTapeAutoRun(false)
+	assert.Nil(t, m.LoadFile(file))
+
+	// Call LD_BYTES to load the block at 0x8000, it returns to DI, JR $ at 0x9000
+	m.mem.Write(0x9000, 0xF3)
+	m.mem.Write(0x9001, 0x18)
+	m.mem.Write(0x9002, 0xFE)
+	state := m.CPUState()
+	state.SP = 0xFF00
+	m.mem.Write(0xFF00, 0x00)
+	m.mem.Write(0xFF01, 0x90)
+	state.PC = 0x0556
+	state.AF = 0xFF01 // A = flag, carry set = load
+	state.IX = 0x8000
+	state.DE = uint16(len(data))
+	m.z80.State(state)
+
+	for i := 0; i < 300 && m.CPUState().PC != 0x9001; i++ {
+		m.RunFrame()
+	}
+
+	// Carry flag is set when loaded successfully
+	state = m.CPUState()
+	assert.Equal(t, uint16(0x9001), state.PC)
+	assert.Equal(t, uint16(0x0001), state.AF&0x0001)
+	for i, b := range data {
+		assert.Equal(t, b, *m.mem.Cells[0x8000+i])
+	}
+}
+
+func Test_TapeAutoRun(t *testing.T) {
+	m, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+
+	// Typed LOAD "" reaches LD_BYTES, the condition only records the hit
+	reached := false
+	m.z80.AddBreakpoint(&z80.Breakpoint{Type: z80.BreakPC, From: 0x056A, Condition: func(*z80.Z80) bool {
+		reached = true
+		return false
+	}})
+	tap := []byte{0x02, 0x00, 0xFF, 0xFF}
+	file := filepath.Join(t.TempDir(), "test.tap")
+	assert.Nil(t, ioutil.WriteFile(file, tap, 0644))
+	assert.Nil(t, m.LoadFile(file))
+
+	// Typing starts once the ROM is booted to the main execution loop
+	for i := 0; i < 300 && !reached; i++ {
+		m.RunFrame()
+	}
+	assert.True(t, reached)
+
+	// The edit line holds the typed command: LOAD token and two quotes
+	e := int(*m.mem.Cells[0x5C59]) | int(*m.mem.Cells[0x5C5A])<<8
+	assert.Equal(t, []byte{0xEF, 0x22, 0x22, 0x0D}, []byte{*m.mem.Cells[e], *m.mem.Cells[e+1], *m.mem.Cells[e+2], *m.mem.Cells[e+3]})
+
+	// Each machine has its own keyboard, ENTER is down on the typing one only
+	other, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0xFE), m.Keyboard().GetKeyPortValue(0xBF))
+	assert.Equal(t, byte(0xFF), other.Keyboard().GetKeyPortValue(0xBF))
+
+	// ENTER is released by the following frames
+	for i := 0; i < loadKeyFrames; i++ {
+		m.RunFrame()
+	}
+	assert.Equal(t, -1, m.autoRunKey)
+}
+
+func Test_TapeSaving(t *testing.T) {
+	for _, fastLoad := range []bool{true, false} {
+		m, err := NewMachine(machine.ZX48k)
+		assert.Nil(t, err)
+		m.SetFastLoad(fastLoad)
+		for i := 0; i < 100; i++ {
+			m.RunFrame()
+		}
+
+		file := filepath.Join(t.TempDir(), "test.tap")
+		assert.Nil(t, m.StartRecording(file))
+
+		// Call SA_BYTES to save the block at 0x8000, it returns to DI, JR $ at 0x9000
+		data := []byte{0xAA, 0x55, 0xC3}
+		for i, b := range data {
+			m.mem.Write(0x8000+uint16(i), b)
+		}
+		m.mem.Write(0x9000, 0xF3)
+		m.mem.Write(0x9001, 0x18)
+		m.mem.Write(0x9002, 0xFE)
+		state := m.CPUState()
+		state.SP = 0xFF00
+		m.mem.Write(0xFF00, 0x00)
+		m.mem.Write(0xFF01, 0x90)
+		state.PC = 0x04C2
+		state.AF = 0xFF00 // A = flag
+		state.IX = 0x8000
+		state.DE = uint16(len(data))
+		m.z80.State(state)
+
+		for i := 0; i < 300 && m.CPUState().PC != 0x9001; i++ {
+			m.RunFrame()
+		}
+		assert.Equal(t, uint16(0x9001), m.CPUState().PC)
+		assert.Nil(t, m.StopRecording())
+
+		tap, err := ioutil.ReadFile(file)
+		assert.Nil(t, err, "fast %v", fastLoad)
+		assert.Equal(t, []byte{0x05, 0x00, 0xFF, 0xAA, 0x55, 0xC3, 0xFF ^ 0xAA ^ 0x55 ^ 0xC3}, tap, "fast %v", fastLoad)
+	}
+}
+
+func Test_AudioSamples(t *testing.T) {
+	m, err := NewMachine(machine.ZX128k)
+	assert.Nil(t, err)
+	m.RunFrame()
+	// Frame can take a few more T states than expected due to the last instruction
+	frames := float64(m.model.FrameStates) * float64(m.SampleRate()) / float64(m.model.Clock*1000000)
+	assert.InDelta(t, frames*2, len(m.AudioSamples()), 4)
+
+	// Channel A with fixed maximum volume and tone disabled
+	ay := m.bus.AY()
+	ay.SelectReg(0x07)
+	ay.WriteReg(0x3F, m.z80.TC.Total)
+	ay.SelectReg(0x08)
+	ay.WriteReg(0x0F, m.z80.TC.Total)
+	m.RunFrame()
+	samples := m.AudioSamples()
+	for i := 100; i < len(samples); i += 2 {
+		assert.InDelta(t, 10922, samples[i], 1, "AY output expected on the left")
+		assert.InDelta(t, 0, samples[i+1], 1, "AY output not expected on the right")
+	}
+
+	m.SetStereo(sound.StereoMono, 1)
+	m.RunFrame()
+	samples = m.AudioSamples()
+	assert.InDelta(t, 5461, samples[len(samples)-2], 1)
+	assert.Equal(t, samples[len(samples)-2], samples[len(samples)-1])
+}
+
+func Test_AudioRecording(t *testing.T) {
+	// The same run gives the same file
+	files := []string{}
+	for i := 0; i < 2; i++ {
+		m, err := NewMachine(machine.ZX128k)
+		assert.Nil(t, err)
+		file := filepath.Join(t.TempDir(), "audio.wav")
+		assert.Nil(t, m.StartAudioRecording(file))
+		assert.True(t, m.IsAudioRecording())
+		for frame := 0; frame < 10; frame++ {
+			m.bus.Beeper().Beep(byte(frame%2)<<4, m.z80.TC.Total+1000)
+			m.RunFrame()
+		}
+		assert.Nil(t, m.StopAudioRecording())
+		assert.False(t, m.IsAudioRecording())
+		files = append(files, file)
+	}
+
+	data1, err := ioutil.ReadFile(files[0])
+	assert.Nil(t, err)
+	data2, err := ioutil.ReadFile(files[1])
+	assert.Nil(t, err)
+	// About 882 stereo samples (4 bytes each) per frame
+	assert.InDelta(t, 44+10*882*4, len(data1), 10*4*2)
+	assert.Equal(t, data1, data2)
+}
+
+func Test_AYRecording(t *testing.T) {
+	m, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+	assert.NotNil(t, m.StartAYRecording(filepath.Join(t.TempDir(), "music.psg"), sound.PSGFormat))
+
+	m, err = NewMachine(machine.ZX128k)
+	assert.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		m.RunFrame()
+	}
+	file := filepath.Join(t.TempDir(), "music.psg")
+	assert.Nil(t, m.StartAYRecording(file, sound.PSGFormat))
+	assert.True(t, m.IsAYRecording())
+
+	// Register writes through the AY ports
+	m.bus.Write(0xFF, 0xFD, 0x08)
+	m.bus.Write(0xBF, 0xFD, 0x0F)
+	m.RunFrame()
+	assert.Nil(t, m.StopAYRecording())
+	assert.False(t, m.IsAYRecording())
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x08, 0x0F, 0xFF, 0xFD}, data[len(data)-4:])
+}
+
+func Test_Plus3Disk(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "disk.dsk")
+
+	// Single track with sector 1 (512 bytes) filled with 0xA5
+	dsk := make([]byte, 0x100+0x100+512)
+	copy(dsk, "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	dsk[0x30], dsk[0x31], dsk[0x32], dsk[0x33] = 1, 1, 0x00, 0x03
+	copy(dsk[0x100:], "Track-Info\r\n")
+	dsk[0x114], dsk[0x115] = 2, 1
+	copy(dsk[0x118:], []byte{0, 0, 1, 2})
+	for i := 0x200; i < len(dsk); i++ {
+		dsk[i] = 0xA5
+	}
+	assert.Nil(t, ioutil.WriteFile(file, dsk, 0644))
+
+	m, err := NewMachine(machine.ZX48k)
+	assert.Nil(t, err)
+	assert.NotNil(t, m.LoadFile(file))
+
+	// ROM 0 reads the sector using FDC ports to 0x9000
+	rom := make([]byte, 0x4000)
+	copy(rom, []byte{
+		0xF3,             // di
+		0x31, 0x00, 0x80, // ld sp,0x8000
+		0x01, 0xFD, 0x1F, // ld bc,0x1ffd
+		0x3E, 0x08, //       ld a,0x08 (motor on)
+		0xED, 0x79, //       out (c),a
+		0x21, 0x40, 0x00, // ld hl,command
+		0x16, 0x09, //       ld d,9
+		0x01, 0xFD, 0x2F, // ld bc,0x2ffd
+		0xED, 0x78, //       in a,(c)
+		0x87,       //       add a,a
+		0x30, 0xFB, //       jr nc,-5
+		0x06, 0x3F, //       ld b,0x3f
+		0x7E,       //       ld a,(hl)
+		0xED, 0x79, //       out (c),a
+		0x23,       //       inc hl
+		0x15,       //       dec d
+		0x20, 0xEF, //       jr nz,-17
+		0x21, 0x00, 0x90, // ld hl,0x9000
+		0x01, 0xFD, 0x2F, // ld bc,0x2ffd
+		0xED, 0x78, //       in a,(c)
+		0xF2, 0x27, 0x00, // jp p,0x0027
+		0xE6, 0x20, //       and 0x20 (execution phase)
+		0x28, 0x06, //       jr z,+6
+		0x06, 0x3F, //       ld b,0x3f
+		0xED, 0xA2, //       ini
+		0x18, 0xEE, //       jr -18
+		0x76, //             halt
+	})
+	copy(rom[0x40:], []byte{0x46, 0x00, 0, 0, 1, 2, 1, 0x2A, 0xFF}) // read data C=0 H=0 R=1 N=2
+	m, err = NewMachine(stubPlus3(t, machine.ZXPlus3, func(int) []byte { return rom }))
+	assert.Nil(t, err)
+	assert.Nil(t, m.LoadFile(file))
+	m.RunFrame()
+
+	bank := m.Memory().Bank(2)
+	assert.Equal(t, byte(0xA5), bank[0x1000])
+	assert.Equal(t, byte(0xA5), bank[0x11FF])
+	assert.Equal(t, byte(0x00), bank[0x1200])
+	assert.Nil(t, m.EjectDisk(0))
+}
+
+// Returns the copy of +2A/+3 model running the stub ROMs written to files
+func stubPlus3(t *testing.T, model *machine.Machine, rom func(i int) []byte) *machine.Machine {
+	stub := *model
+	dir := t.TempDir()
+	for i, path := range []*string{&stub.ROM1Path, &stub.ROM2Path, &stub.ROM3Path, &stub.ROM4Path} {
+		*path = filepath.Join(dir, fmt.Sprintf("plus3-%d.rom", i))
+		assert.Nil(t, ioutil.WriteFile(*path, rom(i), 0644))
+	}
+	return &stub
+}
+
+func Test_Plus3Paging(t *testing.T) {
+	// Each ROM is filled with its number
+	rom := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 0x4000)
+	}
+	for _, model := range []*machine.Machine{machine.ZXPlus2A, machine.ZXPlus3} {
+		m, err := NewMachine(stubPlus3(t, model, rom))
+		assert.Nil(t, err)
+		mem := m.Memory()
+
+		// ROM is selected by bit 4 of port 0x7FFD (low) and bit 2 of port 0x1FFD (high)
+		for i, ports := range [][2]byte{{0x00, 0x00}, {0x10, 0x00}, {0x00, 0x04}, {0x10, 0x04}} {
+			m.bus.Write(0x1F, 0xFD, ports[1])
+			m.bus.Write(0x7F, 0xFD, ports[0])
+			assert.Equal(t, byte(i), mem.Read(0x0000), "%s ROM %d", model.Name, i)
+		}
+
+		// Special paging: banks 4, 7, 6 and 3, the first slot is RAM
+		m.bus.Write(0x1F, 0xFD, 0x07)
+		mem.Write(0x0000, 0xA5)
+		assert.Equal(t, byte(0xA5), mem.Bank(4)[0])
+		m.bus.Write(0x1F, 0xFD, 0x00)
+		assert.Equal(t, byte(1), mem.Read(0x0000)) // port 0x7FFD still selects ROM 1
+
+		// Only +3 has the disk controller, its main status is ready for the command
+		if model.FDC {
+			assert.Equal(t, byte(0x80), m.bus.Read(0x2F, 0xFD))
+			assert.NotNil(t, m.bus.FDC())
+		} else {
+			assert.Nil(t, m.bus.FDC())
+		}
+	}
+}
+
+// Creates +3 format DSK (40 tracks, 9 sectors of 512 bytes) with the single
+// 1k file HELLO.BAS in the directory
+func newPlus3DSK() []byte {
+	const trackSize = 0x100 + 9*512
+	dsk := make([]byte, 0x100+40*trackSize)
+	copy(dsk, "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	dsk[0x30], dsk[0x31], dsk[0x32], dsk[0x33] = 40, 1, trackSize&0xFF, trackSize>>8
+	for track := 0; track < 40; track++ {
+		info := dsk[0x100+track*trackSize:]
+		copy(info, "Track-Info\r\n")
+		info[0x10], info[0x14], info[0x15], info[0x16], info[0x17] = byte(track), 2, 9, 0x52, 0xE5
+		for i := 0; i < 9; i++ {
+			copy(info[0x18+8*i:], []byte{byte(track), 0, byte(i + 1), 2})
+		}
+		for i := 0x100; i < trackSize; i++ {
+			info[i] = 0xE5
+		}
+	}
+	sector := func(track, n int) []byte {
+		return dsk[0x100+track*trackSize+0x100+(n-1)*512:]
+	}
+
+	// Disk specification (boot sector) and the directory entry, block 2
+	// follows the directory (blocks 0 and 1)
+	copy(sector(0, 1), []byte{0x00, 0x00, 0x28, 0x09, 0x02, 0x01, 0x03, 0x02, 0x2A, 0x52})
+	entry := sector(1, 1)
+	copy(entry, append([]byte{0}, "HELLO   BAS"...))
+	copy(entry[12:], []byte{0, 0, 0, 8, 2})
+	for i := 17; i < 32; i++ {
+		entry[i] = 0
+	}
+	return dsk
+}
+
+func Test_Plus3DOS(t *testing.T) {
+	dsk := newPlus3DSK()
+	d, err := disk.ParseDSK(dsk)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, d.Tracks())
+
+	// +3 ROMs can be embedded only if available when built, the paging and
+	// the disk controller are tested with stub ROMs by the tests above
+	if err := machine.ZXPlus3.CheckROMs(); err != nil {
+		t.Skip(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "disk.dsk")
+	assert.Nil(t, ioutil.WriteFile(file, dsk, 0644))
+	m, err := NewMachine(machine.ZXPlus3)
+	assert.Nil(t, err)
+	assert.Nil(t, m.LoadFile(file))
+
+	// Boot to the menu, +3DOS is initialised by then
+	for i := 0; i < 200; i++ {
+		m.RunFrame()
+	}
+
+	// Calls DOS_CATALOG with +3DOS ROM and bank 7 paged in, stores the carry
+	// (success) and number of entries + 1 to 0x9100
+	mem := m.Memory()
+	for i, b := range []byte{
+		0xF3,             // di
+		0x31, 0x00, 0xBF, // ld sp,0xbf00
+		0x01, 0xFD, 0x7F, // ld bc,0x7ffd
+		0x3E, 0x07, //       ld a,7
+		0xED, 0x79, //       out (c),a
+		0x01, 0xFD, 0x1F, // ld bc,0x1ffd
+		0x3E, 0x04, //       ld a,4 (ROM 2)
+		0xED, 0x79, //       out (c),a
+		0x21, 0x00, 0x90, // ld hl,0x9000
+		0x11, 0x00, 0xA0, // ld de,0xa000
+		0x01, 0x01, 0x09, // ld bc,0x0901
+		0xCD, 0x1E, 0x01, // call DOS_CATALOG
+		0x78,             // ld a,b
+		0x32, 0x01, 0x91, // ld (0x9101),a
+		0x9F,             // sbc a,a
+		0x32, 0x00, 0x91, // ld (0x9100),a
+		0x76, //             halt
+	} {
+		mem.Write(0x8000+uint16(i), b)
+	}
+	for i, b := range []byte("*.*\xFF") {
+		mem.Write(0x9000+uint16(i), b)
+	}
+	for i := uint16(0); i < 9*13; i++ {
+		mem.Write(0xA000+i, 0)
+	}
+	mem.Write(0x9100, 0x55)
+	m.z80.Reg.PC = 0x8000
+	for i := 0; i < 500 && mem.Read(0x9100) == 0x55; i++ {
+		m.RunFrame()
+	}
+
+	assert.Equal(t, byte(0xFF), mem.Read(0x9100))
+	assert.Equal(t, byte(2), mem.Read(0x9101))
+	name := make([]byte, 11)
+	for i := range name {
+		name[i] = mem.Read(0xA00D+uint16(i)) & 0x7F
+	}
+	assert.Equal(t, "HELLO   BAS", string(name))
+	assert.Equal(t, byte(1), mem.Read(0xA00D+11))
+}
+
+func Test_Models(t *testing.T) {
+	assert.Equal(t, machine.Pentagon, machine.Models["pentagon"])
+
+	// 16k ROM finds the top of RAM at 0x7FFF (P_RAMT)
+	m, err := NewMachine(machine.ZX16k)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+	assert.Equal(t, byte(0x7F), m.Memory().Read(0x5CB5))
+	assert.Equal(t, byte(0xFF), m.Memory().Read(0x5CB4))
+
+	// Pentagon runs 128k ROM, longer frames and no contention
+	m, err = NewMachine(machine.Pentagon)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+	assert.InDelta(t, 100*71680, m.z80.TC.Total, 100)
+	pc := m.CPUState().PC
+	assert.True(t, pc < 0x4000, "PC %04X should be in ROM", pc)
+	assert.Equal(t, byte(0xFF), m.bus.Read(0x00, 0xFF))
+	assert.Equal(t, byte(0xFF), m.bus.Read(0x00, 0xF6))
+
+	// +2 runs the embedded 128k ROMs
+	m, err = NewMachine(machine.ZXPlus2)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+	assert.Equal(t, machine.ZX128k.ROM1Path, machine.ZXPlus2.ROM1Path)
+	pc = m.CPUState().PC
+	assert.True(t, pc < 0x4000, "PC %04X should be in ROM", pc)
+}
+
+func Test_Debugger(t *testing.T) {
+	m, err := NewMachine(machine.ZX128k)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		m.RunFrame()
+	}
+
+	// Stops at the interrupt routine, the frame is not finished
+	d := m.Debugger()
+	d.RunTo(0x0038)
+	m.RunFrame()
+	assert.True(t, d.Paused())
+	assert.Equal(t, uint16(0x0038), m.CPUState().PC)
+	total, start := m.z80.TC.Total, m.CPUState().T
+	m.RunFrame()
+	assert.Equal(t, total, m.z80.TC.Total)
+	assert.Nil(t, m.AudioSamples())
+
+	out, err := d.Exec("r")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "Banks: 0000=ROM 4000=5 8000=2 C000=")
+	_, err = d.Exec("e 7:0001 12")
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x12), m.mem.Bank(7)[1])
+	out, err = d.Exec("m 7:0000 2")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(out, "7:0000  00 12"))
+
+	// Single instruction within the frame, then the rest of the frame
+	d.Step()
+	m.RunFrame()
+	assert.True(t, m.midFrame)
+	assert.NotEqual(t, uint16(0x0038), m.CPUState().PC)
+	d.Resume()
+	m.RunFrame()
+	assert.False(t, m.midFrame)
+	assert.InDelta(t, machine.ZX128k.FrameStates, int(m.z80.TC.Total-total)+start, 23)
+	assert.NotNil(t, m.AudioSamples())
+}
@@ -0,0 +1,109 @@
+package tape
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describes the tape block as shown by the tape browser
+type BlockInfo struct {
+	Index       int     // index of the block on the tape, used to seek the tape
+	Type        string  // block type, e.g. "Standard speed data"
+	Flag        int     // flag of the data block, -1 if the block has no data
+	Length      int     // length of the block data including flag and checksum
+	Header      *Header // standard ROM header, nil if it is not a header block
+	Description string  // block details or metadata, e.g. archive info
+}
+
+// Represents standard ROM header block
+type Header struct {
+	Type   byte   // 0=Program, 1=Number array, 2=Character array, 3=Bytes
+	Name   string // file name
+	Length int    // length of the data block
+	Param1 int    // autostart line (Program) or start address (Bytes)
+	Param2 int    // program length without variables (Program)
+}
+
+// Header types
+const (
+	HeaderProgram   = 0
+	HeaderNumbers   = 1
+	HeaderChars     = 2
+	HeaderBytes     = 3
+	headerBlockSize = 19 // flag, header and checksum
+)
+
+// Returns the name of the header type
+func (h *Header) TypeName() string {
+	switch h.Type {
+	case HeaderProgram:
+		return "Program"
+	case HeaderNumbers:
+		return "Number array"
+	case HeaderChars:
+		return "Character array"
+	case HeaderBytes:
+		return "Bytes"
+	default:
+		return fmt.Sprintf("Unknown type %d", h.Type)
+	}
+}
+
+// Returns the header description, e.g. Bytes: "screen" CODE 16384,6912
+func (h *Header) String() string {
+	s := fmt.Sprintf("%s: \"%s\"", h.TypeName(), h.Name)
+	switch h.Type {
+	case HeaderProgram:
+		if h.Param1 < 32768 {
+			s += fmt.Sprintf(" LINE %d", h.Param1)
+		}
+	case HeaderBytes:
+		s += fmt.Sprintf(" CODE %d,%d", h.Param1, h.Length)
+	}
+	return s
+}
+
+// Returns the blocks on the tape, nil if no tape is loaded
+func (t *Tape) Blocks() []*BlockInfo {
+	if t.reader == nil {
+		return nil
+	}
+	return t.reader.blocks()
+}
+
+// Returns the index of the block to be played or loaded next
+func (t *Tape) Position() int {
+	if t.reader == nil {
+		return 0
+	}
+	return t.reader.position()
+}
+
+// Enables or disables stopping the tape when the pause block is reached
+func (t *Tape) SetAutoStop(autoStop bool) {
+	t.autoStop = autoStop
+}
+
+// Creates the information of the block containing data as in TAP file
+func newDataBlockInfo(index int, blockType string, data []byte) *BlockInfo {
+	info := &BlockInfo{
+		Index:  index,
+		Type:   blockType,
+		Flag:   -1,
+		Length: len(data),
+	}
+	if len(data) > 0 {
+		info.Flag = int(data[0])
+	}
+	if len(data) == headerBlockSize && data[0] == 0x00 {
+		info.Header = &Header{
+			Type:   data[1],
+			Name:   strings.TrimRight(string(data[2:12]), " "),
+			Length: int(data[12]) | int(data[13])<<8,
+			Param1: int(data[14]) | int(data[15])<<8,
+			Param2: int(data[16]) | int(data[17])<<8,
+		}
+		info.Description = info.Header.String()
+	}
+	return info
+}
@@ -0,0 +1,93 @@
+package tape
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates header block (flag, header and checksum)
+func headerBlock(headerType byte, name string, length, param1, param2 int) []byte {
+	data := []byte{0x00, headerType}
+	data = append(data, []byte(name + "          ")[:10]...)
+	data = append(data, byte(length), byte(length>>8), byte(param1), byte(param1>>8), byte(param2), byte(param2>>8))
+	checksum := byte(0)
+	for _, b := range data {
+		checksum ^= b
+	}
+	return append(data, checksum)
+}
+
+func Test_Blocks(t *testing.T) {
+	tap := []byte{}
+	for _, block := range [][]byte{
+		headerBlock(HeaderProgram, "game", 100, 10, 90),
+		{0xFF, 0x01, 0x02, 0xFC},
+		headerBlock(HeaderBytes, "screen", 6912, 16384, 32768),
+		headerBlock(HeaderChars, "text", 10, 0, 0),
+	} {
+		tap = append(tap, byte(len(block)), byte(len(block)>>8))
+		tap = append(tap, block...)
+	}
+
+	tape := &Tape{}
+	assert.Nil(t, tape.Blocks())
+	assert.Nil(t, tape.LoadData(tap, ".tap"))
+
+	blocks := tape.Blocks()
+	assert.Equal(t, 4, len(blocks))
+	assert.Equal(t, &BlockInfo{
+		Index:       0,
+		Type:        "Standard speed data",
+		Flag:        0x00,
+		Length:      19,
+		Header:      &Header{Type: HeaderProgram, Name: "game", Length: 100, Param1: 10, Param2: 90},
+		Description: `Program: "game" LINE 10`,
+	}, blocks[0])
+	assert.Equal(t, 0xFF, blocks[1].Flag)
+	assert.Equal(t, 4, blocks[1].Length)
+	assert.Nil(t, blocks[1].Header)
+	assert.Equal(t, `Bytes: "screen" CODE 16384,6912`, blocks[2].Description)
+	assert.Equal(t, `Character array: "text"`, blocks[3].Description)
+
+	// Seek and position
+	assert.Equal(t, 0, tape.Position())
+	tape.Seek(2)
+	assert.Equal(t, 2, tape.Position())
+	assert.Equal(t, byte(0x00), tape.reader.NextBlock().flag)
+	assert.Equal(t, 3, tape.Position())
+	tape.Rewind()
+	assert.Equal(t, 0, tape.Position())
+}
+
+func Test_TZXBlocks(t *testing.T) {
+	data := []byte("ZXTape!\x1A\x01\x14")
+	data = append(data, 0x32, 0x0E, 0x00, 0x02, 0x00, 0x04, 'G', 'a', 'm', 'e', 0x02, 0x02, 'M', 'e')
+	data = append(data, tzxStandardBlock(0xFF)...)
+	data = append(data, 0x20, 0xE8, 0x03)
+	data = append(data, tzxStandardBlock(0xFF)...)
+	data = append(data, 0x30, 0x04, 'T', 'e', 'x', 't')
+
+	tape := &Tape{}
+	assert.Nil(t, tape.LoadData(data, ".tzx"))
+	blocks := tape.Blocks()
+	assert.Equal(t, 5, len(blocks))
+	assert.Equal(t, "Archive info", blocks[0].Type)
+	assert.Equal(t, "Title: Game, Author: Me", blocks[0].Description)
+	assert.Equal(t, -1, blocks[0].Flag)
+	assert.Equal(t, "Standard speed data", blocks[1].Type)
+	assert.Equal(t, "Pause", blocks[2].Type)
+	assert.Equal(t, "1000 ms", blocks[2].Description)
+	assert.Equal(t, "Text", blocks[4].Description)
+
+	// Auto stop at the pause block
+	tape.SetAutoStop(true)
+	tape.Play(0)
+	tape.EAR(1 << 40)
+	assert.False(t, tape.IsPlaying())
+	assert.Equal(t, 3, tape.Position())
+	tape.Play(0)
+	tape.EAR(1 << 40)
+	assert.False(t, tape.IsPlaying())
+	assert.Equal(t, 5, tape.Position())
+}
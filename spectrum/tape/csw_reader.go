@@ -0,0 +1,141 @@
+package tape
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/voytas/z80-go-zx/spectrum/helpers"
+)
+
+const (
+	cswSignature = "Compressed Square Wave\x1A"
+	cswRLE       = 1 // RLE compression
+	cswZRLE      = 2 // Z-RLE compression (version 2 only)
+)
+
+// CSW is a single recording, it does not contain separate blocks
+type cswReader struct {
+	pulses   []int // pulse lengths in samples
+	rate     int   // sample rate
+	polarity bool  // initial signal level is high
+	played   bool  // recording has been played
+}
+
+func newCSWReader(data []byte) (*cswReader, error) {
+	r := helpers.NewBinaryReader(data)
+	if string(r.ReadBytes(len(cswSignature))) != cswSignature {
+		return nil, errors.New("Not a valid CSW data")
+	}
+
+	var rate int
+	var compression, flags byte
+	switch major := r.ReadByte(); major {
+	case 1:
+		r.ReadByte() // minor version
+		rate = int(r.ReadWord())
+		compression = r.ReadByte()
+		flags = r.ReadByte()
+		r.ReadBytes(3) // reserved
+	case 2:
+		r.ReadByte() // minor version
+		rate = int(r.ReadDWord())
+		r.ReadDWord() // total number of pulses
+		compression = r.ReadByte()
+		flags = r.ReadByte()
+		ext := r.ReadByte()
+		r.ReadBytes(16) // encoding application
+		r.ReadBytes(int(ext))
+	default:
+		return nil, errors.New("CSW version not supported")
+	}
+	if r.Eof || rate == 0 {
+		return nil, errors.New("Not a valid CSW data")
+	}
+
+	pulses, err := decodeCSW(data[r.Pos():], compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cswReader{
+		pulses:   pulses,
+		rate:     rate,
+		polarity: flags&0x01 != 0,
+	}, nil
+}
+
+// Sampled recording can't be loaded by the ROM routine
+func (c *cswReader) NextBlock() *TapeBlock {
+	return nil
+}
+
+func (c *cswReader) nextSignal() signal {
+	if c.played {
+		return nil
+	}
+	c.played = true
+
+	s := newCSWSignal(c.pulses, c.rate, 0)
+	if len(s.pulses) > 0 {
+		// The first pulse has the initial signal level
+		s.pulses[0].level = levelLow
+		if c.polarity {
+			s.pulses[0].level = levelHigh
+		}
+	}
+	return s
+}
+
+func (c *cswReader) seek(block int) {
+	c.played = block > 0
+}
+
+func (c *cswReader) position() int {
+	return boolToInt(c.played)
+}
+
+func (c *cswReader) blocks() []*BlockInfo {
+	return []*BlockInfo{{
+		Type:        "CSW recording",
+		Flag:        -1,
+		Description: fmt.Sprintf("%d pulses at %d Hz", len(c.pulses), c.rate),
+	}}
+}
+
+// Decodes CSW pulses (number of samples of each pulse), data is compressed
+// using RLE or Z-RLE (RLE compressed with zlib)
+func decodeCSW(data []byte, compression byte) ([]int, error) {
+	switch compression {
+	case cswRLE:
+	case cswZRLE:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("CSW compression type not supported")
+	}
+
+	pulses := []int{}
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0 {
+			pulses = append(pulses, int(data[i]))
+			continue
+		}
+		// Zero is followed by the pulse length stored as DWORD
+		if i+4 >= len(data) {
+			return nil, errors.New("CSW data is truncated")
+		}
+		pulses = append(pulses, int(data[i+1])|int(data[i+2])<<8|int(data[i+3])<<16|int(data[i+4])<<24)
+		i += 4
+	}
+
+	return pulses, nil
+}
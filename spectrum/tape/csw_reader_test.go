@@ -0,0 +1,66 @@
+package tape
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_decodeCSW(t *testing.T) {
+	rle := []byte{0x10, 0x20, 0x00, 0x00, 0x01, 0x00, 0x00, 0x05}
+	pulses, err := decodeCSW(rle, cswRLE)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0x10, 0x20, 0x100, 0x05}, pulses)
+
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write(rle)
+	w.Close()
+	pulses, err = decodeCSW(buf.Bytes(), cswZRLE)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0x10, 0x20, 0x100, 0x05}, pulses)
+
+	_, err = decodeCSW(rle[:4], cswRLE)
+	assert.NotNil(t, err)
+
+	// 44100Hz, 3500000 T states per second
+	s := newCSWSignal([]int{1, 1, 1}, 44100, 0)
+	assert.Equal(t, []pulse{{79, edgeToggle}, {79, edgeToggle}, {80, edgeToggle}}, s.pulses)
+}
+
+func Test_CSWReader(t *testing.T) {
+	// Version 1: 44100Hz, RLE, initial level high
+	v1 := append([]byte(cswSignature), 0x01, 0x01, 0x44, 0xAC, cswRLE, 0x01, 0x00, 0x00, 0x00)
+	// Version 2: 44100Hz, 3 pulses, Z-RLE, initial level low, 1 byte extension
+	v2 := append([]byte(cswSignature), 0x02, 0x00, 0x44, 0xAC, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, cswZRLE, 0x00, 0x01)
+	v2 = append(v2, append(make([]byte, 16), 0xFF)...)
+
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write([]byte{0x01, 0x01, 0x01})
+	w.Close()
+
+	for _, test := range []struct {
+		data  []byte
+		level int
+	}{
+		{append(v1, 0x01, 0x01, 0x01), levelHigh},
+		{append(v2, buf.Bytes()...), levelLow},
+	} {
+		r, err := newCSWReader(test.data)
+		assert.Nil(t, err)
+		assert.Nil(t, r.NextBlock())
+
+		s := r.nextSignal().(*pulseSignal)
+		assert.Equal(t, []pulse{{79, test.level}, {79, edgeToggle}, {80, edgeToggle}}, s.pulses)
+		assert.Nil(t, r.nextSignal())
+
+		r.seek(0)
+		assert.NotNil(t, r.nextSignal())
+	}
+
+	_, err := newCSWReader([]byte("Compressed"))
+	assert.NotNil(t, err)
+}
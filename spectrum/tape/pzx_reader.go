@@ -0,0 +1,218 @@
+package tape
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/voytas/z80-go-zx/spectrum/helpers"
+)
+
+type pzxBlock struct {
+	tag  string
+	data []byte
+}
+
+type pzxReader struct {
+	entries []*pzxBlock
+	index   int
+}
+
+func newPZXReader(data []byte) (*pzxReader, error) {
+	blocks := []*pzxBlock{}
+	r := helpers.NewBinaryReader(data)
+	for {
+		tag := r.ReadBytes(4)
+		size := r.ReadDWord()
+		if r.Eof {
+			break
+		}
+		block := r.ReadBytes(int(size))
+		if r.Eof {
+			return nil, errors.New("PZX data is truncated")
+		}
+		blocks = append(blocks, &pzxBlock{tag: string(tag), data: block})
+	}
+
+	if len(blocks) == 0 || blocks[0].tag != "PZXT" {
+		return nil, errors.New("Not a valid PZX data")
+	}
+
+	return &pzxReader{
+		entries: blocks,
+	}, nil
+}
+
+func (p *pzxReader) NextBlock() *TapeBlock {
+	for p.index < len(p.entries) {
+		block := p.entries[p.index]
+		p.index += 1
+		if block.tag != "DATA" || len(block.data) < 8 {
+			continue
+		}
+
+		// Only whole bytes with the flag and checksum can be loaded by the ROM routine
+		bits := int(helpers.NewBinaryReader(block.data).ReadDWord() & 0x7FFFFFFF)
+		p0, p1 := int(block.data[6]), int(block.data[7])
+		offset := 8 + (p0+p1)*2
+		if bits%8 == 0 && bits >= 16 && offset+bits/8 <= len(block.data) {
+			data := block.data[offset : offset+bits/8]
+			return &TapeBlock{
+				flag:     data[0],
+				data:     data[1 : len(data)-1],
+				checksum: data[len(data)-1],
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *pzxReader) nextSignal() signal {
+	for p.index < len(p.entries) {
+		block := p.entries[p.index]
+		p.index += 1
+		r := helpers.NewBinaryReader(block.data)
+		switch block.tag {
+		case "PULS":
+			return p.pulses(r)
+		case "DATA":
+			return p.data(r)
+		case "PAUS":
+			duration := r.ReadDWord()
+			return &pulseSignal{pulses: []pulse{{length: 0, level: pauseBlock}, {length: int(duration & 0x7FFFFFFF), level: pzxLevel(duration)}}}
+		case "STOP":
+			if r.ReadWord() == 1 {
+				return newControlSignal(stopTape48k)
+			}
+			return newControlSignal(stopTape)
+		}
+	}
+
+	return nil
+}
+
+func (p *pzxReader) seek(block int) {
+	p.index = block
+}
+
+func (p *pzxReader) position() int {
+	return p.index
+}
+
+func (p *pzxReader) blocks() []*BlockInfo {
+	blocks := make([]*BlockInfo, len(p.entries))
+	for i, block := range p.entries {
+		info := &BlockInfo{Index: i, Type: block.tag, Flag: -1, Length: len(block.data)}
+		r := helpers.NewBinaryReader(block.data)
+		switch block.tag {
+		case "PZXT":
+			info.Type = "Header"
+			// Version followed by null terminated title and info (key, value) strings
+			if len(block.data) > 2 {
+				texts := strings.Split(strings.TrimRight(string(block.data[2:]), "\x00"), "\x00")
+				info.Description = "Title: " + texts[0]
+				for j := 1; j+1 < len(texts); j += 2 {
+					info.Description += ", " + texts[j] + ": " + texts[j+1]
+				}
+			}
+		case "PULS":
+			info.Type = "Pulses"
+		case "DATA":
+			info.Type = "Data"
+			bits := int(r.ReadDWord() & 0x7FFFFFFF)
+			if len(block.data) >= 8 {
+				offset := 8 + (int(block.data[6])+int(block.data[7]))*2
+				if bits%8 == 0 && offset+bits/8 <= len(block.data) {
+					data := block.data[offset : offset+bits/8]
+					info = newDataBlockInfo(i, info.Type, data)
+				}
+			}
+		case "PAUS":
+			info.Type = "Pause"
+			info.Description = fmt.Sprintf("%d T states", r.ReadDWord()&0x7FFFFFFF)
+		case "BRWS":
+			info.Type = "Browse point"
+			info.Description = string(block.data)
+		case "STOP":
+			info.Type = "Stop the tape"
+			if r.ReadWord() == 1 {
+				info.Type = "Stop the tape if in 48k mode"
+			}
+		}
+		blocks[i] = info
+	}
+
+	return blocks
+}
+
+// PULS block, the signal level is low at the start of the block
+// and it is inverted after each pulse
+func (p *pzxReader) pulses(r *helpers.BinaryReader) signal {
+	pulses := []pulse{}
+	level := levelLow
+	for {
+		count, duration := 1, int(r.ReadWord())
+		if duration > 0x8000 {
+			count, duration = duration&0x7FFF, int(r.ReadWord())
+		}
+		if duration >= 0x8000 {
+			duration = (duration&0x7FFF)<<16 | int(r.ReadWord())
+		}
+		if r.Eof {
+			break
+		}
+		for i := 0; i < count; i++ {
+			pulses = append(pulses, pulse{length: duration, level: level})
+			level = edgeToggle
+		}
+	}
+
+	return &pulseSignal{pulses: pulses}
+}
+
+// DATA block, each bit is represented by the sequence of pulses
+// followed by the tail pulse
+func (p *pzxReader) data(r *helpers.BinaryReader) signal {
+	count := r.ReadDWord()
+	tail := int(r.ReadWord())
+	p0, p1 := int(r.ReadByte()), int(r.ReadByte())
+	s0, s1 := make([]int, p0), make([]int, p1)
+	for i := range s0 {
+		s0[i] = int(r.ReadWord())
+	}
+	for i := range s1 {
+		s1[i] = int(r.ReadWord())
+	}
+	bits := int(count & 0x7FFFFFFF)
+	data := r.ReadBytes((bits + 7) / 8)
+	if r.Eof {
+		return &pulseSignal{}
+	}
+
+	pulses := []pulse{}
+	level := pzxLevel(count)
+	for bit := 0; bit < bits; bit++ {
+		seq := s0
+		if data[bit/8]&(0x80>>(bit%8)) != 0 {
+			seq = s1
+		}
+		for _, length := range seq {
+			pulses = append(pulses, pulse{length: length, level: level})
+			level = edgeToggle
+		}
+	}
+	if tail > 0 {
+		pulses = append(pulses, pulse{length: tail, level: level})
+	}
+
+	return &pulseSignal{pulses: pulses}
+}
+
+// Initial signal level stored in the bit 31
+func pzxLevel(value uint32) int {
+	if value&0x80000000 != 0 {
+		return levelHigh
+	}
+	return levelLow
+}
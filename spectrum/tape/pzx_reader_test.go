@@ -0,0 +1,60 @@
+package tape
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PZX block with the tag and data
+func pzxBlockData(tag string, data ...byte) []byte {
+	size := len(data)
+	return append([]byte{tag[0], tag[1], tag[2], tag[3], byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)}, data...)
+}
+
+func Test_PZXReader(t *testing.T) {
+	data := pzxBlockData("PZXT", 0x01, 0x00)
+	// 2 pulses of 2168, 1 pulse of 667, 1 pulse of 0x12345 (repeat count is required)
+	data = append(data, pzxBlockData("PULS", 0x02, 0x80, 0x78, 0x08, 0x9B, 0x02, 0x01, 0x80, 0x01, 0x80, 0x45, 0x23)...)
+	// 16 bits, initial level high, tail 945, 2 pulses per bit
+	data = append(data, pzxBlockData("DATA", 0x10, 0x00, 0x00, 0x80, 0xB1, 0x03, 0x02, 0x02,
+		0x57, 0x03, 0x57, 0x03, 0xAE, 0x06, 0xAE, 0x06, 0xFF, 0x00)...)
+	data = append(data, pzxBlockData("BRWS", 'T')...)
+	data = append(data, pzxBlockData("PAUS", 0xE0, 0x2E, 0x00, 0x00)...)
+	data = append(data, pzxBlockData("STOP", 0x01, 0x00)...)
+
+	r, err := newPZXReader(data)
+	assert.Nil(t, err)
+
+	b := r.NextBlock()
+	assert.Equal(t, byte(0xFF), b.flag)
+	assert.Equal(t, byte(0x00), b.checksum)
+	assert.Nil(t, r.NextBlock())
+
+	r.seek(0)
+	s := r.nextSignal().(*pulseSignal)
+	assert.Equal(t, []pulse{{2168, levelLow}, {2168, edgeToggle}, {667, edgeToggle}, {0x12345, edgeToggle}}, s.pulses)
+
+	s = r.nextSignal().(*pulseSignal)
+	assert.Equal(t, 33, len(s.pulses))
+	assert.Equal(t, pulse{1710, levelHigh}, s.pulses[0])
+	assert.Equal(t, pulse{855, edgeToggle}, s.pulses[16])
+	assert.Equal(t, pulse{945, edgeToggle}, s.pulses[32])
+
+	s = r.nextSignal().(*pulseSignal)
+	assert.Equal(t, []pulse{{0, pauseBlock}, {12000, levelLow}}, s.pulses)
+	s = r.nextSignal().(*pulseSignal)
+	assert.Equal(t, []pulse{{0, stopTape48k}}, s.pulses)
+	assert.Nil(t, r.nextSignal())
+
+	blocks := r.blocks()
+	assert.Equal(t, 6, len(blocks))
+	assert.Equal(t, "Data", blocks[2].Type)
+	assert.Equal(t, 0xFF, blocks[2].Flag)
+	assert.Equal(t, "Browse point", blocks[3].Type)
+	assert.Equal(t, "T", blocks[3].Description)
+	assert.Equal(t, "Stop the tape if in 48k mode", blocks[5].Type)
+
+	_, err = newPZXReader(pzxBlockData("DATA"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,160 @@
+package tape
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/voytas/z80-go-zx/spectrum/memory"
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+// Tolerance of the pulse lengths when decoding MIC output (percentage)
+const micTolerance = 20
+
+// Decoder states of the MIC output
+const (
+	micPilot = iota // waiting for the pilot tone
+	micSync         // pilot tone detected, waiting for the second sync pulse
+	micData         // reading data bits
+)
+
+// Records the blocks saved by the ROM routine (or any routine using the
+// standard timings) and writes them to TAP or TZX file
+type recorder struct {
+	file   string
+	blocks [][]byte // recorded blocks (flag, data and checksum)
+	mic    byte     // last MIC output level
+	last   int64    // T state of the last MIC edge
+	state  int      // decoder state
+	pilot  int      // number of pilot pulses detected
+	half   int      // length of the first pulse of the bit, 0 if not read yet
+	data   []byte   // decoded bytes of the block
+	bits   int      // number of decoded bits
+}
+
+// Records the block saved by the ROM SA_BYTES routine: A=flag, IX=start
+// address, DE=length. Returns from the routine as if it was executed.
+func (r *recorder) save(cpu *z80.Z80, mem *memory.Memory) error {
+	addr := cpu.Reg.IX()
+	length := cpu.Reg.DE()
+
+	block := []byte{cpu.Reg.A}
+	checksum := cpu.Reg.A
+	for i := uint16(0); i < length; i++ {
+		b := *mem.Cells[addr+i]
+		block = append(block, b)
+		checksum ^= b
+	}
+	block = append(block, checksum)
+
+	// SA/LD-RET restores the border and returns to the caller
+	cpu.Reg.IXH, cpu.Reg.IXL = byte((addr+length)>>8), byte(addr+length)
+	cpu.Reg.D, cpu.Reg.E = 0, 0
+	cpu.Reg.F |= z80.FC
+	cpu.Reg.PC = 0x053F
+
+	return r.add(block)
+}
+
+// Processes MIC output (bit 3 of port 0xFE) at the T state, the block is
+// decoded from the lengths of the pulses between the edges
+func (r *recorder) output(mic byte, total int64) error {
+	if mic == r.mic {
+		return nil
+	}
+	r.mic = mic
+	length := int(total - r.last)
+	r.last = total
+
+	switch r.state {
+	case micPilot:
+		if matchPulse(length, pilotPulse) {
+			r.pilot++
+		} else if r.pilot >= pilotData/2 && matchPulse(length, sync1Pulse) {
+			r.state = micSync
+		} else {
+			r.pilot = 0
+		}
+	case micSync:
+		if matchPulse(length, sync2Pulse) {
+			r.state, r.half, r.data, r.bits = micData, 0, nil, 0
+		} else {
+			r.state, r.pilot = micPilot, 0
+		}
+	case micData:
+		if !matchPulse(length, zeroBitPulse) && !matchPulse(length, oneBitPulse) {
+			// Block is finished, the pulse may be part of the next pilot tone
+			err := r.flush()
+			if matchPulse(length, pilotPulse) {
+				r.pilot = 1
+			}
+			return err
+		}
+		if r.half == 0 {
+			r.half = length
+			return nil
+		}
+		r.addBit(r.half+length > zeroBitPulse+oneBitPulse)
+	}
+
+	return nil
+}
+
+// Adds the decoded bit to the block data
+func (r *recorder) addBit(one bool) {
+	if r.bits%8 == 0 {
+		r.data = append(r.data, 0)
+	}
+	if one {
+		r.data[r.bits/8] |= 0x80 >> (r.bits % 8)
+	}
+	r.bits++
+	r.half = 0
+}
+
+// Adds the block decoded from MIC output (if any) to the recorded blocks
+func (r *recorder) flush() error {
+	if r.state == micData && r.half != 0 {
+		// The last bit may not be followed by an edge, use its first pulse only
+		r.addBit(r.half > (zeroBitPulse+oneBitPulse)/2)
+	}
+	data := r.data[:r.bits/8]
+	r.state, r.pilot, r.half, r.data, r.bits = micPilot, 0, 0, nil, 0
+	if len(data) == 0 {
+		return nil
+	}
+	return r.add(data)
+}
+
+// Adds the block and writes all recorded blocks to the file
+func (r *recorder) add(block []byte) error {
+	r.blocks = append(r.blocks, block)
+
+	buf := &bytes.Buffer{}
+	switch getTapeType(r.file) {
+	case tapFile:
+		for _, b := range r.blocks {
+			buf.Write([]byte{byte(len(b)), byte(len(b) >> 8)})
+			buf.Write(b)
+		}
+	case tzxFile:
+		buf.WriteString("ZXTape!\x1A\x01\x14")
+		pause := standardPause
+		for _, b := range r.blocks {
+			buf.Write([]byte{0x10, byte(pause), byte(pause >> 8), byte(len(b)), byte(len(b) >> 8)})
+			buf.Write(b)
+		}
+	default:
+		return fmt.Errorf("Tape format not supported for saving: %s", filepath.Ext(r.file))
+	}
+
+	return ioutil.WriteFile(r.file, buf.Bytes(), 0644)
+}
+
+// Checks whether the pulse length matches the expected length
+func matchPulse(length, expected int) bool {
+	delta := expected * micTolerance / 100
+	return length >= expected-delta && length <= expected+delta
+}
@@ -0,0 +1,39 @@
+package tape
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Recorder(t *testing.T) {
+	for _, ext := range []string{".tap", ".tzx"} {
+		tape := &Tape{}
+		file := filepath.Join(t.TempDir(), "test"+ext)
+		assert.Nil(t, tape.StartRecording(file))
+		assert.True(t, tape.IsRecording())
+
+		// MIC output of the standard signal
+		block := []byte{0xFF, 0x01, 0x80, 0x7E}
+		s := newStandardSignal(block, 0)
+		total, mic := int64(0), byte(0)
+		for p, ok := s.next(); ok; p, ok = s.next() {
+			mic ^= 0x08
+			tape.MIC(mic, total)
+			total += int64(p.length)
+		}
+		assert.Nil(t, tape.StopRecording())
+		assert.False(t, tape.IsRecording())
+
+		data, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Nil(t, tape.LoadData(data, ext))
+		b := tape.reader.NextBlock()
+		assert.Equal(t, &TapeBlock{flag: 0xFF, data: []byte{0x01, 0x80}, checksum: 0x7E}, b, ext)
+		assert.Nil(t, tape.reader.NextBlock())
+	}
+
+	assert.NotNil(t, (&Tape{}).StartRecording("test.wav"))
+}
@@ -0,0 +1,208 @@
+package tape
+
+// Level change at the start of the pulse
+const (
+	edgeToggle  = iota // signal level is inverted
+	levelLow           // signal level is set low
+	levelHigh          // signal level is set high
+	levelKeep          // signal level is not changed
+	stopTape           // tape is stopped
+	stopTape48k        // tape is stopped if the machine is 48k
+	pauseBlock         // tape is stopped if auto stop is enabled
+)
+
+// Standard ROM loader timings in T states
+const (
+	pilotPulse     = 2168
+	pilotHeader    = 8063 // number of pilot pulses for header block (flag < 128)
+	pilotData      = 3223 // number of pilot pulses for data block (flag >= 128)
+	sync1Pulse     = 667
+	sync2Pulse     = 735
+	zeroBitPulse   = 855
+	oneBitPulse    = 1710
+	standardPause  = 1000 // pause after the block in ms
+	statesPerMilli = 3500 // T states per ms, TZX timings are relative to 3.5MHz
+)
+
+// Pulses are generated in T states of the 3.5MHz reference clock and scaled to
+// the machine clock when played, so the signal keeps its real time length
+const referenceClock = statesPerMilli * 1000
+
+// Represents a single pulse of the tape signal
+type pulse struct {
+	length int // length of the pulse in T states
+	level  int // level change at the start of the pulse
+}
+
+// Generates the pulses of a single tape block
+type signal interface {
+	// Returns the next pulse, false if there are no more pulses
+	next() (pulse, bool)
+}
+
+// Signal stages of the data block
+const (
+	stagePilot = iota
+	stageSync
+	stageData
+	stagePause
+	stageDone
+)
+
+// Signal of the data block: pilot tone, sync pulses, data and pause
+type dataSignal struct {
+	pilotLength int    // length of the pilot pulse
+	pilotCount  int    // number of pilot pulses
+	syncs       []int  // sync pulses
+	zero, one   int    // lengths of zero and one bit pulses
+	data        []byte // data bytes
+	lastBits    int    // used bits in the last byte
+	pause       int    // pause after the block in ms
+	stage       int
+	index       int // index of pulse within the stage
+}
+
+// Creates signal of the block using standard ROM loader timings
+func newStandardSignal(data []byte, pause int) *dataSignal {
+	count := pilotHeader
+	if len(data) > 0 && data[0] >= 128 {
+		count = pilotData
+	}
+
+	return &dataSignal{
+		pilotLength: pilotPulse,
+		pilotCount:  count,
+		syncs:       []int{sync1Pulse, sync2Pulse},
+		zero:        zeroBitPulse,
+		one:         oneBitPulse,
+		data:        data,
+		lastBits:    8,
+		pause:       pause,
+	}
+}
+
+func (s *dataSignal) next() (pulse, bool) {
+	for {
+		switch s.stage {
+		case stagePilot:
+			if s.index < s.pilotCount {
+				s.index++
+				return pulse{length: s.pilotLength, level: edgeToggle}, true
+			}
+		case stageSync:
+			if s.index < len(s.syncs) {
+				s.index++
+				return pulse{length: s.syncs[s.index-1], level: edgeToggle}, true
+			}
+		case stageData:
+			// Each bit is represented by two pulses of the same length
+			bits := len(s.data) * 8
+			if len(s.data) > 0 {
+				bits -= 8 - s.lastBits
+			}
+			if s.index < bits*2 {
+				bit := s.index / 2
+				s.index++
+				length := s.zero
+				if s.data[bit/8]&(0x80>>(bit%8)) != 0 {
+					length = s.one
+				}
+				return pulse{length: length, level: edgeToggle}, true
+			}
+		case stagePause:
+			if s.index == 0 && s.pause > 0 {
+				s.index++
+				return pulse{length: s.pause * statesPerMilli, level: levelLow}, true
+			}
+		default:
+			return pulse{}, false
+		}
+
+		s.stage++
+		s.index = 0
+	}
+}
+
+// Signal of the pure tone block: sequence of the pulses of the same length
+func newToneSignal(length, count int) *dataSignal {
+	return &dataSignal{pilotLength: length, pilotCount: count}
+}
+
+// Signal of the pulse sequence block: sequence of the pulses of different lengths
+func newPulsesSignal(lengths []int) *dataSignal {
+	return &dataSignal{syncs: lengths}
+}
+
+// Signal of the pause (silence) block, the signal level is low. The tape
+// can be stopped automatically at the start of the block.
+func newPauseSignal(pause int) *pulseSignal {
+	return &pulseSignal{pulses: appendPause([]pulse{{length: 0, level: pauseBlock}}, pause)}
+}
+
+// Signal of the blocks stored as the list of pulses
+type pulseSignal struct {
+	pulses []pulse
+	index  int
+}
+
+func (s *pulseSignal) next() (pulse, bool) {
+	if s.index >= len(s.pulses) {
+		return pulse{}, false
+	}
+	s.index++
+	return s.pulses[s.index-1], true
+}
+
+// Appends the pause to the pulses, the signal level is low during the pause
+func appendPause(pulses []pulse, pause int) []pulse {
+	if pause > 0 {
+		pulses = append(pulses, pulse{length: pause * statesPerMilli, level: levelLow})
+	}
+	return pulses
+}
+
+// Signal of the direct recording block, each bit of the data is one sample of
+// the signal level. Samples of the same level are joined into a single pulse.
+func newDirectSignal(data []byte, statesPerSample, lastBits, pause int) *pulseSignal {
+	pulses := []pulse{}
+	for i, b := range data {
+		bits := 8
+		if i == len(data)-1 {
+			bits = lastBits
+		}
+		for bit := 0; bit < bits; bit++ {
+			level := levelLow
+			if b&(0x80>>bit) != 0 {
+				level = levelHigh
+			}
+			if n := len(pulses); n > 0 && pulses[n-1].level == level {
+				pulses[n-1].length += statesPerSample
+			} else {
+				pulses = append(pulses, pulse{length: statesPerSample, level: level})
+			}
+		}
+	}
+
+	return &pulseSignal{pulses: appendPause(pulses, pause)}
+}
+
+// Signal of the CSW recording, pulse lengths are specified as number of samples
+// at the sample rate
+func newCSWSignal(samples []int, rate, pause int) *pulseSignal {
+	pulses := make([]pulse, 0, len(samples)+1)
+	total, last := int64(0), int64(0)
+	for _, n := range samples {
+		// Keep the rounding error from accumulating
+		total += int64(n)
+		t := total * statesPerMilli * 1000 / int64(rate)
+		pulses = append(pulses, pulse{length: int(t - last), level: edgeToggle})
+		last = t
+	}
+
+	return &pulseSignal{pulses: appendPause(pulses, pause)}
+}
+
+// Signal of the single control pulse, e.g. stop the tape
+func newControlSignal(level int) *pulseSignal {
+	return &pulseSignal{pulses: []pulse{{length: 0, level: level}}}
+}
@@ -0,0 +1,87 @@
+package tape
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StandardSignal(t *testing.T) {
+	for _, test := range []struct {
+		data  []byte
+		pilot int
+	}{
+		{[]byte{0x00, 0x80}, pilotHeader},
+		{[]byte{0xFF, 0x01, 0xFE}, pilotData},
+	} {
+		s := newStandardSignal(test.data, standardPause)
+		pulses := []pulse{}
+		for p, ok := s.next(); ok; p, ok = s.next() {
+			pulses = append(pulses, p)
+		}
+
+		assert.Equal(t, test.pilot+2+len(test.data)*16+1, len(pulses))
+		assert.Equal(t, pulse{pilotPulse, edgeToggle}, pulses[0])
+		assert.Equal(t, pulse{sync1Pulse, edgeToggle}, pulses[test.pilot])
+		assert.Equal(t, pulse{sync2Pulse, edgeToggle}, pulses[test.pilot+1])
+		for i := 0; i < len(test.data)*8; i++ {
+			length := zeroBitPulse
+			if test.data[i/8]&(0x80>>(i%8)) != 0 {
+				length = oneBitPulse
+			}
+			assert.Equal(t, length, pulses[test.pilot+2+i*2].length)
+			assert.Equal(t, length, pulses[test.pilot+3+i*2].length)
+		}
+		assert.Equal(t, pulse{standardPause * statesPerMilli, levelLow}, pulses[len(pulses)-1])
+	}
+}
+
+func Test_EAR(t *testing.T) {
+	tape := &Tape{}
+	assert.Nil(t, tape.LoadData([]byte{0x02, 0x00, 0xFF, 0xFF}, ".tap"))
+
+	// Not playing
+	assert.Equal(t, byte(0x00), tape.EAR(10000))
+	assert.False(t, tape.IsPlaying())
+
+	tape.Play(1000)
+	assert.True(t, tape.IsPlaying())
+	assert.Equal(t, byte(0x40), tape.EAR(1000))
+	assert.Equal(t, byte(0x40), tape.EAR(1000+pilotPulse-1))
+	assert.Equal(t, byte(0x00), tape.EAR(1000+pilotPulse))
+
+	// Stopped tape keeps the position
+	tape.Stop()
+	assert.Equal(t, byte(0x00), tape.EAR(1000+pilotPulse*2))
+	tape.Play(1000 + pilotPulse*2)
+	assert.Equal(t, byte(0x40), tape.EAR(1000+pilotPulse*2))
+
+	// End of the tape stops playing
+	tape.EAR(1 << 40)
+	assert.False(t, tape.IsPlaying())
+
+	tape.Rewind()
+	assert.Equal(t, byte(0x00), tape.EAR(0))
+	tape.Play(0)
+	assert.Equal(t, byte(0x40), tape.EAR(0))
+}
+
+func Test_EARClock(t *testing.T) {
+	tape := &Tape{}
+	tape.SetClock(3.5469)
+	assert.Nil(t, tape.LoadData([]byte{0x02, 0x00, 0xFF, 0xFF}, ".tap"))
+
+	// 100 pilot pulses take the same time as at 3.5MHz, no rounding error accumulates
+	end := int64(1000 + 100*pilotPulse*3546900/referenceClock)
+	tape.Play(1000)
+	assert.Equal(t, byte(0x00), tape.EAR(end-1))
+	assert.Equal(t, byte(0x40), tape.EAR(end))
+
+	// Reference clock is not scaled
+	tape.SetClock(3.5)
+	tape.Rewind()
+	tape.Stop()
+	tape.Play(0)
+	assert.Equal(t, byte(0x00), tape.EAR(100*pilotPulse-1))
+	assert.Equal(t, byte(0x40), tape.EAR(100*pilotPulse))
+}
@@ -1,36 +1,74 @@
 package tape
 
 import (
-	"io/ioutil"
-
 	"github.com/voytas/z80-go-zx/spectrum/helpers"
 )
 
 type tapReader struct {
+	data   []byte
 	reader *helpers.BinaryReader
+	index  int
 }
 
-func newTAPReader(file string) (*tapReader, error) {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
-	}
-
+func newTAPReader(data []byte) *tapReader {
 	return &tapReader{
+		data:   data,
 		reader: helpers.NewBinaryReader(data),
-	}, nil
+	}
 }
 
 func (t *tapReader) NextBlock() *TapeBlock {
-	len := t.reader.ReadWord()
-	data := t.reader.ReadBytes(int(len))
-	if t.reader.Eof {
+	data := t.next()
+	if data == nil || len(data) < 2 {
 		return nil
 	}
 
 	return &TapeBlock{
 		flag:     data[0],
-		data:     data[1 : len-1],
-		checksum: data[len-1],
+		data:     data[1 : len(data)-1],
+		checksum: data[len(data)-1],
+	}
+}
+
+func (t *tapReader) nextSignal() signal {
+	data := t.next()
+	if data == nil {
+		return nil
+	}
+
+	return newStandardSignal(data, standardPause)
+}
+
+func (t *tapReader) seek(block int) {
+	t.reader = helpers.NewBinaryReader(t.data)
+	t.index = 0
+	for i := 0; i < block; i++ {
+		if t.next() == nil {
+			return
+		}
+	}
+}
+
+func (t *tapReader) position() int {
+	return t.index
+}
+
+func (t *tapReader) blocks() []*BlockInfo {
+	blocks := []*BlockInfo{}
+	r := &tapReader{data: t.data, reader: helpers.NewBinaryReader(t.data)}
+	for data := r.next(); data != nil; data = r.next() {
+		blocks = append(blocks, newDataBlockInfo(len(blocks), "Standard speed data", data))
+	}
+	return blocks
+}
+
+// Reads the next block data (flag, data and checksum), nil if there are no more blocks
+func (t *tapReader) next() []byte {
+	len := t.reader.ReadWord()
+	data := t.reader.ReadBytes(int(len))
+	if t.reader.Eof {
+		return nil
 	}
+	t.index += 1
+	return data
 }
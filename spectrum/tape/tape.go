@@ -1,6 +1,9 @@
 package tape
 
 import (
+	"fmt"
+	"io/ioutil"
+	"log"
 	"path/filepath"
 	"strings"
 
@@ -12,16 +15,38 @@ const (
 	noTapeFile = 0
 	tapFile    = 1
 	tzxFile    = 2
+	cswFile    = 3
+	pzxFile    = 4
+	wavFile    = 5
 )
 
 // Type reader
 type TapeReader interface {
 	NextBlock() *TapeBlock
+	// Returns the signal of the next block, nil if there are no more blocks
+	nextSignal() signal
+	// Position the reader so the next block read is the specified one
+	seek(block int)
+	// Returns the index of the next block
+	position() int
+	// Returns the information of all blocks
+	blocks() []*BlockInfo
 }
 
 // Represents a tape
 type Tape struct {
-	reader TapeReader
+	reader       TapeReader
+	signal       signal  // signal of the block being played
+	playing      bool    // tape is playing
+	ear          byte    // current signal level (EAR bit 6)
+	edge         int64   // T state when the current pulse ends
+	start        int64   // T state when the tape started playing
+	played       int64   // reference T states played since the start
+	clock        int64   // machine clock in Hz, 0 if it is the reference clock
+	is48k        bool    // machine is 48k, "stop the tape if in 48k mode" block applies
+	autoStop     bool    // tape is stopped when the pause block is reached
+	wavThreshold float64 // Schmitt trigger threshold for WAV recordings
+	recorder     *recorder
 }
 
 // Represents a tape block
@@ -32,17 +57,23 @@ type TapeBlock struct {
 }
 
 // Handles fast loading (block) when load routine is executed.
-// Only works if standard ROM routine is used.
-func (t *Tape) Load(cpu *z80.Z80, mem *memory.Memory) {
+// Only works if standard ROM routine is used. Returns false if there
+// is no block which could be loaded, e.g. sampled recording.
+func (t *Tape) Load(cpu *z80.Z80, mem *memory.Memory) bool {
+	if t.reader == nil {
+		return false
+	}
 	block := t.reader.NextBlock()
 	// No data to load
 	if block == nil {
-		return
+		return false
 	}
+	// Remaining part of the block being played is skipped
+	t.signal = nil
 
 	// Check if running Load or Verify (CF = 1 or CF = 0)
 	if cpu.Reg.F_&z80.FC == 0 {
-		return
+		return true
 	}
 
 	// Checksum (xor of all bytes)
@@ -66,25 +97,216 @@ func (t *Tape) Load(cpu *z80.Z80, mem *memory.Memory) {
 	cpu.Reg.IXH, cpu.Reg.IXL = byte((addr+len)<<8), byte(addr+len)&0xFF
 	cpu.Reg.A = checksum
 	cpu.Reg.PC = 0x05E0
+
+	return true
+}
+
+// Starts recording the saved blocks to TAP or TZX file
+func (t *Tape) StartRecording(file string) error {
+	switch getTapeType(file) {
+	case tapFile, tzxFile:
+		t.recorder = &recorder{file: file}
+		return nil
+	default:
+		return fmt.Errorf("Tape format not supported for saving: %s", filepath.Ext(file))
+	}
+}
+
+// Stops recording, the block being saved (if any) is written to the file
+func (t *Tape) StopRecording() error {
+	if t.recorder == nil {
+		return nil
+	}
+	err := t.recorder.flush()
+	t.recorder = nil
+	return err
+}
+
+// Checks whether the tape is recording
+func (t *Tape) IsRecording() bool {
+	return t.recorder != nil
+}
+
+// Handles fast saving (block) when save routine is executed. Only works if
+// standard ROM routine is used. Returns false if the tape is not recording.
+func (t *Tape) Save(cpu *z80.Z80, mem *memory.Memory) bool {
+	if t.recorder == nil {
+		return false
+	}
+	if err := t.recorder.save(cpu, mem); err != nil {
+		log.Println("failed to save tape block:", err)
+	}
+	return true
+}
+
+// Processes the value written to the ULA port at the T state,
+// MIC output (bit 3) is recorded when the tape is recording
+func (t *Tape) MIC(data byte, total int64) {
+	if t.recorder == nil {
+		return
+	}
+	if err := t.recorder.output(data&0x08, total); err != nil {
+		log.Println("failed to save tape block:", err)
+	}
 }
 
 // Loads a tape file
 func (t *Tape) LoadFile(file string) error {
-	var err error = nil
-	switch getTapeType(file) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return t.LoadData(data, filepath.Ext(file))
+}
+
+// Loads a tape from data, type is specified by the file extension (.tap, .tzx, .csw, .pzx or .wav)
+func (t *Tape) LoadData(data []byte, ext string) error {
+	var reader TapeReader
+	switch getTapeType(ext) {
 	case tapFile:
-		t.reader, err = newTAPReader(file)
+		reader = newTAPReader(data)
 	case tzxFile:
-		t.reader, err = newTZXReader(file)
+		tzx, err := newTZXReader(data)
+		if err != nil {
+			return err
+		}
+		reader = tzx
+	case cswFile:
+		csw, err := newCSWReader(data)
+		if err != nil {
+			return err
+		}
+		reader = csw
+	case pzxFile:
+		pzx, err := newPZXReader(data)
+		if err != nil {
+			return err
+		}
+		reader = pzx
+	case wavFile:
+		threshold := t.wavThreshold
+		if threshold == 0 {
+			threshold = DefaultWAVThreshold
+		}
+		wav, err := newWAVReader(data, threshold)
+		if err != nil {
+			return err
+		}
+		reader = wav
+	default:
+		return fmt.Errorf("Tape format not supported: %s", ext)
 	}
 
-	return err
+	t.reader = reader
+	t.playing = false
+	t.Rewind()
+
+	return nil
+}
+
+// Starts playing the tape at the T state
+func (t *Tape) Play(total int64) {
+	if t.reader == nil || t.playing {
+		return
+	}
+	t.playing = true
+	t.edge, t.start, t.played = total, total, 0
+}
+
+// Stops playing the tape, it can be resumed from the same position
+func (t *Tape) Stop() {
+	t.playing = false
+}
+
+// Checks whether the tape is playing
+func (t *Tape) IsPlaying() bool {
+	return t.playing
+}
+
+// Rewinds the tape to the beginning
+func (t *Tape) Rewind() {
+	t.Seek(0)
+}
+
+// Returns the tape signal level (EAR bit 6) at the T state
+func (t *Tape) EAR(total int64) byte {
+	for t.playing && total >= t.edge {
+		if t.signal == nil {
+			t.signal = t.reader.nextSignal()
+			if t.signal == nil {
+				// End of the tape
+				t.playing = false
+				break
+			}
+		}
+
+		p, ok := t.signal.next()
+		if !ok {
+			t.signal = nil
+			continue
+		}
+
+		switch p.level {
+		case edgeToggle:
+			t.ear ^= 0x40
+		case levelLow:
+			t.ear = 0x00
+		case levelHigh:
+			t.ear = 0x40
+		case stopTape:
+			t.playing = false
+		case stopTape48k:
+			t.playing = !t.is48k
+		case pauseBlock:
+			t.playing = !t.autoStop
+		}
+		// Scaled from the start, so the rounding error doesn't accumulate
+		t.played += int64(p.length)
+		t.edge = t.start + toMachineStates(t.played, t.clock)
+	}
+
+	return t.ear
+}
+
+// Converts the reference (3.5MHz) T states to the T states of the machine clock
+func toMachineStates(states, clock int64) int64 {
+	if clock == 0 {
+		return states
+	}
+	return states * clock / referenceClock
+}
+
+// Sets whether the machine is 48k, the tape can be stopped only for 48k machine
+func (t *Tape) Set48k(is48k bool) {
+	t.is48k = is48k
+}
+
+// Sets the machine clock in MHz, the tape signal is timed relative to it
+func (t *Tape) SetClock(clock float32) {
+	t.clock = int64(float64(clock)*1000000 + 0.5)
+	if t.clock == referenceClock {
+		t.clock = 0
+	}
+}
+
+// Sets the Schmitt trigger threshold (fraction of the full scale) used to detect
+// signal level changes in WAV recordings, it applies to tapes loaded afterwards
+func (t *Tape) SetWAVThreshold(threshold float64) {
+	t.wavThreshold = threshold
+}
+
+// Positions the tape at the specified block
+func (t *Tape) Seek(block int) {
+	if t.reader != nil {
+		t.reader.seek(block)
+	}
+	t.signal = nil
+	t.ear = 0x00
 }
 
-// Checks if specified file is *.tap or *.tzx
+// Checks if specified file is *.tap, *.tzx, *.csw, *.pzx or *.wav
 func (t *Tape) IsTape(file string) bool {
-	ft := getTapeType(file)
-	return ft == tapFile || ft == tzxFile
+	return getTapeType(file) != noTapeFile
 }
 
 // Returns the type of the tape file
@@ -94,6 +316,12 @@ func getTapeType(file string) int {
 		return tapFile
 	case ".tzx":
 		return tzxFile
+	case ".csw":
+		return cswFile
+	case ".pzx":
+		return pzxFile
+	case ".wav":
+		return wavFile
 	default:
 		return noTapeFile
 	}
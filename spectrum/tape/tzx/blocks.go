@@ -133,11 +133,11 @@ type Select struct {
 }
 
 type StopTheTape48kDataBlock struct {
-	Length uint16 // Length of the block without these four bytes (0)
+	Length uint32 // Length of the block without these four bytes (0)
 }
 
 type SetSignalLevelDataBlock struct {
-	Length uint16 // lock length (without these four bytes)
+	Length uint32 // Block length (without these four bytes)
 	Level  byte   // Signal level (0=low, 1=high)
 }
 
@@ -250,7 +250,7 @@ func (b *GeneralizedDataBlock) populate(r *helpers.BinaryReader) {
 	b.DataMaxPulses = r.ReadByte()
 	b.DataSymbolsCountAlpha = r.ReadByte()
 	if b.PilotTotalSymbols > 0 {
-		b.PilotSymbols = make([]*SymbolDef, int(b.PilotSymbolsCountAlpha))
+		b.PilotSymbols = make([]*SymbolDef, alphabetSize(b.PilotSymbolsCountAlpha))
 		for i := 0; i < len(b.PilotSymbols); i++ {
 			b.PilotSymbols[i] = &SymbolDef{
 				Flags:        r.ReadByte(),
@@ -266,17 +266,35 @@ func (b *GeneralizedDataBlock) populate(r *helpers.BinaryReader) {
 		}
 	}
 	if b.DataTotalSymbols > 0 {
-		b.DataSymbols = make([]*SymbolDef, int(b.DataSymbolsCountAlpha))
+		b.DataSymbols = make([]*SymbolDef, alphabetSize(b.DataSymbolsCountAlpha))
 		for i := 0; i < len(b.DataSymbols); i++ {
 			b.DataSymbols[i] = &SymbolDef{
 				Flags:        r.ReadByte(),
 				PulseLengths: r.ReadWords(int(b.DataMaxPulses)),
 			}
 		}
-		b.DataStream = r.ReadBytes(int(b.DataTotalSymbols / 8))
+		bits := int(b.DataTotalSymbols) * b.DataSymbolBits()
+		b.DataStream = r.ReadBytes((bits + 7) / 8)
 	}
 }
 
+// Number of bits per data symbol in the data stream
+func (b *GeneralizedDataBlock) DataSymbolBits() int {
+	bits := 0
+	for 1<<bits < alphabetSize(b.DataSymbolsCountAlpha) {
+		bits++
+	}
+	return bits
+}
+
+// Alphabet size of the generalized data block, 0 means 256
+func alphabetSize(count byte) int {
+	if count == 0 {
+		return 256
+	}
+	return int(count)
+}
+
 func (b *SilenceDataBlock) populate(r *helpers.BinaryReader) {
 	b.PauseDuration = r.ReadWord()
 }
@@ -317,17 +335,17 @@ func (b *SelectDataBlock) populate(r *helpers.BinaryReader) {
 			Offset: r.ReadWord(),
 		}
 		s.Length = r.ReadByte()
-		s.Chars = r.ReadBytes(int(b.Length))
+		s.Chars = r.ReadBytes(int(s.Length))
 		b.Selections[i] = s
 	}
 }
 
 func (b *StopTheTape48kDataBlock) populate(r *helpers.BinaryReader) {
-	b.Length = r.ReadWord()
+	b.Length = r.ReadDWord()
 }
 
 func (b *SetSignalLevelDataBlock) populate(r *helpers.BinaryReader) {
-	b.Length = r.ReadWord()
+	b.Length = r.ReadDWord()
 	b.Level = r.ReadByte()
 }
 
@@ -1,6 +1,7 @@
 package tzx
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 
@@ -47,16 +48,27 @@ type Block interface {
 	populate(r *helpers.BinaryReader)
 }
 
+// Loads TZX file
 func Load(file string) (*Tzx, error) {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
+	tzx, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid TZX file.", file)
+	}
+
+	return tzx, nil
+}
+
+// Parses TZX data
+func Parse(data []byte) (*Tzx, error) {
 	r := helpers.NewBinaryReader(data)
 	header := readHeader(r)
 	if header == nil || header.signature != signature || header.eot != eot {
-		return nil, fmt.Errorf("'%s' is not a valid TZX file.", file)
+		return nil, errors.New("Not a valid TZX data.")
 	}
 
 	tzx := &Tzx{
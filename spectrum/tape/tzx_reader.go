@@ -1,18 +1,25 @@
 package tape
 
 import (
-	"reflect"
+	"fmt"
+	"log"
+	"strings"
 
 	"github.com/voytas/z80-go-zx/spectrum/tape/tzx"
 )
 
 type tzxReader struct {
-	tzx   *tzx.Tzx
-	index int
+	tzx       *tzx.Tzx
+	index     int
+	loopStart int      // index of the first block in the loop
+	loopCount int      // remaining loop repetitions
+	callBlock int      // index of the call sequence block
+	calls     []uint16 // call sequence offsets, nil if not in the call sequence
+	call      int      // index of the current call
 }
 
-func newTZXReader(file string) (*tzxReader, error) {
-	tzx, err := tzx.Load(file)
+func newTZXReader(data []byte) (*tzxReader, error) {
+	tzx, err := tzx.Parse(data)
 	if err != nil {
 		return nil, err
 	}
@@ -23,30 +30,299 @@ func newTZXReader(file string) (*tzxReader, error) {
 }
 
 func (t *tzxReader) NextBlock() *TapeBlock {
-	if t.index >= len(t.tzx.Blocks) {
-		return nil
+	for {
+		var data []byte
+		switch b := t.nextBlock().(type) {
+		case nil:
+			return nil
+		case *tzx.StandardSpeedDataBlock:
+			data = b.Data
+		case *tzx.TurboSpeedDataBlock:
+			data = b.Data
+		case *tzx.PureDataBlock:
+			data = b.Data
+		}
+
+		// Only blocks with the flag and checksum can be loaded by the ROM routine
+		if len(data) >= 2 {
+			return &TapeBlock{
+				flag:     data[0],
+				data:     data[1 : len(data)-1],
+				checksum: data[len(data)-1],
+			}
+		}
+	}
+}
+
+func (t *tzxReader) nextSignal() signal {
+	for {
+		switch b := t.nextBlock().(type) {
+		case nil:
+			return nil
+		case *tzx.StandardSpeedDataBlock:
+			return newStandardSignal(b.Data, int(b.PauseAfter))
+		case *tzx.TurboSpeedDataBlock:
+			return &dataSignal{
+				pilotLength: int(b.PilotPulseLength),
+				pilotCount:  int(b.PilotToneLength),
+				syncs:       []int{int(b.SyncPulse1Length), int(b.SyncPulse2Length)},
+				zero:        int(b.ZeroBitPulseLength),
+				one:         int(b.OneBitPulseLength),
+				data:        b.Data,
+				lastBits:    int(b.UsedBitsLastByte),
+				pause:       int(b.PauseAfter),
+			}
+		case *tzx.PureDataBlock:
+			return &dataSignal{
+				zero:     int(b.ZeroBitPulseLength),
+				one:      int(b.OneBitPulseLength),
+				data:     b.Data,
+				lastBits: int(b.UsedBitsLastByte),
+				pause:    int(b.PauseAfter),
+			}
+		case *tzx.PureToneDataBlock:
+			return newToneSignal(int(b.PulseLength), int(b.PulseCount))
+		case *tzx.PulseSequenceDataBlock:
+			lengths := make([]int, len(b.PulseLengths))
+			for i, l := range b.PulseLengths {
+				lengths[i] = int(l)
+			}
+			return newPulsesSignal(lengths)
+		case *tzx.DirectRecordingDataBlock:
+			return newDirectSignal(b.Data, int(b.TStatesPerSample), int(b.UsedBitsLastByte), int(b.PauseAfter))
+		case *tzx.CswRecordingDataBlock:
+			rate := int(b.SamplingRate[0]) | int(b.SamplingRate[1])<<8 | int(b.SamplingRate[2])<<16
+			samples, err := decodeCSW(b.Data, b.CompressionType)
+			if err != nil || rate == 0 {
+				log.Printf("TZX CSW recording block ignored: %v", err)
+				continue
+			}
+			return newCSWSignal(samples, rate, int(b.PauseAfter))
+		case *tzx.GeneralizedDataBlock:
+			return newGeneralizedSignal(b)
+		case *tzx.SilenceDataBlock:
+			if b.PauseDuration == 0 {
+				return newControlSignal(stopTape)
+			}
+			return newPauseSignal(int(b.PauseDuration))
+		case *tzx.StopTheTape48kDataBlock:
+			return newControlSignal(stopTape48k)
+		case *tzx.SetSignalLevelDataBlock:
+			level := levelLow
+			if b.Level != 0 {
+				level = levelHigh
+			}
+			return newControlSignal(level)
+		}
 	}
+}
 
-	block := t.tzx.Blocks[t.index]
-	for block != nil {
-		name := reflect.TypeOf(block).Elem().Name()
+// Returns the next block to play or load, nil if there are no more blocks.
+// Control blocks (loops, calls, jumps) are processed and information
+// blocks (groups, texts, etc.) are skipped.
+func (t *tzxReader) nextBlock() tzx.Block {
+	for t.index >= 0 && t.index < len(t.tzx.Blocks) {
+		current := t.index
+		block := t.tzx.Blocks[current]
 		t.index += 1
-		switch name {
-		case "StandardSpeedDataBlock":
-			b := block.(*tzx.StandardSpeedDataBlock)
-			return &TapeBlock{
-				flag:     b.Data[0],
-				data:     b.Data[1 : b.Length-1],
-				checksum: b.Data[b.Length-1],
+		switch b := block.(type) {
+		case *tzx.LoopStartDataBlock:
+			t.loopStart, t.loopCount = t.index, int(b.Count)
+		case *tzx.LoopEndDataBlock:
+			if t.loopCount > 1 {
+				t.loopCount -= 1
+				t.index = t.loopStart
 			}
-		default:
-			if t.index < len(t.tzx.Blocks) {
-				block = t.tzx.Blocks[t.index]
-			} else {
-				return nil
+		case *tzx.JumpToDataBlock:
+			// Jump 0 would loop forever
+			if b.Jump != 0 {
+				t.index = current + int(int16(b.Jump))
 			}
+		case *tzx.CallSequenceDataBlock:
+			if len(b.Offsets) > 0 {
+				t.callBlock, t.calls, t.call = current, b.Offsets, 0
+				t.index = current + int(int16(b.Offsets[0]))
+			}
+		case *tzx.ReturnFromSequenceDataBlock:
+			if t.calls != nil {
+				t.call += 1
+				if t.call < len(t.calls) {
+					t.index = t.callBlock + int(int16(t.calls[t.call]))
+				} else {
+					t.index = t.callBlock + 1
+					t.calls = nil
+				}
+			}
+		case *tzx.StandardSpeedDataBlock, *tzx.TurboSpeedDataBlock, *tzx.PureToneDataBlock,
+			*tzx.PulseSequenceDataBlock, *tzx.PureDataBlock, *tzx.DirectRecordingDataBlock,
+			*tzx.CswRecordingDataBlock, *tzx.GeneralizedDataBlock, *tzx.SilenceDataBlock,
+			*tzx.StopTheTape48kDataBlock, *tzx.SetSignalLevelDataBlock:
+			return block
 		}
 	}
 
 	return nil
 }
+
+func (t *tzxReader) seek(block int) {
+	t.index = block
+	t.loopCount = 0
+	t.calls = nil
+}
+
+// Signal of the generalized data block. Each symbol starts with the level
+// change given by its flags, the following pulses of the symbol toggle the level.
+func newGeneralizedSignal(b *tzx.GeneralizedDataBlock) *pulseSignal {
+	pulses := []pulse{}
+	appendSymbol := func(symbols []*tzx.SymbolDef, index int) {
+		if index >= len(symbols) {
+			return
+		}
+		level := []int{edgeToggle, levelKeep, levelLow, levelHigh}[symbols[index].Flags&0x03]
+		for _, length := range symbols[index].PulseLengths {
+			if length == 0 {
+				break
+			}
+			pulses = append(pulses, pulse{length: int(length), level: level})
+			level = edgeToggle
+		}
+	}
+
+	// Pilot and sync
+	for _, prle := range b.PilotDataStream {
+		for i := 0; i < int(prle.RepeatCount); i++ {
+			appendSymbol(b.PilotSymbols, int(prle.Symbol))
+		}
+	}
+
+	// Data, symbols are stored using the minimum number of bits (MSb first)
+	bits := b.DataSymbolBits()
+	for i := 0; i < int(b.DataTotalSymbols); i++ {
+		symbol := 0
+		for bit := i * bits; bit < (i+1)*bits; bit++ {
+			symbol <<= 1
+			if bit/8 < len(b.DataStream) && b.DataStream[bit/8]&(0x80>>(bit%8)) != 0 {
+				symbol |= 1
+			}
+		}
+		appendSymbol(b.DataSymbols, symbol)
+	}
+
+	return &pulseSignal{pulses: appendPause(pulses, int(b.PauseAfter))}
+}
+
+func (t *tzxReader) position() int {
+	return t.index
+}
+
+func (t *tzxReader) blocks() []*BlockInfo {
+	blocks := make([]*BlockInfo, len(t.tzx.Blocks))
+	for i, block := range t.tzx.Blocks {
+		info := &BlockInfo{Index: i, Flag: -1}
+		switch b := block.(type) {
+		case *tzx.StandardSpeedDataBlock:
+			info = newDataBlockInfo(i, "Standard speed data", b.Data)
+		case *tzx.TurboSpeedDataBlock:
+			info = newDataBlockInfo(i, "Turbo speed data", b.Data)
+		case *tzx.PureDataBlock:
+			info = newDataBlockInfo(i, "Pure data", b.Data)
+		case *tzx.PureToneDataBlock:
+			info.Type = "Pure tone"
+			info.Description = fmt.Sprintf("%d pulses of %d T states", b.PulseCount, b.PulseLength)
+		case *tzx.PulseSequenceDataBlock:
+			info.Type = "Pulse sequence"
+			info.Description = fmt.Sprintf("%d pulses", b.PulseCount)
+		case *tzx.DirectRecordingDataBlock:
+			info.Type = "Direct recording"
+			info.Length = len(b.Data)
+			info.Description = fmt.Sprintf("%d T states per sample", b.TStatesPerSample)
+		case *tzx.CswRecordingDataBlock:
+			info.Type = "CSW recording"
+			info.Length = len(b.Data)
+			info.Description = fmt.Sprintf("%d pulses", b.PulseCount)
+		case *tzx.GeneralizedDataBlock:
+			info.Type = "Generalized data"
+			info.Length = len(b.DataStream)
+			info.Description = fmt.Sprintf("%d data symbols", b.DataTotalSymbols)
+		case *tzx.SilenceDataBlock:
+			info.Type = "Pause"
+			info.Description = fmt.Sprintf("%d ms", b.PauseDuration)
+			if b.PauseDuration == 0 {
+				info.Description = "Stop the tape"
+			}
+		case *tzx.GroupStartDataBlock:
+			info.Type = "Group start"
+			info.Description = string(b.Chars)
+		case *tzx.GroupEndDataBlock:
+			info.Type = "Group end"
+		case *tzx.JumpToDataBlock:
+			info.Type = "Jump"
+			info.Description = fmt.Sprintf("to block %d", i+int(int16(b.Jump)))
+		case *tzx.LoopStartDataBlock:
+			info.Type = "Loop start"
+			info.Description = fmt.Sprintf("%d repetitions", b.Count)
+		case *tzx.LoopEndDataBlock:
+			info.Type = "Loop end"
+		case *tzx.CallSequenceDataBlock:
+			info.Type = "Call sequence"
+			calls := []string{}
+			for _, offset := range b.Offsets {
+				calls = append(calls, fmt.Sprint(i+int(int16(offset))))
+			}
+			info.Description = "blocks " + strings.Join(calls, ", ")
+		case *tzx.ReturnFromSequenceDataBlock:
+			info.Type = "Return from sequence"
+		case *tzx.SelectDataBlock:
+			info.Type = "Select"
+			selections := []string{}
+			for _, s := range b.Selections {
+				selections = append(selections, string(s.Chars))
+			}
+			info.Description = strings.Join(selections, ", ")
+		case *tzx.StopTheTape48kDataBlock:
+			info.Type = "Stop the tape if in 48k mode"
+		case *tzx.SetSignalLevelDataBlock:
+			info.Type = "Set signal level"
+			info.Description = "low"
+			if b.Level != 0 {
+				info.Description = "high"
+			}
+		case *tzx.TextDescriptionDataBlock:
+			info.Type = "Text description"
+			info.Description = string(b.Description)
+		case *tzx.MessageDataBlock:
+			info.Type = "Message"
+			info.Description = string(b.Message)
+		case *tzx.ArchiveInfoDataBlock:
+			info.Type = "Archive info"
+			texts := []string{}
+			for _, text := range b.Texts {
+				texts = append(texts, archiveInfoName(text.Id)+": "+string(text.Text))
+			}
+			info.Description = strings.Join(texts, ", ")
+		case *tzx.HardwareTypeDataBlock:
+			info.Type = "Hardware type"
+			info.Description = fmt.Sprintf("%d entries", b.Count)
+		case *tzx.CustomInfoDataBlock:
+			info.Type = "Custom info"
+			info.Description = strings.TrimRight(string(b.IdText), " \x00")
+		case *tzx.GlueDataBlock:
+			info.Type = "Glue"
+		}
+		blocks[i] = info
+	}
+
+	return blocks
+}
+
+// Returns the name of the archive info text
+func archiveInfoName(id byte) string {
+	names := map[byte]string{
+		0x00: "Title", 0x01: "Publisher", 0x02: "Author", 0x03: "Year", 0x04: "Language",
+		0x05: "Type", 0x06: "Price", 0x07: "Loader", 0x08: "Origin", 0xFF: "Comment",
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("Info %02X", id)
+}
@@ -0,0 +1,102 @@
+package tape
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/spectrum/tape/tzx"
+)
+
+// Standard speed data block with the flag and no data
+func tzxStandardBlock(flag byte) []byte {
+	return []byte{tzx.StandardSpeedDataBlockId, 0x00, 0x00, 0x02, 0x00, flag, flag}
+}
+
+func Test_TZXControlBlocks(t *testing.T) {
+	data := []byte("ZXTape!\x1A\x01\x14")
+	for _, block := range [][]byte{
+		{tzx.LoopStartDataBlockId, 0x02, 0x00},                        // 0
+		tzxStandardBlock(0x01),                                        // 1
+		{tzx.LoopEndDataBlockId},                                      // 2
+		{tzx.JumpToDataBlockId, 0x02, 0x00},                           // 3
+		tzxStandardBlock(0x02),                                        // 4
+		{tzx.CallSequenceDataBlockId, 0x01, 0x00, 0x03, 0x00},         // 5
+		tzxStandardBlock(0x03),                                        // 6
+		{tzx.SilenceDataBlockId, 0x00, 0x00},                          // 7
+		tzxStandardBlock(0x04),                                        // 8
+		{tzx.ReturnFromSequenceDataBlockId},                           // 9
+		{tzx.StopTheTape48kDataBlockId, 0x00, 0x00, 0x00, 0x00},       // 10
+		{tzx.SetSignalLevelDataBlockId, 0x01, 0x00, 0x00, 0x00, 0x01}, // 11
+		{tzx.TextDescriptionDataBlockId, 0x01, 'T'},                   // 12
+	} {
+		data = append(data, block...)
+	}
+
+	r, err := newTZXReader(data)
+	assert.Nil(t, err)
+	flags := []byte{}
+	for b := r.NextBlock(); b != nil; b = r.NextBlock() {
+		flags = append(flags, b.flag)
+	}
+	assert.Equal(t, []byte{0x01, 0x01, 0x04, 0x03, 0x04}, flags)
+
+	r.seek(0)
+	levels := []int{}
+	for s := r.nextSignal(); s != nil; s = r.nextSignal() {
+		if s, ok := s.(*pulseSignal); ok {
+			levels = append(levels, s.pulses[0].level)
+		}
+	}
+	assert.Equal(t, []int{stopTape, stopTape48k, levelHigh}, levels)
+
+	// Tape stops at the pause 0 and stop the tape if in 48k mode blocks
+	tape := &Tape{}
+	tape.Set48k(true)
+	assert.Nil(t, tape.LoadData(data, ".tzx"))
+	r = tape.reader.(*tzxReader)
+	for _, index := range []int{8, 11, 13} {
+		tape.Play(0)
+		tape.EAR(1 << 40)
+		assert.False(t, tape.IsPlaying())
+		assert.Equal(t, index, r.index)
+	}
+}
+
+func Test_DirectSignal(t *testing.T) {
+	s := newDirectSignal([]byte{0b11100001, 0b10000000}, 79, 2, 1)
+	assert.Equal(t, []pulse{
+		{3 * 79, levelHigh},
+		{4 * 79, levelLow},
+		{2 * 79, levelHigh},
+		{1 * 79, levelLow},
+		{statesPerMilli, levelLow},
+	}, s.pulses)
+}
+
+func Test_GeneralizedSignal(t *testing.T) {
+	b := &tzx.GeneralizedDataBlock{
+		PilotTotalSymbols: 2,
+		PilotSymbols: []*tzx.SymbolDef{
+			{Flags: 0x00, PulseLengths: []uint16{2168, 0}},
+			{Flags: 0x00, PulseLengths: []uint16{667, 735}},
+		},
+		PilotDataStream:       []*tzx.Prle{{Symbol: 0, RepeatCount: 3}, {Symbol: 1, RepeatCount: 1}},
+		DataTotalSymbols:      3,
+		DataSymbolsCountAlpha: 3,
+		DataSymbols: []*tzx.SymbolDef{
+			{Flags: 0x02, PulseLengths: []uint16{100, 0}},
+			{Flags: 0x03, PulseLengths: []uint16{200, 0}},
+			{Flags: 0x01, PulseLengths: []uint16{300, 400}},
+		},
+		DataStream: []byte{0b10011000},
+	}
+	assert.Equal(t, 2, b.DataSymbolBits())
+	s := newGeneralizedSignal(b)
+	assert.Equal(t, []pulse{
+		{2168, edgeToggle}, {2168, edgeToggle}, {2168, edgeToggle},
+		{667, edgeToggle}, {735, edgeToggle},
+		{300, levelKeep}, {400, edgeToggle},
+		{200, levelHigh},
+		{300, levelKeep}, {400, edgeToggle},
+	}, s.pulses)
+}
@@ -0,0 +1,185 @@
+package tape
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/voytas/z80-go-zx/spectrum/helpers"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+	DefaultWAVThreshold = 0.05 // default Schmitt trigger threshold (fraction of the full scale)
+)
+
+// WAV is a single sampled recording, it does not contain separate blocks
+type wavReader struct {
+	pulses []pulse // pulses detected in the recording
+	played bool    // recording has been played
+}
+
+// Creates the reader of the WAV recording, signal level changes when the sample
+// rises above the threshold or falls below the negative threshold (Schmitt trigger)
+func newWAVReader(data []byte, threshold float64) (*wavReader, error) {
+	samples, rate, err := decodeWAV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove DC offset, so the threshold is relative to the signal centre
+	mean := 0.0
+	for _, s := range samples {
+		mean += s
+	}
+	if len(samples) > 0 {
+		mean /= float64(len(samples))
+	}
+
+	// Sample positions are converted to the reference T states (3.5MHz)
+	pulses := []pulse{}
+	high, last := false, int64(0)
+	for i, s := range samples {
+		s -= mean
+		if high && s < -threshold || !high && s > threshold {
+			t := int64(i) * statesPerMilli * 1000 / int64(rate)
+			pulses = append(pulses, pulse{length: int(t - last), level: wavLevel(high)})
+			high, last = !high, t
+		}
+	}
+	t := int64(len(samples)) * statesPerMilli * 1000 / int64(rate)
+	pulses = append(pulses, pulse{length: int(t - last), level: wavLevel(high)})
+
+	return &wavReader{
+		pulses: pulses,
+	}, nil
+}
+
+// Sampled recording can't be loaded by the ROM routine
+func (w *wavReader) NextBlock() *TapeBlock {
+	return nil
+}
+
+func (w *wavReader) nextSignal() signal {
+	if w.played {
+		return nil
+	}
+	w.played = true
+	return &pulseSignal{pulses: w.pulses}
+}
+
+func (w *wavReader) seek(block int) {
+	w.played = block > 0
+}
+
+func (w *wavReader) position() int {
+	return boolToInt(w.played)
+}
+
+func (w *wavReader) blocks() []*BlockInfo {
+	return []*BlockInfo{{
+		Type:        "WAV recording",
+		Flag:        -1,
+		Description: fmt.Sprintf("%d pulses", len(w.pulses)),
+	}}
+}
+
+// Decodes WAV data (8, 16, 24 or 32 bit PCM or 32 bit float), returns mono
+// samples in range -1 to 1 and the sample rate
+func decodeWAV(data []byte) ([]float64, int, error) {
+	r := helpers.NewBinaryReader(data)
+	if string(r.ReadBytes(4)) != "RIFF" || r.ReadDWord() == 0 || string(r.ReadBytes(4)) != "WAVE" {
+		return nil, 0, errors.New("Not a valid WAV data")
+	}
+
+	var format, channels, bits int
+	var rate int
+	for {
+		id := string(r.ReadBytes(4))
+		size := int(r.ReadDWord())
+		chunk := r.ReadBytes(size)
+		if r.Eof {
+			return nil, 0, errors.New("WAV data chunk not found")
+		}
+		if size&1 != 0 {
+			r.ReadByte() // chunks are word aligned
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("Not a valid WAV format")
+			}
+			c := helpers.NewBinaryReader(chunk)
+			format = int(c.ReadWord())
+			channels = int(c.ReadWord())
+			rate = int(c.ReadDWord())
+			c.ReadDWord() // byte rate
+			c.ReadWord()  // block align
+			bits = int(c.ReadWord())
+			if format == wavFormatExtensible && size >= 26 {
+				c.ReadWord()  // extension size
+				c.ReadWord()  // valid bits
+				c.ReadDWord() // channel mask
+				format = int(c.ReadWord())
+			}
+		case "data":
+			if channels == 0 || rate == 0 {
+				return nil, 0, errors.New("WAV format chunk not found")
+			}
+			samples, err := wavSamples(chunk, format, channels, bits)
+			return samples, rate, err
+		}
+	}
+}
+
+// Converts WAV samples to mono samples in range -1 to 1
+func wavSamples(data []byte, format, channels, bits int) ([]float64, error) {
+	if !(format == wavFormatPCM && (bits == 8 || bits == 16 || bits == 24 || bits == 32) ||
+		format == wavFormatFloat && bits == 32) {
+		return nil, errors.New("WAV format not supported")
+	}
+
+	size := bits / 8
+	count := len(data) / (size * channels)
+	samples := make([]float64, count)
+	for i := 0; i < count; i++ {
+		for c := 0; c < channels; c++ {
+			b := data[(i*channels+c)*size:]
+			var s float64
+			switch {
+			case format == wavFormatFloat:
+				s = float64(math.Float32frombits(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24))
+			case bits == 8:
+				s = (float64(b[0]) - 128) / 128
+			case bits == 16:
+				s = float64(int16(uint16(b[0])|uint16(b[1])<<8)) / (1 << 15)
+			case bits == 24:
+				s = float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+			case bits == 32:
+				s = float64(int32(uint32(b[0])|uint32(b[1])<<8|uint32(b[2])<<16|uint32(b[3])<<24)) / (1 << 31)
+			}
+			samples[i] += s / float64(channels)
+		}
+	}
+
+	return samples, nil
+}
+
+// Signal level of the pulse
+func wavLevel(high bool) int {
+	if high {
+		return levelHigh
+	}
+	return levelLow
+}
+
+// Converts boolean value to integer
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package tape
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates 8 bit mono WAV data from the samples
+func wavData(rate int, samples []byte) []byte {
+	size := len(samples)
+	data := []byte("RIFF")
+	data = append(data, byte(size+36), byte((size+36)>>8), byte((size+36)>>16), 0)
+	data = append(data, []byte("WAVEfmt ")...)
+	data = append(data, 16, 0, 0, 0, wavFormatPCM, 0, 1, 0)
+	data = append(data, byte(rate), byte(rate>>8), byte(rate>>16), 0)
+	data = append(data, byte(rate), byte(rate>>8), byte(rate>>16), 0, 1, 0, 8, 0)
+	data = append(data, []byte("data")...)
+	data = append(data, byte(size), byte(size>>8), byte(size>>16), 0)
+	return append(data, samples...)
+}
+
+func Test_WAVReader(t *testing.T) {
+	// Record standard block signal with noise and DC offset at 44100Hz
+	const rate = 44100
+	expected := []pulse{}
+	s := newStandardSignal([]byte{0xFF, 0x12, 0x34, 0xDB}, 0)
+	for p, ok := s.next(); ok; p, ok = s.next() {
+		expected = append(expected, p)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	samples := []byte{}
+	high, total := false, int64(0)
+	for _, p := range expected {
+		high = !high
+		total += int64(p.length)
+		for int64(len(samples))*statesPerMilli*1000/rate < total {
+			v := 100 + rnd.Intn(11) - 5
+			if high {
+				v += 50
+			} else {
+				v -= 50
+			}
+			samples = append(samples, byte(v))
+		}
+	}
+
+	r, err := newWAVReader(wavData(rate, samples), DefaultWAVThreshold)
+	assert.Nil(t, err)
+	assert.Nil(t, r.NextBlock())
+
+	// Initial low level pulse is followed by the recorded pulses
+	pulses := r.nextSignal().(*pulseSignal).pulses
+	assert.Nil(t, r.nextSignal())
+	assert.Equal(t, len(expected)+1, len(pulses))
+	for i, p := range expected {
+		assert.InDelta(t, p.length, pulses[i+1].length, statesPerMilli*1000/rate+1, "pulse %d", i)
+	}
+
+	_, err = newWAVReader([]byte("RIFF"), DefaultWAVThreshold)
+	assert.NotNil(t, err)
+}
+
+func Test_decodeWAV(t *testing.T) {
+	// 16 bit stereo
+	data := wavData(22050, []byte{0x00, 0x40, 0x00, 0x40, 0x00, 0xC0, 0x00, 0x00})
+	data[22], data[32], data[34] = 2, 4, 16
+	samples, rate, err := decodeWAV(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 22050, rate)
+	assert.Equal(t, []float64{0.5, -0.25}, samples)
+}
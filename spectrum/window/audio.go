@@ -0,0 +1,77 @@
+package window
+
+import (
+	"io"
+
+	"github.com/hajimehoshi/oto"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
+)
+
+// Audio latency, the ring buffer holds twice as much to absorb the jitter
+const audioLatency = 0.05 // seconds
+
+// Plays the emulator audio samples (stereo, 16 bit) using oto. The samples are
+// passed from the emulation to the audio device using lock-free ring buffer.
+type audio struct {
+	ctx    *oto.Context
+	player *oto.Player
+	ring   *sound.RingBuffer
+	last   [2]int16 // last left and right sample, repeated if there are no samples
+	buf    []int16
+}
+
+func newAudio(sampleRate int) (*audio, error) {
+	frames := int(float64(sampleRate) * audioLatency)
+	ctx, err := oto.NewContext(sampleRate, 2, 2, frames*4)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &audio{
+		ctx:    ctx,
+		player: ctx.NewPlayer(),
+		ring:   sound.NewRingBuffer(frames * 2),
+	}
+
+	go func() {
+		_, _ = io.Copy(a.player, a)
+	}()
+
+	return a, nil
+}
+
+func (a *audio) close() {
+	a.ctx.Close()
+}
+
+// Queues the samples (left and right interleaved) for playing, samples
+// which do not fit into the buffer are dropped
+func (a *audio) play(samples []int16) {
+	a.ring.Write(samples)
+}
+
+// Returns the emulation speed factor to keep the audio buffer level stable
+func (a *audio) speed() float64 {
+	return a.ring.Speed()
+}
+
+// Reads whole frames (left and right sample, little endian)
+func (a *audio) Read(buf []byte) (int, error) {
+	frames := len(buf) / 4
+	if len(a.buf) < frames*2 {
+		a.buf = make([]int16, frames*2)
+	}
+	n := a.ring.Read(a.buf[:frames*2])
+	if n > 0 {
+		a.last = [2]int16{a.buf[n*2-2], a.buf[n*2-1]}
+	}
+	// Buffer underrun, the last level is kept to avoid clicks
+	for i := n; i < frames; i++ {
+		a.buf[i*2], a.buf[i*2+1] = a.last[0], a.last[1]
+	}
+	for i, s := range a.buf[:frames*2] {
+		buf[i*2], buf[i*2+1] = byte(s), byte(s>>8)
+	}
+
+	return frames * 4, nil
+}
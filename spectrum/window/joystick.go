@@ -0,0 +1,127 @@
+package window
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
+)
+
+// Default keys emulating the joystick: up, down, left, right and fire
+const DefaultJoystickKeys = "up,down,left,right,rightctrl"
+
+// Gamepad stick position treated as pressed direction
+const gamepadDeadZone = 0.5
+
+// Names of GLFW keys which can be used to emulate the joystick
+var keyNames = map[string]glfw.Key{
+	"up":        glfw.KeyUp,
+	"down":      glfw.KeyDown,
+	"left":      glfw.KeyLeft,
+	"right":     glfw.KeyRight,
+	"space":     glfw.KeySpace,
+	"tab":       glfw.KeyTab,
+	"enter":     glfw.KeyEnter,
+	"leftctrl":  glfw.KeyLeftControl,
+	"rightctrl": glfw.KeyRightControl,
+	"leftalt":   glfw.KeyLeftAlt,
+	"rightalt":  glfw.KeyRightAlt,
+	"kp8":       glfw.KeyKP8,
+	"kp2":       glfw.KeyKP2,
+	"kp4":       glfw.KeyKP4,
+	"kp6":       glfw.KeyKP6,
+	"kp0":       glfw.KeyKP0,
+	"kp5":       glfw.KeyKP5,
+}
+
+func init() {
+	for k := glfw.KeyA; k <= glfw.KeyZ; k++ {
+		keyNames[string(rune('a'+k-glfw.KeyA))] = k
+	}
+	for k := glfw.Key0; k <= glfw.Key9; k++ {
+		keyNames[string(rune('0'+k-glfw.Key0))] = k
+	}
+}
+
+// Parses comma separated names of the keys emulating the joystick, in order:
+// up, down, left, right and fire
+func ParseJoystickKeys(names string) (map[glfw.Key]byte, error) {
+	list := strings.Split(names, ",")
+	if len(list) != 5 {
+		return nil, fmt.Errorf("Joystick keys must be: up,down,left,right,fire")
+	}
+
+	keys := map[glfw.Key]byte{}
+	for i, dir := range []byte{joystick.Up, joystick.Down, joystick.Left, joystick.Right, joystick.Fire} {
+		name := strings.ToLower(strings.TrimSpace(list[i]))
+		key, ok := keyNames[name]
+		if !ok {
+			return nil, fmt.Errorf("Key not supported: %s", name)
+		}
+		keys[key] = dir
+	}
+	return keys, nil
+}
+
+// Joystick input from the keys and the first connected gamepad
+type joystickInput struct {
+	joy   *joystick.Joystick
+	keys  map[glfw.Key]byte
+	state byte // directions pressed using the keys
+}
+
+// Handles the key, returns false if the key does not emulate the joystick
+func (j *joystickInput) key(key glfw.Key, action glfw.Action) bool {
+	dir, ok := j.keys[key]
+	if !ok {
+		return false
+	}
+	switch action {
+	case glfw.Press:
+		j.state |= dir
+	case glfw.Release:
+		j.state &^= dir
+	}
+	return true
+}
+
+// Updates the joystick state from the keys and gamepad, called every frame
+func (j *joystickInput) update() {
+	j.joy.SetState(j.state | gamepadState())
+}
+
+// Returns the directions pressed on the first connected gamepad
+func gamepadState() byte {
+	for jid := glfw.Joystick1; jid <= glfw.JoystickLast; jid++ {
+		if !jid.IsGamepad() {
+			continue
+		}
+		gp := jid.GetGamepadState()
+		if gp == nil {
+			return 0
+		}
+
+		var state byte
+		x, y := gp.Axes[glfw.AxisLeftX], gp.Axes[glfw.AxisLeftY]
+		if gp.Buttons[glfw.ButtonDpadUp] == glfw.Press || y < -gamepadDeadZone {
+			state |= joystick.Up
+		}
+		if gp.Buttons[glfw.ButtonDpadDown] == glfw.Press || y > gamepadDeadZone {
+			state |= joystick.Down
+		}
+		if gp.Buttons[glfw.ButtonDpadLeft] == glfw.Press || x < -gamepadDeadZone {
+			state |= joystick.Left
+		}
+		if gp.Buttons[glfw.ButtonDpadRight] == glfw.Press || x > gamepadDeadZone {
+			state |= joystick.Right
+		}
+		for _, b := range []glfw.GamepadButton{glfw.ButtonA, glfw.ButtonB, glfw.ButtonX, glfw.ButtonY} {
+			if gp.Buttons[b] == glfw.Press {
+				state |= joystick.Fire
+			}
+		}
+		return state
+	}
+	return 0
+}
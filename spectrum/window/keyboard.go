@@ -0,0 +1,65 @@
+package window
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/voytas/z80-go-zx/spectrum/keyboard"
+)
+
+// Index of GLFW keys and corresponding Spectrum keys
+var keys = map[glfw.Key]byte{
+	glfw.KeyLeftShift:  keyboard.KEY_SHIFT,
+	glfw.KeyRightShift: keyboard.KEY_SYMBOL,
+	glfw.KeyEnter:      keyboard.KEY_ENTER,
+	glfw.KeySpace:      keyboard.KEY_SPACE,
+	glfw.Key1:          keyboard.KEY_1,
+	glfw.Key2:          keyboard.KEY_2,
+	glfw.Key3:          keyboard.KEY_3,
+	glfw.Key4:          keyboard.KEY_4,
+	glfw.Key5:          keyboard.KEY_5,
+	glfw.Key6:          keyboard.KEY_6,
+	glfw.Key7:          keyboard.KEY_7,
+	glfw.Key8:          keyboard.KEY_8,
+	glfw.Key9:          keyboard.KEY_9,
+	glfw.Key0:          keyboard.KEY_0,
+	glfw.KeyA:          keyboard.KEY_A,
+	glfw.KeyB:          keyboard.KEY_B,
+	glfw.KeyC:          keyboard.KEY_C,
+	glfw.KeyD:          keyboard.KEY_D,
+	glfw.KeyE:          keyboard.KEY_E,
+	glfw.KeyF:          keyboard.KEY_F,
+	glfw.KeyG:          keyboard.KEY_G,
+	glfw.KeyH:          keyboard.KEY_H,
+	glfw.KeyI:          keyboard.KEY_I,
+	glfw.KeyJ:          keyboard.KEY_J,
+	glfw.KeyK:          keyboard.KEY_K,
+	glfw.KeyL:          keyboard.KEY_L,
+	glfw.KeyM:          keyboard.KEY_M,
+	glfw.KeyN:          keyboard.KEY_N,
+	glfw.KeyO:          keyboard.KEY_O,
+	glfw.KeyP:          keyboard.KEY_P,
+	glfw.KeyQ:          keyboard.KEY_Q,
+	glfw.KeyR:          keyboard.KEY_R,
+	glfw.KeyS:          keyboard.KEY_S,
+	glfw.KeyT:          keyboard.KEY_T,
+	glfw.KeyU:          keyboard.KEY_U,
+	glfw.KeyV:          keyboard.KEY_V,
+	glfw.KeyW:          keyboard.KEY_W,
+	glfw.KeyX:          keyboard.KEY_X,
+	glfw.KeyY:          keyboard.KEY_Y,
+	glfw.KeyZ:          keyboard.KEY_Z,
+}
+
+// Presses or releases the Spectrum key of the GLFW key
+func keyCallback(kb *keyboard.Keyboard, key glfw.Key, action glfw.Action) {
+	k, ok := keys[key]
+	if !ok {
+		return
+	}
+
+	switch action {
+	case glfw.Press:
+		kb.Press(k)
+	case glfw.Release:
+		kb.Release(k)
+	}
+}
@@ -0,0 +1,272 @@
+package window
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/voytas/z80-go-zx/spectrum"
+	"github.com/voytas/z80-go-zx/spectrum/bus"
+	"github.com/voytas/z80-go-zx/spectrum/joystick"
+	"github.com/voytas/z80-go-zx/spectrum/machine"
+	"github.com/voytas/z80-go-zx/spectrum/screen"
+	"github.com/voytas/z80-go-zx/spectrum/sound"
+	"github.com/voytas/z80-go-zx/z80/gdb"
+)
+
+// Emulator window options
+type Options struct {
+	FileToLoad   string            // snapshot or tape file to load on start
+	SaveFile     string            // snapshot file to save when F2 is pressed or after SaveAfter frames
+	SaveAfter    int               // number of frames to run before saving the snapshot (0 = never)
+	FastLoad     bool              // load tape blocks instantly instead of playing the tape
+	AutoRun      bool              // type LOAD "" when the tape file is loaded on start
+	WAVThreshold float64           // Schmitt trigger threshold for WAV tapes
+	RecordFile   string            // TAP or TZX file to record saved blocks to when F7 is pressed
+	AutoStop     bool              // stop the tape when the pause block is reached
+	Stereo       sound.StereoMode  // layout of the AY channels
+	Separation   float32           // stereo separation (0 to 1)
+	SampleRate   int               // audio sample rate, e.g. 44100 or 48000
+	AudioFile    string            // WAV file to record the audio output to
+	AYFile       string            // PSG or YM file to record AY register writes to
+	AYFormat     int               // format of the AY register log
+	Peripherals  bus.Peripherals   // optional audio peripherals
+	Joystick     int               // joystick interface, e.g. joystick.Kempston
+	JoystickKeys map[glfw.Key]byte // keys emulating the joystick directions and fire
+	Debug        bool              // run the debugger console on stdin/stdout, F9 pauses/resumes
+	GDBPort      int               // localhost port of the GDB remote protocol server (0 = disabled)
+}
+
+func init() {
+	runtime.LockOSThread()
+}
+
+// Runs the emulator in the GLFW window with OpenGL rendering and audio output
+func Run(model *machine.Machine, options *Options) {
+	if err := glfw.Init(); err != nil {
+		log.Fatalln("failed to initialize glfw:", err)
+	}
+	defer glfw.Terminate()
+
+	window, err := glfw.CreateWindow(632, 504, "ZX Spectrum "+model.Name, nil, nil)
+	if err != nil {
+		log.Fatalln("failed to create window:", err)
+	}
+
+	window.MakeContextCurrent()
+
+	if err := gl.Init(); err != nil {
+		log.Fatalln("failed to initialize gl bindings:", err)
+	}
+
+	gl.ClearColor(0, 0, 0, 1)
+	gl.PixelZoom(4, -4)
+	gl.RasterPos2d(-1, 1)
+
+	emu, err := spectrum.NewMachine(model)
+	if err != nil {
+		log.Fatalln("failed to create emulator:", err)
+	}
+	emu.SetFastLoad(options.FastLoad)
+	emu.SetTapeAutoRun(options.AutoRun)
+	emu.SetWAVThreshold(options.WAVThreshold)
+	emu.SetTapeAutoStop(options.AutoStop)
+	emu.SetStereo(options.Stereo, options.Separation)
+	emu.AttachPeripherals(options.Peripherals)
+
+	// Joystick is controlled by the keys or the first connected gamepad
+	var joy *joystickInput
+	if options.Joystick != joystick.None {
+		joy = &joystickInput{joy: joystick.New(options.Joystick), keys: options.JoystickKeys}
+		emu.AttachDevice(joy.joy)
+	}
+	if options.FileToLoad != "" {
+		if err := emu.LoadFile(options.FileToLoad); err != nil {
+			log.Fatalln("failed to load file:", err)
+		}
+		// SZX snapshot may attach the joystick it was saved with
+		if j := emu.Joystick(); joy == nil && j != nil {
+			joy = &joystickInput{joy: j, keys: options.JoystickKeys}
+		}
+	}
+
+	if options.Debug {
+		go emu.Debugger().Serve(os.Stdin, os.Stdout)
+	}
+	if options.GDBPort != 0 {
+		server, err := gdb.Listen(fmt.Sprintf("localhost:%d", options.GDBPort), emu.Debugger())
+		if err != nil {
+			log.Fatalln("failed to start gdb server:", err)
+		}
+		fmt.Printf("GDB server listening on %s\n", server.Addr())
+		go server.Serve()
+		defer server.Close()
+	}
+
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		if action == glfw.Press {
+			switch key {
+			case glfw.KeyF9:
+				if options.Debug {
+					toggleDebugger(emu)
+				}
+				return
+			case glfw.KeyF2:
+				saveSnapshot(emu, options.SaveFile)
+				return
+			case glfw.KeyF5:
+				if emu.IsTapePlaying() {
+					emu.StopTape()
+				} else {
+					emu.PlayTape()
+				}
+				return
+			case glfw.KeyF6:
+				emu.RewindTape()
+				return
+			case glfw.KeyF7:
+				toggleRecording(emu, options.RecordFile)
+				return
+			case glfw.KeyPageUp:
+				seekTape(emu, emu.TapePosition()-1)
+				return
+			case glfw.KeyPageDown:
+				seekTape(emu, emu.TapePosition()+1)
+				return
+			}
+		}
+		if joy != nil && joy.key(key, action) {
+			return
+		}
+		keyCallback(emu.Keyboard(), key, action)
+	})
+
+	defer emu.StopRecording()
+	defer func() {
+		for drive := 0; drive < 2; drive++ {
+			if err := emu.EjectDisk(drive); err != nil {
+				log.Println("failed to save disk:", err)
+			}
+		}
+	}()
+
+	emu.SetSampleRate(options.SampleRate)
+	if options.AudioFile != "" {
+		if err := emu.StartAudioRecording(options.AudioFile); err != nil {
+			log.Fatalln("failed to record audio:", err)
+		}
+		defer func() {
+			if err := emu.StopAudioRecording(); err != nil {
+				log.Println("failed to record audio:", err)
+			}
+		}()
+	}
+
+	if options.AYFile != "" {
+		if err := emu.StartAYRecording(options.AYFile, options.AYFormat); err != nil {
+			log.Fatalln("failed to record AY:", err)
+		}
+		defer func() {
+			if err := emu.StopAYRecording(); err != nil {
+				log.Println("failed to record AY:", err)
+			}
+		}()
+	}
+
+	audio, err := newAudio(emu.SampleRate())
+	if err != nil {
+		log.Fatalln("failed to initialize audio:", err)
+	}
+	defer audio.close()
+
+	// Frame duration is adjusted slightly to keep the audio buffer level stable
+	frameDuration := float64(model.FrameStates) / float64(model.Clock*1000000) * float64(time.Second)
+	next := time.Now()
+
+	frames := 0
+	for !window.ShouldClose() {
+		if joy != nil {
+			joy.update()
+		}
+		emu.RunFrame()
+		frames += 1
+		if frames == options.SaveAfter {
+			saveSnapshot(emu, options.SaveFile)
+		}
+		audio.play(emu.AudioSamples())
+		next = next.Add(time.Duration(frameDuration / audio.speed()))
+		if wait := time.Until(next); wait > 0 {
+			time.Sleep(wait)
+		} else if wait < -time.Second {
+			// Emulation is too slow, do not try to catch up
+			next = time.Now()
+		}
+
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		scr := emu.Screen()
+		gl.DrawPixels(
+			screen.BorderLeft+256+screen.BorderRight,
+			screen.BorderTop+192+screen.BorderBottom,
+			gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&scr.Pix[0]))
+
+		window.SwapBuffers()
+		glfw.PollEvents()
+	}
+}
+
+// Pauses or resumes the execution
+func toggleDebugger(emu *spectrum.Machine) {
+	if d := emu.Debugger(); d.Paused() {
+		d.Resume()
+	} else {
+		d.Pause()
+	}
+}
+
+// Saves the snapshot, if file is not specified it is generated using current time
+func saveSnapshot(emu *spectrum.Machine, file string) {
+	if file == "" {
+		file = fmt.Sprintf("snapshot-%s.szx", time.Now().Format("20060102-150405"))
+	}
+	if err := emu.SaveFile(file); err != nil {
+		log.Println("failed to save snapshot:", err)
+		return
+	}
+	log.Println("snapshot saved:", file)
+}
+
+// Starts or stops recording the saved tape blocks, if file is not specified
+// it is generated using current time
+func toggleRecording(emu *spectrum.Machine, file string) {
+	if emu.IsRecording() {
+		if err := emu.StopRecording(); err != nil {
+			log.Println("failed to save tape:", err)
+		}
+		log.Println("tape recording stopped")
+		return
+	}
+	if file == "" {
+		file = fmt.Sprintf("tape-%s.tzx", time.Now().Format("20060102-150405"))
+	}
+	if err := emu.StartRecording(file); err != nil {
+		log.Println("failed to start tape recording:", err)
+		return
+	}
+	log.Println("tape recording started:", file)
+}
+
+// Positions the tape at the block and logs its description
+func seekTape(emu *spectrum.Machine, block int) {
+	blocks := emu.TapeBlocks()
+	if block < 0 || block >= len(blocks) {
+		return
+	}
+	emu.SeekTape(block)
+	b := blocks[block]
+	log.Printf("tape block %d: %s %s", b.Index, b.Type, b.Description)
+}
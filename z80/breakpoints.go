@@ -0,0 +1,243 @@
+package z80
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Breakpoint types
+const (
+	BreakPC    = iota // PC reaches the address
+	BreakRead         // memory read (data, not opcode or operand fetch) in the address range
+	BreakWrite        // memory write in the address range
+	BreakIn           // port read (IN) in the port range
+	BreakOut          // port write (OUT) in the port range
+	BreakINT          // maskable interrupt accepted
+	BreakNMI          // non-maskable interrupt
+)
+
+var breakNames = []string{"pc", "read", "write", "in", "out", "int", "nmi"}
+
+// Breakpoint stops Run before the next instruction once it is reached, i.e.
+// PC reaches the address, the memory or port in the range is accessed or
+// the interrupt is accepted.
+type Breakpoint struct {
+	ID        int                 // assigned by AddBreakpoint
+	Type      int                 // breakpoint type, e.g. BreakPC
+	From, To  uint16              // address or port range, To lower than From means single address
+	Mask      uint16              // mask applied to the address or port before matching (0 = all lines)
+	Condition func(z80 *Z80) bool // optional condition, e.g. register value
+	Expr      string              // condition description
+	Count     int                 // stops once reached Count times (0 = every time)
+	Hits      int                 // number of times reached (with condition met)
+	Disabled  bool
+}
+
+// Breakpoint hit that stopped the execution
+type Hit struct {
+	Breakpoint *Breakpoint
+	PC         uint16 // address of the instruction
+	Addr       uint16 // memory address or port accessed, address of the interrupt routine
+	Value      byte   // value read or written
+}
+
+// Index of the enabled breakpoints, Z80 has none when no breakpoint is
+// enabled, so the checks cost a nil test only
+type breakpoints struct {
+	pc                                   map[uint16][]*Breakpoint
+	watch                                []*Breakpoint // memory, port and interrupt breakpoints
+	read, write, in, out, interrupt, nmi bool          // types of the enabled breakpoints
+	instrPC                              uint16        // address of the current instruction
+	pending                              *Hit          // hit within the instruction, stops before the next one
+}
+
+// Adds the breakpoint and returns its ID
+func (z80 *Z80) AddBreakpoint(bp *Breakpoint) int {
+	z80.nextBreakID++
+	bp.ID = z80.nextBreakID
+	z80.breakList = append(z80.breakList, bp)
+	z80.UpdateBreakpoints()
+	return bp.ID
+}
+
+// Removes the breakpoint, returns false if it does not exist
+func (z80 *Z80) RemoveBreakpoint(id int) bool {
+	for i, bp := range z80.breakList {
+		if bp.ID == id {
+			z80.breakList = append(z80.breakList[:i], z80.breakList[i+1:]...)
+			z80.UpdateBreakpoints()
+			return true
+		}
+	}
+	return false
+}
+
+// Removes all breakpoints
+func (z80 *Z80) ClearBreakpoints() {
+	z80.breakList = nil
+	z80.UpdateBreakpoints()
+}
+
+// Returns the breakpoint, nil if it does not exist
+func (z80 *Z80) Breakpoint(id int) *Breakpoint {
+	for _, bp := range z80.breakList {
+		if bp.ID == id {
+			return bp
+		}
+	}
+	return nil
+}
+
+// Returns all breakpoints
+func (z80 *Z80) Breakpoints() []*Breakpoint {
+	return z80.breakList
+}
+
+// Rebuilds the index of the enabled breakpoints, it has to be called when
+// the breakpoint is changed (e.g. disabled) after it has been added
+func (z80 *Z80) UpdateBreakpoints() {
+	b := &breakpoints{pc: map[uint16][]*Breakpoint{}}
+	enabled := false
+	for _, bp := range z80.breakList {
+		if bp.Disabled {
+			continue
+		}
+		enabled = true
+		switch bp.Type {
+		case BreakPC:
+			b.pc[bp.From] = append(b.pc[bp.From], bp)
+			continue
+		case BreakRead:
+			b.read = true
+		case BreakWrite:
+			b.write = true
+		case BreakIn:
+			b.in = true
+		case BreakOut:
+			b.out = true
+		case BreakINT:
+			b.interrupt = true
+		case BreakNMI:
+			b.nmi = true
+		}
+		b.watch = append(b.watch, bp)
+	}
+
+	if z80.breaks != nil {
+		b.instrPC, b.pending = z80.breaks.instrPC, z80.breaks.pending
+	}
+	z80.breaks = nil
+	if enabled {
+		z80.breaks = b
+	}
+}
+
+// Returns the breakpoint hit that stopped the last Run or Continue, nil if
+// it was not stopped by a breakpoint
+func (z80 *Z80) Hit() *Hit {
+	return z80.hit
+}
+
+// Checks the PC breakpoints, returns the hit to stop before the instruction.
+// When resuming after the stop at PC (not memory, port or interrupt hit), the
+// breakpoint the execution stopped at is skipped.
+func (z80 *Z80) checkBreakpoints(resume bool) *Hit {
+	pc := z80.Reg.PC
+	z80.breaks.instrPC = pc
+	if resume {
+		return nil
+	}
+	for _, bp := range z80.breaks.pc[pc] {
+		if bp.reached(z80) {
+			return &Hit{Breakpoint: bp, PC: pc, Addr: pc}
+		}
+	}
+	return nil
+}
+
+// Stops the execution at the breakpoint hit
+func (z80 *Z80) breakpointHit(hit *Hit) {
+	z80.breaks.pending = nil
+	z80.stopped, z80.hit = true, hit
+	z80.stoppedAtPC = hit.Breakpoint.Type == BreakPC
+	if z80.OnBreakpoint != nil {
+		z80.OnBreakpoint(hit)
+	}
+}
+
+// Checks the memory, port or interrupt breakpoints of the type, the hit
+// stops the execution before the next instruction
+func (z80 *Z80) watch(kind int, addr uint16, value byte) {
+	b := z80.breaks
+	if b.pending != nil {
+		return
+	}
+	for _, bp := range b.watch {
+		if bp.Type == kind && bp.matches(addr) && bp.reached(z80) {
+			b.pending = &Hit{Breakpoint: bp, PC: b.instrPC, Addr: addr, Value: value}
+			return
+		}
+	}
+}
+
+// Checks whether the address or port is in the range (any interrupt matches)
+func (bp *Breakpoint) matches(addr uint16) bool {
+	if bp.Type == BreakINT || bp.Type == BreakNMI {
+		return true
+	}
+	if bp.Mask != 0 {
+		addr &= bp.Mask
+	}
+	to := bp.To
+	if to < bp.From {
+		to = bp.From
+	}
+	return addr >= bp.From && addr <= to
+}
+
+// Counts the hit if the condition is met, returns true if it should stop
+func (bp *Breakpoint) reached(z80 *Z80) bool {
+	if bp.Condition != nil && !bp.Condition(z80) {
+		return false
+	}
+	bp.Hits++
+	return bp.Count <= 0 || bp.Hits >= bp.Count
+}
+
+// Describes the breakpoint, e.g. 2: write 4000-5AFF if A==0 (hits 1)
+func (bp *Breakpoint) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d: %s", bp.ID, breakNames[bp.Type])
+	if bp.Type != BreakINT && bp.Type != BreakNMI {
+		fmt.Fprintf(&b, " %04X", bp.From)
+		if bp.To > bp.From {
+			fmt.Fprintf(&b, "-%04X", bp.To)
+		}
+		if bp.Mask != 0 {
+			fmt.Fprintf(&b, "/%04X", bp.Mask)
+		}
+	}
+	if bp.Expr != "" {
+		fmt.Fprintf(&b, " if %s", bp.Expr)
+	}
+	if bp.Count > 0 {
+		fmt.Fprintf(&b, " after %d", bp.Count)
+	}
+	fmt.Fprintf(&b, " (hits %d)", bp.Hits)
+	if bp.Disabled {
+		b.WriteString(" disabled")
+	}
+	return b.String()
+}
+
+// Describes the hit, e.g. breakpoint 2 at 8003: write 4000=FF
+func (h *Hit) String() string {
+	s := fmt.Sprintf("Breakpoint %d at %04X: %s", h.Breakpoint.ID, h.PC, breakNames[h.Breakpoint.Type])
+	switch h.Breakpoint.Type {
+	case BreakRead, BreakWrite, BreakIn, BreakOut:
+		s += fmt.Sprintf(" %04X=%02X", h.Addr, h.Value)
+	case BreakINT, BreakNMI:
+		s += fmt.Sprintf(" %04X", h.Addr)
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package z80
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/z80/memory"
+)
+
+func newBreakpointsZ80() *Z80 {
+	mem := &memory.BasicMemory{Cells: make([]byte, 0x10000)}
+	copy(mem.Cells, []byte{
+		ld_a_n, 0x05, // 0000: LD A,05
+		0x32, 0x00, 0x40, // 0002: LD (4000),A
+		0x3A, 0x00, 0x40, // 0005: LD A,(4000)
+		0xD3, 0xFE, // 0008: OUT (FE),A
+		0xDB, 0x1F, // 000A: IN A,(1F)
+		dec_a,      // 000C: DEC A
+		0x20, 0xFD, // 000D: JR NZ,000C
+		halt, // 000F: HALT
+	})
+	return NewZ80(mem)
+}
+
+func Test_BreakpointPC(t *testing.T) {
+	z80 := newBreakpointsZ80()
+	var hits []*Hit
+	z80.OnBreakpoint = func(hit *Hit) { hits = append(hits, hit) }
+
+	id := z80.AddBreakpoint(&Breakpoint{Type: BreakPC, From: 0x0005})
+	z80.Run(10000)
+	assert.True(t, z80.Stopped())
+	assert.Equal(t, id, z80.Hit().Breakpoint.ID)
+	assert.Equal(t, uint16(0x0005), z80.Reg.PC)
+	assert.Equal(t, []*Hit{z80.Hit()}, hits)
+	assert.Equal(t, "Breakpoint 1 at 0005: pc", z80.Hit().String())
+
+	// Not stopped again when continued
+	z80.Continue()
+	assert.False(t, z80.Stopped())
+	assert.Nil(t, z80.Hit())
+	assert.Equal(t, uint16(0x0010), z80.Reg.PC)
+
+	z80.ClearBreakpoints()
+	assert.Nil(t, z80.breaks)
+
+	// Condition and hit count
+	z80 = newBreakpointsZ80()
+	z80.nextBreakID = 1
+	z80.Reg.PC = 0x000A
+	z80.AddBreakpoint(&Breakpoint{Type: BreakPC, From: 0x000C, Condition: func(z80 *Z80) bool { return z80.Reg.A == 0x10 }})
+	bp := &Breakpoint{Type: BreakPC, From: 0x000D, Count: 3}
+	z80.AddBreakpoint(bp)
+	z80.Run(10000)
+	assert.Equal(t, byte(0xFC), z80.Reg.A)
+	assert.Equal(t, uint16(0x000D), z80.Reg.PC)
+	assert.Equal(t, 3, bp.Hits)
+	z80.Continue()
+	assert.Equal(t, byte(0xFB), z80.Reg.A)
+	z80.RemoveBreakpoint(bp.ID)
+	z80.Continue()
+	assert.Equal(t, byte(0x10), z80.Reg.A)
+	assert.Equal(t, uint16(0x000C), z80.Reg.PC)
+
+	// Disabled
+	z80.Breakpoints()[0].Disabled = true
+	z80.UpdateBreakpoints()
+	assert.Nil(t, z80.breaks)
+	assert.Equal(t, "2: pc 000C (hits 1) disabled", z80.Breakpoint(2).String())
+}
+
+func Test_Watchpoints(t *testing.T) {
+	tests := []struct {
+		bp       Breakpoint
+		pc, addr uint16
+		value    byte
+		stop     uint16
+	}{
+		{Breakpoint{Type: BreakWrite, From: 0x4000}, 0x0002, 0x4000, 0x05, 0x0005},
+		{Breakpoint{Type: BreakRead, From: 0x3FFF, To: 0x4001}, 0x0005, 0x4000, 0x05, 0x0008},
+		{Breakpoint{Type: BreakOut, From: 0x00FE, Mask: 0x00FF}, 0x0008, 0x05FE, 0x05, 0x000A},
+		{Breakpoint{Type: BreakIn, From: 0x001F, Mask: 0x00FF}, 0x000A, 0x051F, 0xFF, 0x000C},
+	}
+
+	for _, test := range tests {
+		z80 := newBreakpointsZ80()
+		bp := test.bp
+		z80.AddBreakpoint(&bp)
+		z80.Run(10000)
+		assert.True(t, z80.Stopped(), bp.String())
+		assert.Equal(t, test.stop, z80.Reg.PC, bp.String())
+		assert.Equal(t, Hit{Breakpoint: &bp, PC: test.pc, Addr: test.addr, Value: test.value}, *z80.Hit())
+	}
+
+	// Read of the instruction operands is not a data read
+	z80 := newBreakpointsZ80()
+	z80.AddBreakpoint(&Breakpoint{Type: BreakRead, From: 0x0000, To: 0x000F})
+	z80.Run(10000)
+	assert.False(t, z80.Stopped())
+}
+
+func Test_BreakpointInterrupt(t *testing.T) {
+	z80 := newBreakpointsZ80()
+	z80.AddBreakpoint(&Breakpoint{Type: BreakINT})
+	z80.AddBreakpoint(&Breakpoint{Type: BreakNMI})
+	z80.Reg.PC, z80.Reg.SP = 0x000C, 0x8000
+
+	// Disabled interrupts are not accepted
+	z80.INT(0xFF)
+	z80.Run(4)
+	assert.False(t, z80.Stopped())
+
+	z80.iff1, z80.im = true, 1
+	z80.INT(0xFF)
+	z80.Run(10000)
+	assert.True(t, z80.Stopped())
+	assert.Equal(t, uint16(0x0038), z80.Reg.PC)
+	assert.Equal(t, "Breakpoint 1 at 000D: int 0038", z80.Hit().String())
+
+	z80.NMI()
+	z80.Continue()
+	assert.Equal(t, uint16(0x0066), z80.Reg.PC)
+	assert.Equal(t, 2, z80.Hit().Breakpoint.ID)
+
+	// IM2 vector fetch is not a data read
+	z80 = newBreakpointsZ80()
+	z80.mem.Write(0x80FF, 0x38)
+	z80.AddBreakpoint(&Breakpoint{Type: BreakRead, From: 0x80FF, To: 0x8100})
+	z80.AddBreakpoint(&Breakpoint{Type: BreakINT})
+	z80.Reg.PC, z80.Reg.SP, z80.Reg.I = 0x000C, 0x8000, 0x80
+	z80.iff1, z80.im = true, 2
+	z80.INT(0xFF)
+	z80.Run(10000)
+	assert.Equal(t, uint16(0x0038), z80.Reg.PC)
+	assert.Equal(t, BreakINT, z80.Hit().Breakpoint.Type)
+}
+
+func Test_BreakpointAfterWatchpoint(t *testing.T) {
+	z80 := newBreakpointsZ80()
+	z80.AddBreakpoint(&Breakpoint{Type: BreakWrite, From: 0x4000})
+	z80.AddBreakpoint(&Breakpoint{Type: BreakPC, From: 0x0005})
+
+	// Write watchpoint stops at the next instruction, where PC breakpoint is
+	z80.Run(10000)
+	assert.Equal(t, uint16(0x0005), z80.Reg.PC)
+	assert.Equal(t, BreakWrite, z80.Hit().Breakpoint.Type)
+	z80.Continue()
+	assert.True(t, z80.Stopped())
+	assert.Equal(t, uint16(0x0005), z80.Reg.PC)
+	assert.Equal(t, BreakPC, z80.Hit().Breakpoint.Type)
+	z80.Continue()
+	assert.False(t, z80.Stopped())
+}
@@ -8,8 +8,12 @@ type CPUState struct {
 	PC, SP             uint16
 	I, R, IM           byte
 	IFF1, IFF2         bool
+	Halt               bool // CPU is halted (waiting for interrupt)
+	EILast             bool // last executed instruction was EI
+	T                  int  // T states executed in the current frame
 }
 
+// Restores the CPU state
 func (z80 *Z80) State(state *CPUState) {
 	z80.Reg.A = byte(state.AF >> 8)
 	z80.Reg.F = byte(state.AF)
@@ -38,4 +42,33 @@ func (z80 *Z80) State(state *CPUState) {
 	z80.im = state.IM
 	z80.iff1 = state.IFF1
 	z80.iff2 = state.IFF2
+	z80.halt = state.Halt
+	z80.eiLast = state.EILast
+	z80.TC.setFrame(state.T)
+}
+
+// Returns the current CPU state
+func (z80 *Z80) GetState() *CPUState {
+	return &CPUState{
+		AF:     uint16(z80.Reg.A)<<8 | uint16(z80.Reg.F),
+		BC:     z80.Reg.BC(),
+		DE:     z80.Reg.DE(),
+		HL:     uint16(z80.Reg.H)<<8 | uint16(z80.Reg.L),
+		AF_:    uint16(z80.Reg.A_)<<8 | uint16(z80.Reg.F_),
+		BC_:    uint16(z80.Reg.B_)<<8 | uint16(z80.Reg.C_),
+		DE_:    uint16(z80.Reg.D_)<<8 | uint16(z80.Reg.E_),
+		HL_:    uint16(z80.Reg.H_)<<8 | uint16(z80.Reg.L_),
+		IX:     z80.Reg.IX(),
+		IY:     z80.Reg.IY(),
+		PC:     z80.Reg.PC,
+		SP:     z80.Reg.SP,
+		I:      z80.Reg.I,
+		R:      z80.Reg.R,
+		IM:     z80.im,
+		IFF1:   z80.iff1,
+		IFF2:   z80.iff2,
+		Halt:   z80.halt,
+		EILast: z80.eiLast,
+		T:      z80.TC.frame(),
+	}
 }
@@ -28,6 +28,8 @@ func Test_State(t *testing.T) {
 		IM:   2,
 		IFF1: true,
 		IFF2: true,
+		Halt: true,
+		T:    1234,
 	}
 
 	z80.State(state)
@@ -59,4 +61,36 @@ func Test_State(t *testing.T) {
 	assert.Equal(t, byte(2), z80.im)
 	assert.Equal(t, true, z80.iff1)
 	assert.Equal(t, true, z80.iff2)
+	assert.Equal(t, true, z80.halt)
+	assert.Equal(t, 1234, z80.TC.Current)
+}
+
+func Test_GetState(t *testing.T) {
+	mem := &memory.BasicMemory{}
+	z80 := NewZ80(mem)
+	state := &CPUState{
+		AF:     0x1234,
+		BC:     0x2345,
+		DE:     0x3456,
+		HL:     0x4567,
+		AF_:    0x5678,
+		BC_:    0x6789,
+		DE_:    0x789A,
+		HL_:    0x89AB,
+		IX:     0x9ABC,
+		IY:     0xABCD,
+		PC:     0xBCDE,
+		SP:     0xCDEF,
+		I:      0x1A,
+		R:      0x2B,
+		IM:     1,
+		IFF1:   true,
+		IFF2:   false,
+		EILast: true,
+		T:      4321,
+	}
+
+	z80.State(state)
+
+	assert.Equal(t, state, z80.GetState())
 }
@@ -16,6 +16,29 @@ type instruction struct {
 // Decode current opcode into mnemonic. This is very basic and simple
 // implementation, just a helper for debugging any issues.
 func Decode(addr uint16, mem memory.Memory) string {
+	inst := lookup(addr, mem)
+
+	var bytes []byte
+	for i := 0; i < inst.size; i++ {
+		bytes = append(bytes, mem.Read(addr+uint16(i)))
+	}
+	s := fmt.Sprintf("%04X: ", addr) + fmtBytes(bytes)
+
+	mnemonic := inst.mnemonic
+	for i, arg := range inst.args {
+		mnemonic = strings.ReplaceAll(mnemonic, fmt.Sprintf("$%v", i+1), fmt.Sprintf("%02X", bytes[arg]))
+	}
+
+	return s + mnemonic
+}
+
+// Returns the size of the instruction in bytes
+func Length(addr uint16, mem memory.Memory) int {
+	return lookup(addr, mem).size
+}
+
+// Finds the instruction at the address
+func lookup(addr uint16, mem memory.Memory) *instruction {
 	opcode := mem.Read(addr)
 	var inst *instruction
 	switch opcode {
@@ -47,18 +70,7 @@ func Decode(addr uint16, mem memory.Memory) string {
 		inst = &instruction{mnemonic: "[INVALID]", size: 2}
 	}
 
-	var bytes []byte
-	for i := 0; i < inst.size; i++ {
-		bytes = append(bytes, mem.Read(addr+uint16(i)))
-	}
-	s := fmt.Sprintf("%04X: ", addr) + fmtBytes(bytes)
-
-	mnemonic := inst.mnemonic
-	for i, arg := range inst.args {
-		mnemonic = strings.ReplaceAll(mnemonic, fmt.Sprintf("$%v", i+1), fmt.Sprintf("%02X", bytes[arg]))
-	}
-
-	return s + mnemonic
+	return inst
 }
 
 func fmtBytes(bytes []byte) string {
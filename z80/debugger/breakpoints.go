@@ -0,0 +1,229 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/voytas/z80-go-zx/z80"
+)
+
+// Breakpoint types by name
+var breakTypes = map[string]int{
+	"pc":    z80.BreakPC,
+	"read":  z80.BreakRead,
+	"write": z80.BreakWrite,
+	"in":    z80.BreakIn,
+	"out":   z80.BreakOut,
+	"int":   z80.BreakINT,
+	"nmi":   z80.BreakNMI,
+}
+
+// Parses the breakpoint in the format: [type] [from[-to][/mask]] [if cond] [after n]
+// where type is pc (default), read, write, in, out, int or nmi, addresses
+// and ports are hex and the hit count is decimal, e.g.
+//
+//	8000 if a==0 && nz
+//	write 4000-5AFF after 10
+//	out FE/FF
+func ParseBreakpoint(spec string) (*z80.Breakpoint, error) {
+	fields := strings.Fields(spec)
+	bp := &z80.Breakpoint{Type: z80.BreakPC}
+	if len(fields) > 0 {
+		if t, ok := breakTypes[strings.ToLower(fields[0])]; ok {
+			bp.Type, fields = t, fields[1:]
+		}
+	}
+
+	if bp.Type != z80.BreakINT && bp.Type != z80.BreakNMI {
+		if len(fields) == 0 {
+			return nil, errors.New("Address expected")
+		}
+		if err := parseRange(fields[0], bp); err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	}
+
+	for len(fields) > 0 {
+		switch strings.ToLower(fields[0]) {
+		case "if":
+			end := len(fields)
+			for i, f := range fields {
+				if strings.EqualFold(f, "after") {
+					end = i
+					break
+				}
+			}
+			expr := strings.Join(fields[1:end], "")
+			cond, err := parseCondition(expr)
+			if err != nil {
+				return nil, err
+			}
+			bp.Condition, bp.Expr = cond, expr
+			fields = fields[end:]
+		case "after":
+			if len(fields) < 2 {
+				return nil, errors.New("Hit count expected")
+			}
+			count, err := strconv.Atoi(fields[1])
+			if err != nil || count <= 0 {
+				return nil, fmt.Errorf("Invalid hit count: %s", fields[1])
+			}
+			bp.Count = count
+			fields = fields[2:]
+		default:
+			return nil, fmt.Errorf("Unexpected: %s", fields[0])
+		}
+	}
+
+	return bp, nil
+}
+
+// Parses the address or port range, e.g. 4000-5AFF or FE/FF
+func parseRange(s string, bp *z80.Breakpoint) error {
+	if parts := strings.SplitN(s, "/", 2); len(parts) == 2 {
+		if bp.Type == z80.BreakPC {
+			return errors.New("Mask is supported by memory and port breakpoints only")
+		}
+		mask, err := parseNumber(parts[1], 0xFFFF)
+		if err != nil {
+			return err
+		}
+		bp.Mask, s = uint16(mask), parts[0]
+	}
+	parts := strings.SplitN(s, "-", 2)
+	from, err := parseNumber(parts[0], 0xFFFF)
+	if err != nil {
+		return err
+	}
+	bp.From = uint16(from)
+	if len(parts) == 2 {
+		if bp.Type == z80.BreakPC {
+			return errors.New("Range is supported by memory and port breakpoints only")
+		}
+		to, err := parseNumber(parts[1], 0xFFFF)
+		if err != nil {
+			return err
+		}
+		if to < from {
+			return fmt.Errorf("Invalid range: %s", s)
+		}
+		bp.To = uint16(to)
+	}
+	return nil
+}
+
+// Comparison operators, two character ones first
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Parses the condition, terms are combined with && and || (&& takes
+// precedence), the term compares the register with hex value, e.g. hl>=4000,
+// or tests the flag, e.g. z, !c or nz
+func parseCondition(expr string) (func(*z80.Z80) bool, error) {
+	var alts []func(*z80.Z80) bool
+	for _, or := range strings.Split(expr, "||") {
+		var all []func(*z80.Z80) bool
+		for _, term := range strings.Split(or, "&&") {
+			cond, err := parseTerm(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, cond)
+		}
+		alts = append(alts, func(cpu *z80.Z80) bool {
+			for _, cond := range all {
+				if !cond(cpu) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return func(cpu *z80.Z80) bool {
+		for _, cond := range alts {
+			if cond(cpu) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Parses the single comparison or flag test
+func parseTerm(term string) (func(*z80.Z80) bool, error) {
+	for _, op := range operators {
+		i := strings.Index(term, op)
+		if i < 0 {
+			continue
+		}
+		// The register name is validated against a blank CPU
+		name := strings.ToUpper(term[:i])
+		if _, ok := register(z80.NewZ80(nil), name); !ok {
+			return nil, fmt.Errorf("Unknown register: %s", term[:i])
+		}
+		value, err := parseNumber(term[i+len(op):], 0xFFFF)
+		if err != nil {
+			return nil, err
+		}
+		return compare(name, op, uint16(value)), nil
+	}
+
+	set := true
+	switch {
+	case strings.HasPrefix(term, "!"):
+		set, term = false, term[1:]
+	case len(term) == 2 && strings.ToLower(term[:1]) == "n":
+		set, term = false, term[1:]
+	}
+	i := strings.Index(flagNames, strings.ToUpper(term))
+	if len(term) != 1 || i < 0 {
+		return nil, fmt.Errorf("Invalid condition: %s", term)
+	}
+	mask := byte(0x80 >> i)
+	return func(cpu *z80.Z80) bool {
+		return (cpu.Reg.F&mask != 0) == set
+	}, nil
+}
+
+// Returns the comparison of the register with the value
+func compare(name, op string, value uint16) func(*z80.Z80) bool {
+	return func(cpu *z80.Z80) bool {
+		r, _ := register(cpu, name)
+		switch op {
+		case "==":
+			return r == value
+		case "!=":
+			return r != value
+		case "<":
+			return r < value
+		case "<=":
+			return r <= value
+		case ">":
+			return r > value
+		}
+		return r >= value
+	}
+}
+
+// Adds the breakpoint, see ParseBreakpoint for the format
+func (d *Debugger) AddBreakpoint(spec string) (*z80.Breakpoint, error) {
+	bp, err := ParseBreakpoint(spec)
+	if err != nil {
+		return nil, err
+	}
+	d.cpu.AddBreakpoint(bp)
+	return bp, nil
+}
+
+// Enables or disables the breakpoint
+func (d *Debugger) EnableBreakpoint(id int, enable bool) error {
+	bp := d.cpu.Breakpoint(id)
+	if bp == nil {
+		return fmt.Errorf("Breakpoint %d not found", id)
+	}
+	bp.Disabled = !enable
+	d.cpu.UpdateBreakpoints()
+	return nil
+}
@@ -0,0 +1,326 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const prompt = "> "
+
+const help = `Commands (numbers and addresses are hex, bank:offset addresses RAM bank):
+  p, pause                 pause the execution
+  c, continue              resume the execution
+  s, step                  execute single instruction
+  n, next                  execute single instruction, CALL and RST until they return
+  o, out                   run until the current subroutine returns
+  g, go addr               run until PC reaches the address
+  r, regs [reg=value ...]  show or set registers, e.g. r hl=4000 a'=ff
+  f, flags [+f|-f ...]     show, set or reset flags, e.g. f +z -c
+  m, mem [addr [size]]     dump memory, e.g. m 8000 40 or m 7:0000
+  e, edit addr byte ...    write memory, e.g. e 4000 ff 00
+  d, dasm [addr [count]]   disassemble, around PC by default
+  b, break [type] addr [if cond] [after n]
+                           add breakpoint, type is pc (default), read, write,
+                           in, out, int or nmi, e.g. b 8000 if a==0 && nz,
+                           b write 4000-5aff, b out fe/ff, b int (n is decimal)
+  bl, breaks               list breakpoints
+  bd, delete id|all        delete breakpoint
+  be, enable id            enable breakpoint
+  bx, disable id           disable breakpoint
+  h, help                  show this help`
+
+// Function sent to the emulation loop
+type request struct {
+	f    func()
+	done chan struct{}
+}
+
+// Executes the console command and returns its output
+func (d *Debugger) Exec(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	cmd, args := strings.ToLower(fields[0]), fields[1:]
+	switch cmd {
+	case "h", "help", "?":
+		return help, nil
+	case "p", "pause":
+		if !d.paused {
+			d.Pause()
+		}
+		return "", nil
+	case "c", "continue":
+		d.Resume()
+		return "", nil
+	case "s", "step", "n", "next", "o", "out":
+		if !d.paused {
+			return "", errors.New("Execution is not paused")
+		}
+		switch cmd[0] {
+		case 's':
+			d.Step()
+		case 'n':
+			d.StepOver()
+		case 'o':
+			d.StepOut()
+		}
+		return "", nil
+	case "g", "go":
+		if len(args) != 1 {
+			return "", errors.New("Address expected")
+		}
+		addr, err := parseNumber(args[0], 0xFFFF)
+		if err != nil {
+			return "", err
+		}
+		d.RunTo(uint16(addr))
+		return "", nil
+	case "r", "regs":
+		for _, arg := range args {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 {
+				return "", fmt.Errorf("Invalid register assignment: %s", arg)
+			}
+			value, err := parseNumber(kv[1], 0xFFFF)
+			if err != nil {
+				return "", err
+			}
+			if err := d.SetRegister(kv[0], uint16(value)); err != nil {
+				return "", err
+			}
+		}
+		return d.Registers(), nil
+	case "f", "flags":
+		for _, arg := range args {
+			if len(arg) != 2 || (arg[0] != '+' && arg[0] != '-') {
+				return "", fmt.Errorf("Invalid flag: %s", arg)
+			}
+			if err := d.SetFlag(arg[1:], arg[0] == '+'); err != nil {
+				return "", err
+			}
+		}
+		return "Flags: " + formatFlags(d.cpu.Reg.F), nil
+	case "m", "mem":
+		return d.dump(args)
+	case "e", "edit":
+		return "", d.edit(args)
+	case "d", "dasm":
+		return d.dasm(args)
+	case "b", "break":
+		bp, err := d.AddBreakpoint(strings.Join(args, " "))
+		if err != nil {
+			return "", err
+		}
+		return bp.String(), nil
+	case "bl", "breaks":
+		var lines []string
+		for _, bp := range d.cpu.Breakpoints() {
+			lines = append(lines, bp.String())
+		}
+		if len(lines) == 0 {
+			return "No breakpoints", nil
+		}
+		return strings.Join(lines, "\n"), nil
+	case "bd", "delete", "be", "enable", "bx", "disable":
+		if len(args) != 1 {
+			return "", errors.New("Breakpoint ID expected")
+		}
+		if cmd == "bd" || cmd == "delete" {
+			if strings.EqualFold(args[0], "all") {
+				d.cpu.ClearBreakpoints()
+				return "", nil
+			}
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Invalid breakpoint ID: %s", args[0])
+		}
+		switch cmd {
+		case "bd", "delete":
+			if !d.cpu.RemoveBreakpoint(id) {
+				return "", fmt.Errorf("Breakpoint %d not found", id)
+			}
+			return "", nil
+		case "be", "enable":
+			return "", d.EnableBreakpoint(id, true)
+		}
+		return "", d.EnableBreakpoint(id, false)
+	}
+
+	return "", fmt.Errorf("Unknown command: %s (h for help)", fields[0])
+}
+
+// Dumps the memory as hex and ASCII, 16 bytes per line
+func (d *Debugger) dump(args []string) (string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", errors.New("Address and optional size expected")
+	}
+	bank, addr, err := parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+	size := 0x80
+	if len(args) == 2 {
+		if size, err = parseNumber(args[1], 0x10000); err != nil {
+			return "", err
+		}
+	}
+	data, err := d.ReadMemory(bank, addr, size)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for i := 0; i < len(data); i += 16 {
+		row := data[i:]
+		if len(row) > 16 {
+			row = row[:16]
+		}
+		var line strings.Builder
+		if bank >= 0 {
+			fmt.Fprintf(&line, "%d:%04X ", bank, (addr+uint16(i))&0x3FFF)
+		} else {
+			fmt.Fprintf(&line, "%04X ", addr+uint16(i))
+		}
+		for _, b := range row {
+			fmt.Fprintf(&line, " %02X", b)
+		}
+		line.WriteString(strings.Repeat("   ", 16-len(row)) + "  ")
+		for _, b := range row {
+			if b < 0x20 || b > 0x7E {
+				b = '.'
+			}
+			line.WriteByte(b)
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Writes the bytes to the memory
+func (d *Debugger) edit(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Address and bytes expected")
+	}
+	bank, addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, arg := range args[1:] {
+		b, err := parseNumber(arg, 0xFF)
+		if err != nil {
+			return err
+		}
+		data = append(data, byte(b))
+	}
+	return d.WriteMemory(bank, addr, data)
+}
+
+// Disassembles the instructions at the address or around PC
+func (d *Debugger) dasm(args []string) (string, error) {
+	if len(args) == 0 {
+		return strings.Join(d.DisassemblePC(4, 8), "\n"), nil
+	}
+	addr, err := parseNumber(args[0], 0xFFFF)
+	if err != nil {
+		return "", err
+	}
+	count := 0x10
+	if len(args) > 1 {
+		if count, err = parseNumber(args[1], 0xFFFF); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(d.Disassemble(uint16(addr), count), "\n"), nil
+}
+
+// Parses the hex number with optional 0x or $ prefix
+func parseNumber(s string, max int) (int, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "$")
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || int(n) > max {
+		return 0, fmt.Errorf("Invalid number: %s", s)
+	}
+	return int(n), nil
+}
+
+// Parses the address, bank:offset addresses the RAM bank (bank is -1 otherwise)
+func parseAddress(s string) (int, uint16, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 1 {
+		addr, err := parseNumber(s, 0xFFFF)
+		return -1, uint16(addr), err
+	}
+	bank, err := strconv.Atoi(parts[0])
+	if err != nil || bank < 0 {
+		return 0, 0, fmt.Errorf("Invalid bank: %s", parts[0])
+	}
+	offset, err := parseNumber(parts[1], 0x3FFF)
+	return bank, uint16(offset), err
+}
+
+// Executes the commands received by Serve (or functions passed to Do), it
+// has to be called regularly from the emulation loop (e.g. every frame), so
+// the commands do not race with the emulation
+func (d *Debugger) Poll() {
+	for {
+		select {
+		case r := <-d.requests:
+			r.f()
+			close(r.done)
+		default:
+			return
+		}
+	}
+}
+
+// Executes the function by Poll in the emulation loop and waits until it is
+// done, the function can access the CPU, memory and the debugger
+func (d *Debugger) Do(f func()) {
+	r := &request{f: f, done: make(chan struct{})}
+	d.requests <- r
+	<-r.done
+}
+
+// Reads the commands from the console (e.g. stdin) until it is closed and
+// writes their output, the commands are executed by Poll
+func (d *Debugger) Serve(r io.Reader, w io.Writer) {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	fmt.Fprint(w, help+"\n"+prompt)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			var out string
+			d.Do(func() {
+				var err error
+				if out, err = d.Exec(line); err != nil {
+					out = "Error: " + err.Error()
+				}
+			})
+			if out != "" {
+				fmt.Fprintln(w, out)
+			}
+		case event := <-d.events:
+			fmt.Fprintln(w, "\r"+event)
+		}
+		fmt.Fprint(w, prompt)
+	}
+}
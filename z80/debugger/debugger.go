@@ -2,24 +2,478 @@ package debugger
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/voytas/z80-go-zx/z80"
 	"github.com/voytas/z80-go-zx/z80/dasm"
 	"github.com/voytas/z80-go-zx/z80/memory"
 )
 
-var lastOpcode byte = 0xFF
-var lastPC uint16 = 0xFFFF
+// Execution modes
+const (
+	modeRun     = iota // run until paused
+	modeStep           // stop before the next instruction
+	modeStepOut        // stop after return from the current subroutine
+	modeRunTo          // stop at the target address (step over uses the stack pointer too)
+)
+
+// Memory that can be read and written without side effects (e.g. contention),
+// if not implemented Read and Write are used
+type Peeker interface {
+	Peek(addr uint16) byte
+	Poke(addr uint16, value byte)
+}
+
+// Memory with 16k RAM banks that are paged in and out (128k)
+type BankedMemory interface {
+	Banks() int            // number of RAM banks, 0 if there is no paging
+	SlotBank(slot int) int // RAM bank paged in the 16k slot, -1 for ROM
+	PeekBank(bank int, offset uint16) byte
+	PokeBank(bank int, offset uint16, value byte)
+}
+
+// Debugger controls the execution of the CPU, it is installed as the CPU
+// Break hook. It is not safe for concurrent use, commands from the console
+// are executed by Poll called from the emulation loop.
+type Debugger struct {
+	cpu      *z80.Z80
+	mem      memory.Memory
+	paused   bool
+	resumed  bool          // instruction at PC is executed before checking the stop conditions
+	mode     int           // execution mode
+	target   uint16        // address to stop at (run to, step over)
+	sp       uint16        // stack pointer when stepping over or out started
+	checkSP  bool          // stop at the target only when the stack is back at sp
+	ret      bool          // last executed instruction was a return
+	requests chan *request // commands from the console and Do
+	events   chan string   // the execution has stopped, reported to the console
+}
+
+// Creates a new debugger and installs it as the CPU Break hook
+func New(cpu *z80.Z80, mem memory.Memory) *Debugger {
+	d := &Debugger{cpu: cpu, mem: mem, requests: make(chan *request), events: make(chan string, 16)}
+	cpu.Break = d.check
+	cpu.OnBreakpoint = d.breakpoint
+	return d
+}
+
+// Returns the CPU controlled by the debugger
+func (d *Debugger) CPU() *z80.Z80 {
+	return d.cpu
+}
+
+// Checks whether the execution is paused
+func (d *Debugger) Paused() bool {
+	return d.paused
+}
 
-// Very basic console output for debugging the opcodes
-func Debug(prefix byte, PC uint16, mem memory.Memory) {
-	opcode := mem.Read(PC)
-	if lastOpcode == opcode && lastPC == PC {
+// Pauses the execution before the next instruction
+func (d *Debugger) Pause() {
+	d.mode = modeStep
+	d.resumed = false
+	d.paused = true
+	d.stopped("")
+}
+
+// Resumes the execution
+func (d *Debugger) Resume() {
+	d.resume(modeRun)
+}
+
+// Executes a single instruction
+func (d *Debugger) Step() {
+	d.resume(modeStep)
+}
+
+// Executes a single instruction, CALL or RST is executed until it returns.
+// DD and FD prefixes don't change the call, so they are skipped.
+func (d *Debugger) StepOver() {
+	pc := d.cpu.Reg.PC
+	addr := pc
+	for op := d.peek(addr); (op == 0xDD || op == 0xFD) && addr-pc < 0xFF; op = d.peek(addr) {
+		addr++
+	}
+	if !isCall(d.peek(addr)) {
+		d.Step()
 		return
 	}
-	lastOpcode = opcode
-	lastPC = PC
+	d.target, d.sp, d.checkSP = addr+uint16(dasm.Length(addr, d.reader())), d.cpu.Reg.SP, true
+	d.resume(modeRunTo)
+}
+
+// Runs until the current subroutine returns
+func (d *Debugger) StepOut() {
+	d.sp = d.cpu.Reg.SP
+	d.resume(modeStepOut)
+}
+
+// Runs until PC reaches the address
+func (d *Debugger) RunTo(addr uint16) {
+	d.target, d.checkSP = addr, false
+	d.resume(modeRunTo)
+}
+
+func (d *Debugger) resume(mode int) {
+	d.mode = mode
+	d.paused = false
+	d.resumed = true
+	d.ret = false
+}
+
+// Reports the instruction the execution stopped at (and the reason) to the
+// console, the event is dropped when there is no console reading them
+func (d *Debugger) stopped(reason string) {
+	event := "Stopped at " + dasm.Decode(d.cpu.Reg.PC, d.reader())
+	if reason != "" {
+		event = reason + "\n" + event
+	}
+	select {
+	case d.events <- event:
+	default:
+	}
+}
+
+// Called by the CPU when the breakpoint is hit, the execution is paused
+func (d *Debugger) breakpoint(hit *z80.Hit) {
+	d.mode = modeStep
+	d.resumed = false
+	d.paused = true
+	d.stopped(hit.String())
+}
+
+// Called before each instruction, returns true to stop the CPU
+func (d *Debugger) check() bool {
+	if d.paused {
+		return true
+	}
+
+	pc := d.cpu.Reg.PC
+	if d.resumed {
+		d.resumed = false
+	} else if d.stop(pc) {
+		d.paused = true
+		d.stopped("")
+		return true
+	}
+	if d.mode == modeStepOut {
+		d.ret = isReturn(d.peek(pc), d.peek(pc+1))
+	}
+	return false
+}
+
+// Checks whether the execution should stop at the address
+func (d *Debugger) stop(pc uint16) bool {
+	switch d.mode {
+	case modeStep:
+		return true
+	case modeStepOut:
+		return d.ret && d.cpu.Reg.SP > d.sp
+	case modeRunTo:
+		return pc == d.target && (!d.checkSP || d.cpu.Reg.SP >= d.sp)
+	}
+	return false
+}
 
-	if prefix == 0 || opcode == 0xDD || opcode == 0xFD {
-		fmt.Println(dasm.Decode(PC, mem))
+// Reads the memory without side effects
+func (d *Debugger) peek(addr uint16) byte {
+	if p, ok := d.mem.(Peeker); ok {
+		return p.Peek(addr)
+	}
+	return d.mem.Read(addr)
+}
+
+// Writes the memory without side effects
+func (d *Debugger) poke(addr uint16, value byte) {
+	if p, ok := d.mem.(Peeker); ok {
+		p.Poke(addr, value)
+		return
 	}
+	d.mem.Write(addr, value)
+}
+
+// Returns the memory used by the disassembler
+func (d *Debugger) reader() memory.Memory {
+	return reader{d}
+}
+
+type reader struct{ d *Debugger }
+
+func (r reader) Read(addr uint16) byte         { return r.d.peek(addr) }
+func (r reader) Write(addr uint16, value byte) { r.d.poke(addr, value) }
+
+// Returns the bank aware memory, nil if there is no paging
+func (d *Debugger) banked() BankedMemory {
+	if b, ok := d.mem.(BankedMemory); ok && b.Banks() > 0 {
+		return b
+	}
+	return nil
+}
+
+// Disassembles count instructions from the address
+func (d *Debugger) Disassemble(addr uint16, count int) []string {
+	var lines []string
+	for i := 0; i < count; i++ {
+		lines = append(lines, dasm.Decode(addr, d.reader()))
+		addr += uint16(dasm.Length(addr, d.reader()))
+	}
+	return lines
+}
+
+// Disassembles the instructions around PC, the instructions before PC are
+// found by decoding from earlier addresses until one of them lands on PC
+func (d *Debugger) DisassemblePC(before, after int) []string {
+	pc := d.cpu.Reg.PC
+	start := pc
+	for offset := uint16(4 * before); offset > 0; offset-- {
+		var addrs []uint16
+		addr := pc - offset
+		for addr != pc && pc-addr <= offset {
+			addrs = append(addrs, addr)
+			addr += uint16(dasm.Length(addr, d.reader()))
+		}
+		if addr == pc && len(addrs) >= before {
+			start = addrs[len(addrs)-before]
+			break
+		}
+	}
+
+	var lines []string
+	for addr := start; addr != pc; addr += uint16(dasm.Length(addr, d.reader())) {
+		lines = append(lines, "  "+dasm.Decode(addr, d.reader()))
+	}
+	for i, line := range d.Disassemble(pc, after+1) {
+		if i == 0 {
+			lines = append(lines, "> "+line)
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return lines
+}
+
+// Formats the registers, flags and the paged banks
+func (d *Debugger) Registers() string {
+	s := d.cpu.GetState()
+	var b strings.Builder
+	fmt.Fprintf(&b, "AF=%04X BC=%04X DE=%04X HL=%04X IX=%04X IY=%04X SP=%04X PC=%04X\n",
+		s.AF, s.BC, s.DE, s.HL, s.IX, s.IY, s.SP, s.PC)
+	fmt.Fprintf(&b, "AF'=%04X BC'=%04X DE'=%04X HL'=%04X I=%02X R=%02X IM=%d IFF1=%d IFF2=%d T=%d\n",
+		s.AF_, s.BC_, s.DE_, s.HL_, s.I, s.R, s.IM, btoi(s.IFF1), btoi(s.IFF2), s.T)
+	fmt.Fprintf(&b, "Flags: %s", formatFlags(d.cpu.Reg.F))
+	if m := d.banked(); m != nil {
+		b.WriteString("  Banks:")
+		for slot := 0; slot < 4; slot++ {
+			if bank := m.SlotBank(slot); bank >= 0 {
+				fmt.Fprintf(&b, " %04X=%d", slot<<14, bank)
+			} else {
+				fmt.Fprintf(&b, " %04X=ROM", slot<<14)
+			}
+		}
+	}
+	return b.String()
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Flag names from bit 7 to 0
+const flagNames = "SZ5H3PNC"
+
+// Formats the flags, reset flags are shown as -, e.g. -Z---P-C
+func formatFlags(f byte) string {
+	s := []byte(flagNames)
+	for i := range s {
+		if f&(0x80>>i) == 0 {
+			s[i] = '-'
+		}
+	}
+	return string(s)
+}
+
+// Sets or resets the flag, e.g. Z or C
+func (d *Debugger) SetFlag(name string, set bool) error {
+	i := strings.Index(flagNames, strings.ToUpper(name))
+	if len(name) != 1 || i < 0 {
+		return fmt.Errorf("Unknown flag: %s", name)
+	}
+	if set {
+		d.cpu.Reg.F |= 0x80 >> i
+	} else {
+		d.cpu.Reg.F &^= 0x80 >> i
+	}
+	return nil
+}
+
+// Sets the register value, 8 or 16 bit register name is case insensitive,
+// shadow registers use ' suffix, e.g. HL'
+func (d *Debugger) SetRegister(name string, value uint16) error {
+	name = strings.ToUpper(name)
+	if hi, lo := registerRef(d.cpu, name); hi != nil {
+		*hi, *lo = byte(value>>8), byte(value)
+		return nil
+	} else if lo != nil {
+		if value > 0xFF {
+			return fmt.Errorf("Value too big for %s: %X", name, value)
+		}
+		*lo = byte(value)
+		return nil
+	}
+	switch name {
+	case "SP":
+		d.cpu.Reg.SP = value
+	case "PC":
+		d.cpu.Reg.PC = value
+	default:
+		return fmt.Errorf("Unknown register: %s", name)
+	}
+	return nil
+}
+
+// Returns the register value, the name is upper case as in SetRegister. It
+// is called by every conditional breakpoint check, so it doesn't allocate.
+func register(cpu *z80.Z80, name string) (uint16, bool) {
+	if hi, lo := registerRef(cpu, name); hi != nil {
+		return uint16(*hi)<<8 | uint16(*lo), true
+	} else if lo != nil {
+		return uint16(*lo), true
+	}
+	switch name {
+	case "SP":
+		return cpu.Reg.SP, true
+	case "PC":
+		return cpu.Reg.PC, true
+	}
+	return 0, false
+}
+
+// Returns the register pair (high and low byte) or 8 bit register (low byte
+// only) by name, both are nil if the name is not one of them
+func registerRef(cpu *z80.Z80, name string) (hi, lo *byte) {
+	r := cpu.Reg
+	switch name {
+	case "AF":
+		return &r.A, &r.F
+	case "BC":
+		return &r.B, &r.C
+	case "DE":
+		return &r.D, &r.E
+	case "HL":
+		return &r.H, &r.L
+	case "AF'":
+		return &r.A_, &r.F_
+	case "BC'":
+		return &r.B_, &r.C_
+	case "DE'":
+		return &r.D_, &r.E_
+	case "HL'":
+		return &r.H_, &r.L_
+	case "IX":
+		return &r.IXH, &r.IXL
+	case "IY":
+		return &r.IYH, &r.IYL
+	case "A":
+		return nil, &r.A
+	case "F":
+		return nil, &r.F
+	case "B":
+		return nil, &r.B
+	case "C":
+		return nil, &r.C
+	case "D":
+		return nil, &r.D
+	case "E":
+		return nil, &r.E
+	case "H":
+		return nil, &r.H
+	case "L":
+		return nil, &r.L
+	case "A'":
+		return nil, &r.A_
+	case "F'":
+		return nil, &r.F_
+	case "B'":
+		return nil, &r.B_
+	case "C'":
+		return nil, &r.C_
+	case "D'":
+		return nil, &r.D_
+	case "E'":
+		return nil, &r.E_
+	case "H'":
+		return nil, &r.H_
+	case "L'":
+		return nil, &r.L_
+	case "IXH":
+		return nil, &r.IXH
+	case "IXL":
+		return nil, &r.IXL
+	case "IYH":
+		return nil, &r.IYH
+	case "IYL":
+		return nil, &r.IYL
+	case "I":
+		return nil, &r.I
+	case "R":
+		return nil, &r.R
+	}
+	return nil, nil
+}
+
+// Reads the memory, bank -1 reads the address space, otherwise the RAM bank
+func (d *Debugger) ReadMemory(bank int, addr uint16, size int) ([]byte, error) {
+	m, err := d.bank(bank)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	for i := range data {
+		if m != nil {
+			data[i] = m.PeekBank(bank, (addr+uint16(i))&0x3FFF)
+		} else {
+			data[i] = d.peek(addr + uint16(i))
+		}
+	}
+	return data, nil
+}
+
+// Writes the memory, bank -1 writes the address space, otherwise the RAM bank
+func (d *Debugger) WriteMemory(bank int, addr uint16, data []byte) error {
+	m, err := d.bank(bank)
+	if err != nil {
+		return err
+	}
+	for i, b := range data {
+		if m != nil {
+			m.PokeBank(bank, (addr+uint16(i))&0x3FFF, b)
+		} else {
+			d.poke(addr+uint16(i), b)
+		}
+	}
+	return nil
+}
+
+// Validates the bank number, returns nil memory for the address space
+func (d *Debugger) bank(bank int) (BankedMemory, error) {
+	if bank < 0 {
+		return nil, nil
+	}
+	m := d.banked()
+	if m == nil || bank >= m.Banks() {
+		return nil, fmt.Errorf("Bank %d not available", bank)
+	}
+	return m, nil
+}
+
+// Checks whether the opcode is CALL, conditional CALL or RST
+func isCall(opcode byte) bool {
+	return opcode == 0xCD || opcode&0xC7 == 0xC4 || opcode&0xC7 == 0xC7
+}
+
+// Checks whether the instruction is RET, conditional RET, RETI or RETN
+func isReturn(opcode, next byte) bool {
+	return opcode == 0xC9 || opcode&0xC7 == 0xC0 || opcode == 0xED && next&0xC7 == 0x45
 }
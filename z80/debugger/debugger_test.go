@@ -0,0 +1,281 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/z80"
+	"github.com/voytas/z80-go-zx/z80/memory"
+)
+
+func newTestDebugger() (*Debugger, *z80.Z80, *memory.BasicMemory) {
+	mem := &memory.BasicMemory{Cells: make([]byte, 0x10000)}
+	copy(mem.Cells, []byte{
+		0x31, 0x00, 0x90, // 0000: LD SP,9000
+		0xCD, 0x10, 0x00, // 0003: CALL 0010
+		0x3C,       // 0006: INC A
+		0x18, 0xFE, // 0007: JR 0007
+	})
+	copy(mem.Cells[0x10:], []byte{
+		0x06, 0x05, // 0010: LD B,05
+		0xCD, 0x20, 0x00, // 0012: CALL 0020
+		0xC9, // 0015: RET
+	})
+	copy(mem.Cells[0x20:], []byte{
+		0x04, // 0020: INC B
+		0xC9, // 0021: RET
+	})
+	cpu := z80.NewZ80(mem)
+	return New(cpu, mem), cpu, mem
+}
+
+func Test_Stepping(t *testing.T) {
+	d, cpu, _ := newTestDebugger()
+
+	d.Pause()
+	cpu.Run(1000)
+	assert.True(t, cpu.Stopped())
+	assert.Equal(t, uint16(0x0000), cpu.Reg.PC)
+	assert.True(t, strings.HasPrefix(<-d.events, "Stopped at 0000: 31 00 90"))
+
+	d.Step()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0003), cpu.Reg.PC)
+
+	// CALL is executed until it returns
+	d.StepOver()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0006), cpu.Reg.PC)
+	assert.Equal(t, byte(0x06), cpu.Reg.B)
+
+	// Step into the subroutine and out of it, the nested call returns
+	// to the same stack level
+	assert.Nil(t, d.SetRegister("pc", 0x0003))
+	d.Step()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0010), cpu.Reg.PC)
+	d.Step()
+	cpu.Continue()
+	d.StepOver()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0015), cpu.Reg.PC)
+	assert.Nil(t, d.SetRegister("pc", 0x0010))
+	d.StepOut()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0006), cpu.Reg.PC)
+	assert.Equal(t, uint16(0x9000), cpu.Reg.SP)
+
+	d.RunTo(0x0007)
+	cpu.Continue()
+	assert.True(t, cpu.Stopped())
+	assert.Equal(t, uint16(0x0007), cpu.Reg.PC)
+
+	d.Resume()
+	cpu.Continue()
+	assert.False(t, cpu.Stopped())
+	assert.False(t, d.Paused())
+}
+
+func Test_StepOverPrefixed(t *testing.T) {
+	d, cpu, mem := newTestDebugger()
+	copy(mem.Cells[0x30:], []byte{
+		0xDD, 0xCD, 0x20, 0x00, // 0030: CALL 0020 (DD prefix ignored)
+		0xFD, 0xDD, 0xC4, 0x20, 0x00, // 0034: CALL NZ,0020 (both prefixes ignored)
+		0x18, 0xFE, // 0039: JR 0039
+	})
+
+	d.Pause()
+	cpu.Run(1000)
+	assert.Nil(t, d.SetRegister("sp", 0x9000))
+	assert.Nil(t, d.SetRegister("pc", 0x0030))
+	d.StepOver()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0034), cpu.Reg.PC)
+	assert.Equal(t, byte(0x01), cpu.Reg.B)
+	d.StepOver()
+	cpu.Continue()
+	assert.Equal(t, uint16(0x0039), cpu.Reg.PC)
+	assert.Equal(t, byte(0x02), cpu.Reg.B)
+	assert.Equal(t, uint16(0x9000), cpu.Reg.SP)
+}
+
+func Test_Commands(t *testing.T) {
+	d, cpu, mem := newTestDebugger()
+
+	_, err := d.Exec("s")
+	assert.NotNil(t, err)
+	_, err = d.Exec("unknown")
+	assert.NotNil(t, err)
+
+	out, err := d.Exec("r hl=4000 a'=ff f=0 pc=3")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "HL=4000")
+	assert.Contains(t, out, "AF'=FF")
+	assert.Contains(t, out, "PC=0003")
+	_, err = d.Exec("r a=100")
+	assert.NotNil(t, err)
+	_, err = d.Exec("r q=1")
+	assert.NotNil(t, err)
+
+	out, err = d.Exec("f +z +c -c +s")
+	assert.Nil(t, err)
+	assert.Equal(t, "Flags: SZ------", out)
+
+	_, err = d.Exec("e 8000 aa $bb 0xcc")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xAA, 0xBB, 0xCC}, mem.Cells[0x8000:0x8003])
+	out, err = d.Exec("m 7ffc 8")
+	assert.Nil(t, err)
+	assert.Equal(t, "7FFC  00 00 00 00 AA BB CC 00"+strings.Repeat("   ", 8)+"  ........", out)
+
+	// No banks without 128k memory
+	_, err = d.Exec("m 1:0000")
+	assert.NotNil(t, err)
+
+	out, err = d.Exec("d 10 3")
+	assert.Nil(t, err)
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.True(t, strings.HasPrefix(lines[2], "0015: C9"))
+
+	out, err = d.Exec("d")
+	assert.Nil(t, err)
+	lines = strings.Split(out, "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "  "))
+	assert.True(t, strings.Contains(out, "> 0003: CD 10 00"))
+
+	_, err = d.Exec("p")
+	assert.Nil(t, err)
+	assert.True(t, d.Paused())
+	_, err = d.Exec("g 12")
+	assert.Nil(t, err)
+	cpu.Run(1000)
+	assert.Equal(t, uint16(0x0012), cpu.Reg.PC)
+}
+
+func Test_DisassemblePC(t *testing.T) {
+	d, cpu, _ := newTestDebugger()
+	cpu.Reg.PC = 0x0006
+	lines := d.DisassemblePC(2, 1)
+	assert.Equal(t, 4, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "  0000: 31 00 90"))
+	assert.True(t, strings.HasPrefix(lines[1], "  0003: CD 10 00"))
+	assert.True(t, strings.HasPrefix(lines[2], "> 0006: 3C"))
+	assert.True(t, strings.HasPrefix(lines[3], "  0007: 18 FE"))
+}
+
+func Test_Serve(t *testing.T) {
+	d, cpu, _ := newTestDebugger()
+	in, out := strings.NewReader("r pc=10\nx\n"), &bytes.Buffer{}
+
+	done := make(chan bool)
+	go func() {
+		d.Serve(in, out)
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			d.Poll()
+		}
+	}
+
+	assert.Equal(t, uint16(0x0010), cpu.Reg.PC)
+	assert.Contains(t, out.String(), "PC=0010")
+	assert.Contains(t, out.String(), "Error: Unknown command: x")
+}
+
+func Test_ParseBreakpoint(t *testing.T) {
+	tests := []struct {
+		spec, desc string
+	}{
+		{"8000", "0: pc 8000 (hits 0)"},
+		{"pc 8000 if a == 0 && nz after 3", "0: pc 8000 if a==0&&nz after 3 (hits 0)"},
+		{"write 4000-5AFF", "0: write 4000-5AFF (hits 0)"},
+		{"OUT fe/ff if hl>=4000 || !c", "0: out 00FE/00FF if hl>=4000||!c (hits 0)"},
+		{"int", "0: int (hits 0)"},
+	}
+	for _, test := range tests {
+		bp, err := ParseBreakpoint(test.spec)
+		assert.Nil(t, err, test.spec)
+		assert.Equal(t, test.desc, bp.String())
+	}
+
+	for _, spec := range []string{"", "read", "8000-8001", "8000/FF", "write 5000-4000",
+		"8000 if q==1", "8000 if a=1", "8000 if x", "8000 after 0", "8000 unless"} {
+		_, err := ParseBreakpoint(spec)
+		assert.NotNil(t, err, spec)
+	}
+
+	bp, _ := ParseBreakpoint("0 if a==10 && nz || ix<8000 && c")
+	cpu := z80.NewZ80(nil)
+	cpu.Reg.A = 0x10
+	assert.True(t, bp.Condition(cpu))
+	cpu.Reg.F = 0x40
+	assert.False(t, bp.Condition(cpu))
+	cpu.Reg.F = 0x01
+	assert.True(t, bp.Condition(cpu))
+	cpu.Reg.IXH = 0x80
+	assert.True(t, bp.Condition(cpu))
+	cpu.Reg.A = 0
+	assert.False(t, bp.Condition(cpu))
+
+	// Condition is checked before each instruction, so it must not allocate
+	bp, _ = ParseBreakpoint("0 if hl'>=4000 && iyl!=0 || sp<8000")
+	assert.Zero(t, testing.AllocsPerRun(100, func() { bp.Condition(cpu) }))
+}
+
+func Test_BreakpointCommands(t *testing.T) {
+	d, cpu, _ := newTestDebugger()
+
+	out, err := d.Exec("b 20 if b==5")
+	assert.Nil(t, err)
+	assert.Equal(t, "1: pc 0020 if b==5 (hits 0)", out)
+	_, err = d.Exec("b write 8ffe-8fff")
+	assert.Nil(t, err)
+	_, err = d.Exec("bx 2")
+	assert.Nil(t, err)
+
+	// Conditional breakpoint in the nested call pauses the execution
+	cpu.Run(1000)
+	assert.True(t, cpu.Stopped())
+	assert.True(t, d.Paused())
+	assert.Equal(t, uint16(0x0020), cpu.Reg.PC)
+	assert.True(t, strings.HasPrefix(<-d.events, "Breakpoint 1 at 0020: pc\nStopped at 0020: 04"))
+
+	// Stays paused until resumed, then the breakpoint is not hit again
+	cpu.Continue()
+	assert.True(t, cpu.Stopped())
+	d.Resume()
+	cpu.Continue()
+	assert.False(t, cpu.Stopped())
+	assert.Equal(t, uint16(0x0007), cpu.Reg.PC)
+
+	out, err = d.Exec("bl")
+	assert.Nil(t, err)
+	assert.Equal(t, "1: pc 0020 if b==5 (hits 1)\n2: write 8FFE-8FFF (hits 0) disabled", out)
+
+	// Return address pushed by the CALL
+	_, err = d.Exec("be 2")
+	assert.Nil(t, err)
+	assert.Nil(t, d.SetRegister("pc", 0x0000))
+	cpu.Run(1000)
+	assert.True(t, cpu.Stopped())
+	assert.Equal(t, uint16(0x0010), cpu.Reg.PC)
+	assert.Equal(t, "Breakpoint 2 at 0003: write 8FFF=00", cpu.Hit().String())
+
+	_, err = d.Exec("bd 1")
+	assert.Nil(t, err)
+	_, err = d.Exec("bd 1")
+	assert.NotNil(t, err)
+	_, err = d.Exec("be 5")
+	assert.NotNil(t, err)
+	_, err = d.Exec("bd all")
+	assert.Nil(t, err)
+	out, _ = d.Exec("bl")
+	assert.Equal(t, "No breakpoints", out)
+}
@@ -0,0 +1,493 @@
+// Package gdb implements GDB Remote Serial Protocol server, so gdb compatible
+// front-ends (e.g. z88dk or sdcc tooling) can debug the program running on
+// the emulated Z80.
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voytas/z80-go-zx/z80"
+	"github.com/voytas/z80-go-zx/z80/debugger"
+)
+
+// Registers in the order used by gdb for Z80, each is 16 bit little endian
+var registers = []string{"AF", "BC", "DE", "HL", "SP", "PC", "IX", "IY", "AF'", "BC'", "DE'", "HL'", "IR"}
+
+// How often the running CPU is checked whether it has stopped
+const pollInterval = 10 * time.Millisecond
+
+// Byte sent by the client to interrupt the execution (Ctrl-C)
+const interrupt = "\x03"
+
+// Server accepts a single client connection at a time, the execution is
+// controlled by the debugger, so the commands are executed by its Poll in
+// the emulation loop
+type Server struct {
+	d        *debugger.Debugger
+	cpu      *z80.Z80
+	listener net.Listener
+	mu       sync.Mutex
+	conn     net.Conn // current client connection
+}
+
+// Client connection state
+type session struct {
+	s           *Server
+	w           *bufio.Writer
+	noAck       bool             // acknowledgments disabled (QStartNoAckMode)
+	running     bool             // continue or step sent, stop reply is pending
+	interrupted bool             // stopped by the client (Ctrl-C)
+	breaks      map[string][]int // CPU breakpoint IDs by Z packet type and address
+}
+
+// Starts listening on the address, e.g. localhost:1234
+func Listen(addr string, d *debugger.Debugger) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{d: d, cpu: d.CPU(), listener: listener}, nil
+}
+
+// Returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Accepts the client connections until the server is closed
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.conn = conn
+		s.mu.Unlock()
+		s.serveConn(conn)
+		conn.Close()
+	}
+}
+
+// Stops listening and closes the client connection
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+	return s.listener.Close()
+}
+
+// Handles the client connection, the execution is paused once the client is
+// attached and resumed when it detaches or disconnects
+func (s *Server) serveConn(conn net.Conn) {
+	c := &session{s: s, w: bufio.NewWriter(conn), breaks: map[string][]int{}}
+	s.d.Do(func() {
+		if !s.d.Paused() {
+			s.d.Pause()
+		}
+	})
+	defer c.detach()
+
+	packets, done := make(chan string), make(chan struct{})
+	defer close(done)
+	go readPackets(conn, packets, done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for open := true; open; {
+		select {
+		case p, ok := <-packets:
+			open = ok && c.handle(p)
+		case <-ticker.C:
+			if c.running {
+				c.checkStopped()
+			}
+		}
+		if c.w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// Reads the packets and sends them as $data, - (invalid checksum) or the
+// interrupt, the channel is closed when the connection is closed
+func readPackets(r io.Reader, packets chan<- string, done <-chan struct{}) {
+	defer close(packets)
+	br := bufio.NewReader(r)
+	send := func(p string) bool {
+		select {
+		case packets <- p:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		p := ""
+		switch b {
+		case 0x03:
+			p = interrupt
+		case '$':
+			data, err := br.ReadString('#')
+			if err != nil {
+				return
+			}
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(br, sum); err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			p = "$" + data
+			if cs, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(cs) != checksum(data) {
+				p = "-"
+			}
+		default:
+			// acknowledgments (+ and -) sent by the client are ignored
+			continue
+		}
+		if !send(p) {
+			return
+		}
+	}
+}
+
+// Handles the packet, returns false when the connection should be closed
+func (c *session) handle(p string) bool {
+	switch {
+	case p == interrupt:
+		if c.running {
+			c.s.d.Do(func() {
+				if !c.s.d.Paused() {
+					c.interrupted = true
+					c.s.d.Pause()
+				}
+			})
+			c.checkStopped()
+		}
+		return true
+	case p == "-":
+		c.w.WriteByte('-')
+		return true
+	}
+
+	if !c.noAck {
+		c.w.WriteByte('+')
+	}
+	data := p[1:]
+	if data == "k" {
+		return false
+	}
+	reply, ok := c.exec(data)
+	if ok {
+		c.send(reply)
+	}
+	return data != "D"
+}
+
+// Executes the command, returns the reply or false if it is sent later
+// (continue or step)
+func (c *session) exec(data string) (string, bool) {
+	if data == "" {
+		return "", true
+	}
+	cmd, args := data[0], data[1:]
+	var reply string
+	var err error
+	switch cmd {
+	case '?':
+		return "S05", true
+	case 'g':
+		c.do(func() {
+			for i := range registers {
+				reply += formatWord(c.register(i))
+			}
+		})
+	case 'G':
+		if len(args) != 4*len(registers) {
+			return "E01", true
+		}
+		c.do(func() {
+			for i := range registers {
+				if v, e := parseWord(args[4*i : 4*i+4]); e != nil {
+					err = e
+				} else {
+					c.setRegister(i, v)
+				}
+			}
+		})
+		reply = "OK"
+	case 'p':
+		n, e := strconv.ParseUint(args, 16, 8)
+		if e != nil || int(n) >= len(registers) {
+			return "E01", true
+		}
+		c.do(func() { reply = formatWord(c.register(int(n))) })
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, e := strconv.ParseUint(parts[0], 16, 8)
+		if e != nil || len(parts) != 2 || int(n) >= len(registers) {
+			return "E01", true
+		}
+		v, e := parseWord(parts[1])
+		if e != nil {
+			return "E01", true
+		}
+		c.do(func() { c.setRegister(int(n), v) })
+		reply = "OK"
+	case 'm':
+		addr, size, e := parseAddrSize(args)
+		if e != nil {
+			return "E01", true
+		}
+		c.do(func() {
+			var data []byte
+			if data, err = c.s.d.ReadMemory(-1, addr, size); err == nil {
+				reply = hex.EncodeToString(data)
+			}
+		})
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, size, e := parseAddrSize(parts[0])
+		if e != nil || len(parts) != 2 {
+			return "E01", true
+		}
+		data, e := hex.DecodeString(parts[1])
+		if e != nil || len(data) != size {
+			return "E01", true
+		}
+		c.do(func() { err = c.s.d.WriteMemory(-1, addr, data) })
+		reply = "OK"
+	case 'Z', 'z':
+		if args == "" || !strings.Contains("01234", args[:1]) {
+			return "", true // breakpoint type not supported
+		}
+		err = c.breakpoint(cmd == 'Z', args)
+		reply = "OK"
+	case 'c', 's':
+		if args != "" {
+			addr, e := strconv.ParseUint(args, 16, 16)
+			if e != nil {
+				return "E01", true
+			}
+			c.do(func() { c.s.cpu.Reg.PC = uint16(addr) })
+		}
+		c.do(func() {
+			if cmd == 's' {
+				c.s.d.Step()
+			} else {
+				c.s.d.Resume()
+			}
+		})
+		c.running, c.interrupted = true, false
+		return "", false
+	case 'D':
+		return "OK", true
+	case 'H':
+		return "OK", true
+	case 'q':
+		return c.query(args), true
+	case 'Q':
+		if args == "StartNoAckMode" {
+			c.send("OK")
+			c.noAck = true
+			return "", false
+		}
+		return "", true
+	default:
+		// not supported, e.g. vCont or X (binary memory write)
+		return "", true
+	}
+
+	if err != nil {
+		return "E01", true
+	}
+	return reply, true
+}
+
+// Replies to the general query
+func (c *session) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		return "PacketSize=1000;QStartNoAckMode+"
+	case args == "Attached":
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	}
+	return ""
+}
+
+// Adds or removes the breakpoint: 0 and 1 (PC), 2 (write), 3 (read) or 4
+// (access), in format type,addr,kind
+func (c *session) breakpoint(add bool, args string) error {
+	parts := strings.Split(args, ",")
+	if len(parts) != 3 {
+		return errors.New("Invalid breakpoint")
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return err
+	}
+	key := parts[0] + "," + parts[1]
+
+	var types []int
+	switch parts[0] {
+	case "0", "1":
+		types = []int{z80.BreakPC}
+	case "2":
+		types = []int{z80.BreakWrite}
+	case "3":
+		types = []int{z80.BreakRead}
+	case "4":
+		types = []int{z80.BreakRead, z80.BreakWrite}
+	default:
+		return fmt.Errorf("Invalid breakpoint type: %s", parts[0])
+	}
+
+	c.do(func() {
+		if !add {
+			for _, id := range c.breaks[key] {
+				c.s.cpu.RemoveBreakpoint(id)
+			}
+			delete(c.breaks, key)
+			return
+		}
+		if _, ok := c.breaks[key]; ok {
+			return
+		}
+		for _, t := range types {
+			bp := &z80.Breakpoint{Type: t, From: uint16(addr)}
+			if t != z80.BreakPC && size > 1 {
+				bp.To = uint16(addr + size - 1)
+			}
+			c.breaks[key] = append(c.breaks[key], c.s.cpu.AddBreakpoint(bp))
+		}
+	})
+	return nil
+}
+
+// Sends the stop reply once the execution (continue or step) has stopped
+func (c *session) checkStopped() {
+	var reply string
+	c.do(func() {
+		if !c.s.d.Paused() {
+			return
+		}
+		reply = "S05"
+		if c.interrupted {
+			reply = "S02"
+		} else if hit := c.s.cpu.Hit(); hit != nil {
+			switch hit.Breakpoint.Type {
+			case z80.BreakWrite:
+				reply = fmt.Sprintf("T05watch:%04x;", hit.Addr)
+			case z80.BreakRead:
+				reply = fmt.Sprintf("T05rwatch:%04x;", hit.Addr)
+			}
+		}
+	})
+	if reply != "" {
+		c.running = false
+		c.send(reply)
+	}
+}
+
+// Removes the breakpoints added by the client and resumes the execution
+func (c *session) detach() {
+	c.do(func() {
+		for _, ids := range c.breaks {
+			for _, id := range ids {
+				c.s.cpu.RemoveBreakpoint(id)
+			}
+		}
+		c.s.d.Resume()
+	})
+}
+
+// Executes the function in the emulation loop
+func (c *session) do(f func()) {
+	c.s.d.Do(f)
+}
+
+// Returns the register by gdb number
+func (c *session) register(n int) uint16 {
+	s := c.s.cpu.GetState()
+	return []uint16{s.AF, s.BC, s.DE, s.HL, s.SP, s.PC, s.IX, s.IY,
+		s.AF_, s.BC_, s.DE_, s.HL_, uint16(s.I)<<8 | uint16(s.R)}[n]
+}
+
+// Sets the register by gdb number
+func (c *session) setRegister(n int, value uint16) {
+	if registers[n] == "IR" {
+		c.s.d.SetRegister("I", value>>8)
+		c.s.d.SetRegister("R", value&0xFF)
+		return
+	}
+	c.s.d.SetRegister(registers[n], value)
+}
+
+// Sends the packet with the checksum
+func (c *session) send(data string) {
+	fmt.Fprintf(c.w, "$%s#%02x", data, checksum(data))
+}
+
+// Computes the packet checksum: sum of the data bytes modulo 256
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// Formats 16 bit value as little endian hex
+func formatWord(v uint16) string {
+	return fmt.Sprintf("%02x%02x", byte(v), byte(v>>8))
+}
+
+// Parses 16 bit little endian hex value
+func parseWord(s string) (uint16, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 2 {
+		return 0, fmt.Errorf("Invalid register value: %s", s)
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
+
+// Parses addr,length
+func parseAddrSize(s string) (uint16, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid address and length: %s", s)
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(addr), int(size), nil
+}
@@ -0,0 +1,163 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voytas/z80-go-zx/z80"
+	"github.com/voytas/z80-go-zx/z80/debugger"
+	"github.com/voytas/z80-go-zx/z80/memory"
+)
+
+// Test client reading the acknowledgments and replies
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) write(s string) {
+	_, err := c.conn.Write([]byte(s))
+	assert.Nil(c.t, err)
+}
+
+// Sends the packet, returns the reply (empty if none is expected)
+func (c *client) send(data string, reply bool) string {
+	c.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+	ack, err := c.r.ReadByte()
+	assert.Nil(c.t, err)
+	assert.Equal(c.t, byte('+'), ack, data)
+	if !reply {
+		return ""
+	}
+	return c.receive()
+}
+
+// Receives the packet and validates its checksum
+func (c *client) receive() string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.r.ReadString('$')
+	assert.Nil(c.t, err)
+	data, err := c.r.ReadString('#')
+	assert.Nil(c.t, err)
+	sum := make([]byte, 2)
+	_, err = c.r.Read(sum)
+	assert.Nil(c.t, err)
+	data = strings.TrimSuffix(data, "#")
+	assert.Equal(c.t, fmt.Sprintf("%02x", checksum(data)), string(sum))
+	return data
+}
+
+func Test_Server(t *testing.T) {
+	mem := &memory.BasicMemory{Cells: make([]byte, 0x10000)}
+	copy(mem.Cells, []byte{
+		0x31, 0x00, 0x90, // 0000: LD SP,9000
+		0x21, 0x00, 0x40, // 0003: LD HL,4000
+		0x34,       // 0006: INC (HL)
+		0x23,       // 0007: INC HL
+		0x18, 0xFC, // 0008: JR 0006
+	})
+	cpu := z80.NewZ80(mem)
+	d := debugger.New(cpu, mem)
+
+	// Emulation loop, the stopped frame is continued
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		stopped := false
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			d.Poll()
+			if d.Paused() {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if stopped {
+				cpu.Continue()
+			} else {
+				cpu.Run(1000)
+			}
+			stopped = cpu.Stopped()
+		}
+	}()
+
+	s, err := Listen("127.0.0.1:0", d)
+	assert.Nil(t, err)
+	go s.Serve()
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	assert.Equal(t, "PacketSize=1000;QStartNoAckMode+", c.send("qSupported:swbreak+", true))
+	assert.Equal(t, "S05", c.send("?", true))
+	assert.Equal(t, "", c.send("vCont?", true))
+
+	// Registers: AF BC DE HL SP PC IX IY AF' BC' DE' HL' IR
+	assert.Equal(t, "OK", c.send("P5=0000", true))
+	assert.Equal(t, "OK", c.send("P3=3412", true))
+	regs := c.send("g", true)
+	assert.Equal(t, 13*4, len(regs))
+	assert.Equal(t, "3412", regs[12:16])
+	assert.Equal(t, "0000", regs[20:24])
+	assert.Equal(t, "3412", c.send("p3", true))
+	assert.Equal(t, "E01", c.send("pd", true))
+
+	// Memory
+	assert.Equal(t, "310090", c.send("m0,3", true))
+	assert.Equal(t, "OK", c.send("M4000,2:aabb", true))
+	assert.Equal(t, []byte{0xAA, 0xBB}, mem.Cells[0x4000:0x4002])
+	assert.Equal(t, "E01", c.send("M4000,2:aa", true))
+
+	// Breakpoint and continue
+	assert.Equal(t, "OK", c.send("Z0,6,1", true))
+	c.send("c", false)
+	assert.Equal(t, "S05", c.receive())
+	assert.Equal(t, "0600", c.send("p5", true))
+	assert.Equal(t, "0040", c.send("p3", true))
+
+	// Single step
+	c.send("s", false)
+	assert.Equal(t, "S05", c.receive())
+	assert.Equal(t, "0700", c.send("p5", true))
+	assert.Equal(t, byte(0xAB), mem.Cells[0x4000])
+
+	// Write watchpoint replaces the breakpoint
+	assert.Equal(t, "OK", c.send("z0,6,1", true))
+	assert.Equal(t, "OK", c.send("Z2,4003,1", true))
+	c.send("c", false)
+	assert.Equal(t, "T05watch:4003;", c.receive())
+	assert.Equal(t, "0700", c.send("p5", true))
+	assert.Equal(t, "0340", c.send("p3", true))
+	assert.Equal(t, "OK", c.send("z2,4003,1", true))
+	assert.Equal(t, "", c.send("Z5,0,1", true))
+
+	// Interrupted by the client
+	c.send("c", false)
+	c.write("\x03")
+	assert.Equal(t, "S02", c.receive())
+	d.Do(func() {
+		assert.True(t, d.Paused())
+	})
+
+	// Detached, the execution is resumed without the breakpoints
+	assert.Equal(t, "OK", c.send("Z0,6,1", true))
+	assert.Equal(t, "OK", c.send("D", true))
+	for paused := true; paused; {
+		d.Do(func() { paused = d.Paused() })
+	}
+	d.Do(func() {
+		assert.Empty(t, cpu.Breakpoints())
+	})
+}
@@ -37,3 +37,17 @@ func (tc *TCounter) halt() {
 func (tc *TCounter) remaining() int {
 	return tc.max - tc.Current
 }
+
+// Get T states executed in the current frame. When the frame is done,
+// these are T states executed beyond its end (i.e. in the next frame).
+func (tc *TCounter) frame() int {
+	if tc.done() {
+		return tc.Current - tc.max
+	}
+	return tc.Current
+}
+
+// Set T states executed in the current frame, the next limit is reduced accordingly
+func (tc *TCounter) setFrame(t int) {
+	tc.Current, tc.max = t, 0
+}
@@ -47,3 +47,18 @@ func Test_Halt(t *testing.T) {
 	assert.EqualValues(t, 44, tc.Current)
 	assert.Equal(t, true, tc.done())
 }
+
+func Test_Frame(t *testing.T) {
+	tc := TCounter{}
+	tc.setFrame(30)
+	assert.Equal(t, 30, tc.frame())
+
+	tc.limit(100)
+	tc.Add(72)
+	assert.Equal(t, true, tc.done())
+	assert.Equal(t, 2, tc.frame())
+
+	tc.limit(100)
+	tc.Add(50)
+	assert.Equal(t, 50, tc.frame())
+}
@@ -18,8 +18,20 @@ type Z80 struct {
 	Reg              *registers    // registers
 	halt, iff1, iff2 bool          // states of halt, iff1 and iff2
 	im               byte          // interrupt mode (im0, im1 or in2)
+	eiLast           bool          // last executed instruction was EI
+	intPending       bool          // interrupt delayed after EI
+	intData          byte          // data bus value of the delayed interrupt
+	IntLength        int           // length of INT signal in T states, delayed interrupt is missed after it (0 = no limit)
 	TC               *TCounter     // T states counter
 	Trap             func()        // traps to execute on PC address
+	Break            func() bool   // called before each instruction, Run stops when it returns true
+	OnBreakpoint     func(*Hit)    // called when Run is stopped by the breakpoint
+	stopped          bool          // Run was stopped by Break or breakpoint before reaching the limit
+	stoppedAtPC      bool          // stopped by Break or PC breakpoint, not by the previous instruction
+	breakList        []*Breakpoint // all breakpoints
+	breaks           *breakpoints  // enabled breakpoints, nil if there are none
+	hit              *Hit          // breakpoint hit that stopped Run
+	nextBreakID      int
 }
 
 // Creates a new instance of the Z80 emulator.
@@ -57,6 +69,9 @@ func (z80 *Z80) nextWord() uint16 {
 func (z80 *Z80) read(addr uint16) byte {
 	b := z80.mem.Read(addr)
 	z80.TC.Add(3)
+	if z80.breaks != nil && z80.breaks.read {
+		z80.watch(BreakRead, addr, b)
+	}
 	return b
 }
 
@@ -64,6 +79,9 @@ func (z80 *Z80) read(addr uint16) byte {
 func (z80 *Z80) write(addr uint16, value byte) {
 	z80.mem.Write(addr, value)
 	z80.TC.Add(3)
+	if z80.breaks != nil && z80.breaks.write {
+		z80.watch(BreakWrite, addr, value)
+	}
 }
 
 // Reads 8 bit value from the bus (IN port). The cost is 4T.
@@ -74,11 +92,17 @@ func (z80 *Z80) readBus(hi, lo byte) byte {
 	} else {
 		z80.TC.Add(4)
 	}
+	if z80.breaks != nil && z80.breaks.in {
+		z80.watch(BreakIn, uint16(hi)<<8|uint16(lo), b)
+	}
 	return b
 }
 
 // Writes 8 bit value to the bus (OUT port). The cost is 4T.
 func (z80 *Z80) writeBus(hi, lo, data byte) {
+	if z80.breaks != nil && z80.breaks.out {
+		z80.watch(BreakOut, uint16(hi)<<8|uint16(lo), data)
+	}
 	if z80.IOBus != nil {
 		z80.IOBus.Write(hi, lo, data)
 	} else {
@@ -94,13 +118,20 @@ func (z80 *Z80) pushPC() {
 	z80.write(z80.Reg.SP, byte(z80.Reg.PC))
 }
 
-// Emulates maskable interrupt (INT)
+// Emulates maskable interrupt (INT). Interrupt is not accepted immediately
+// after EI, but after the next instruction has been executed.
 func (z80 *Z80) INT(data byte) {
+	if z80.eiLast {
+		z80.intPending, z80.intData = true, data
+		return
+	}
+	z80.intPending = false
 	z80.halt = false
 	if !z80.iff1 {
 		return
 	}
 	z80.iff1, z80.iff2 = false, false
+	pc := z80.Reg.PC
 	switch z80.im {
 	case 0, 1:
 		z80.pushPC()
@@ -109,20 +140,32 @@ func (z80 *Z80) INT(data byte) {
 	case 2:
 		z80.pushPC()
 		addr := uint16(z80.Reg.I)<<8 + uint16(data)
-		z80.Reg.PC = uint16(z80.read(addr+1))<<8 | uint16(z80.read(addr))
-		z80.TC.Add(7)
+		// The vector is not a data read, so read breakpoints are not checked
+		hi := z80.mem.Read(addr + 1)
+		lo := z80.mem.Read(addr)
+		z80.Reg.PC = uint16(hi)<<8 | uint16(lo)
+		z80.TC.Add(13)
 	}
 	z80.Reg.IncR()
+	if z80.breaks != nil && z80.breaks.interrupt {
+		z80.breaks.instrPC = pc
+		z80.watch(BreakINT, z80.Reg.PC, 0)
+	}
 }
 
 // Emulates non-maskable interrupt (NMI)
 func (z80 *Z80) NMI() {
 	z80.halt = false
 	z80.iff2, z80.iff1 = z80.iff1, false
+	pc := z80.Reg.PC
 	z80.pushPC()
 	z80.Reg.PC = 0x66
 	z80.TC.Add(5)
 	z80.Reg.IncR()
+	if z80.breaks != nil && z80.breaks.nmi {
+		z80.breaks.instrPC = pc
+		z80.watch(BreakNMI, z80.Reg.PC, 0)
+	}
 }
 
 func (z80 *Z80) Reset() {
@@ -140,7 +183,45 @@ func (z80 *Z80) Reset() {
 // (limit equal to 0 specifies unlimited number of T states to execute)
 func (z80 *Z80) Run(limit int) {
 	z80.TC.limit(limit)
+	z80.run()
+}
+
+// Continues the execution stopped by Break or breakpoint until the limit
+// specified by the last Run is reached. Break is called again for the current
+// instruction, the breakpoint it stopped at is not hit again.
+func (z80 *Z80) Continue() {
+	z80.run()
+}
+
+// Checks whether the last Run or Continue was stopped by Break or breakpoint
+func (z80 *Z80) Stopped() bool {
+	return z80.stopped
+}
+
+func (z80 *Z80) run() {
+	// Resuming at the address it stopped at does not hit the same breakpoint
+	resume, pc := z80.stoppedAtPC, z80.Reg.PC
+	z80.stopped, z80.stoppedAtPC, z80.hit = false, false, nil
 	for !(z80.Reg.prefix == noPrefix && z80.TC.done()) {
+		if z80.Reg.prefix == noPrefix {
+			// Hit within the last instruction is reported first, PC breakpoints
+			// are not checked while Break keeps the CPU stopped
+			if z80.breaks != nil && z80.breaks.pending != nil {
+				z80.breakpointHit(z80.breaks.pending)
+				return
+			}
+			if z80.Break != nil && z80.Break() {
+				z80.stopped, z80.stoppedAtPC = true, true
+				return
+			}
+			if z80.breaks != nil {
+				if hit := z80.checkBreakpoints(resume && z80.Reg.PC == pc); hit != nil {
+					z80.breakpointHit(hit)
+					return
+				}
+			}
+			resume = false
+		}
 		if z80.Trap != nil {
 			z80.Trap()
 		}
@@ -154,6 +235,7 @@ func (z80 *Z80) Run(limit int) {
 		}
 
 		z80.Reg.IncR()
+		z80.eiLast = opcode == ei
 
 		switch opcode {
 		case nop:
@@ -760,6 +842,15 @@ func (z80 *Z80) Run(limit int) {
 			continue
 		}
 		z80.Reg.prefix = noPrefix
+
+		if z80.intPending && !z80.eiLast {
+			if z80.IntLength == 0 || z80.TC.Current < z80.IntLength {
+				z80.INT(z80.intData)
+			} else {
+				// INT signal is no longer active
+				z80.intPending = false
+			}
+		}
 		//log.Println(fmt.Sprintf("OP: %X T: %v", opcode, z80.TC.Current))
 	}
 }
@@ -2837,3 +2837,89 @@ func Test_INT(t *testing.T) {
 	assert.Equal(t, false, z80.iff1)
 	assert.Equal(t, false, z80.iff2)
 }
+
+func Test_INT_AfterEI(t *testing.T) {
+	mem := &memory.BasicMemory{Cells: []byte{ei, nop, nop, 0x12: 0x00}}
+	z80 := NewZ80(mem)
+	z80.Reg.SP = 0x12
+	z80.im = 1
+
+	z80.Run(4)
+	z80.INT(0)
+	assert.Equal(t, uint16(0x01), z80.Reg.PC)
+	assert.Equal(t, uint16(0x12), z80.Reg.SP)
+
+	// Interrupt is accepted after the instruction following EI
+	z80.Run(4)
+	assert.Equal(t, uint16(0x38), z80.Reg.PC)
+	assert.Equal(t, uint16(0x10), z80.Reg.SP)
+	assert.Equal(t, byte(0x02), mem.Read(0x10))
+	assert.Equal(t, false, z80.iff1)
+	assert.Equal(t, false, z80.intPending)
+}
+
+func Test_INT_AfterEIState(t *testing.T) {
+	mem := &memory.BasicMemory{Cells: []byte{ei, nop, nop, 0x12: 0x00}}
+	z80 := NewZ80(mem)
+
+	// State restored right after EI (e.g. SZX snapshot) delays the interrupt
+	z80.State(&CPUState{PC: 0x01, SP: 0x12, IM: 1, IFF1: true, IFF2: true, EILast: true})
+	z80.INT(0)
+	assert.Equal(t, uint16(0x01), z80.Reg.PC)
+	assert.Equal(t, uint16(0x12), z80.Reg.SP)
+	assert.Equal(t, true, z80.intPending)
+
+	z80.Run(4)
+	assert.Equal(t, uint16(0x38), z80.Reg.PC)
+	assert.Equal(t, byte(0x02), mem.Read(0x10))
+	assert.Equal(t, false, z80.GetState().EILast)
+}
+
+func Test_INT_Length(t *testing.T) {
+	mem := &memory.BasicMemory{Cells: []byte{ei, ei, 0x08: nop, 0x12: 0x00}}
+	for i := 2; i < 8; i++ {
+		mem.Cells[i] = ei
+	}
+	z80 := NewZ80(mem)
+	z80.Reg.SP = 0x12
+	z80.im = 1
+	z80.IntLength = 32
+
+	// Interrupt delayed by EI instructions is missed once INT signal ends
+	z80.Run(4)
+	z80.INT(0)
+	z80.Run(32)
+	assert.Equal(t, uint16(0x09), z80.Reg.PC)
+	assert.Equal(t, uint16(0x12), z80.Reg.SP)
+	assert.Equal(t, false, z80.intPending)
+
+	// Accepted within INT signal
+	z80.Reg.PC = 0x07
+	z80.Run(4)
+	z80.INT(0)
+	z80.Run(4)
+	assert.Equal(t, uint16(0x38), z80.Reg.PC)
+	assert.Equal(t, uint16(0x10), z80.Reg.SP)
+}
+
+func Test_Break(t *testing.T) {
+	mem := &memory.BasicMemory{Cells: []byte{nop, useIX, inc_a, nop, nop, nop}}
+	z80 := NewZ80(mem)
+	var calls []uint16
+	z80.Break = func() bool {
+		calls = append(calls, z80.Reg.PC)
+		return z80.Reg.PC == 0x03 && len(calls) == 3
+	}
+
+	// Not called for the prefixed opcode
+	z80.Run(20)
+	assert.True(t, z80.Stopped())
+	assert.Equal(t, []uint16{0x00, 0x01, 0x03}, calls)
+	assert.Equal(t, 4+4+4, z80.TC.Current)
+
+	// Continues to the limit of the last Run
+	z80.Continue()
+	assert.False(t, z80.Stopped())
+	assert.Equal(t, uint16(0x05), z80.Reg.PC)
+	assert.Equal(t, 20, z80.TC.Current)
+}